@@ -0,0 +1,30 @@
+// Package echoadapter serves controller views from an Echo router.
+package echoadapter
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/goliveview/controller"
+)
+
+// Handler converts a handler returned by the controller to an echo.HandlerFunc:
+//
+//	e.GET("/todos/:id", echoadapter.Handler(glvc.Handler(&TodosView{})))
+//
+// The path parameters can be read in the view using controller.RouteParam(ctx.Context(), "id").
+func Handler(h http.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		params := make(map[string]string)
+		values := c.ParamValues()
+		for i, name := range c.ParamNames() {
+			if i < len(values) {
+				params[name] = values[i]
+			}
+		}
+		// echo.Response implements http.Hijacker for the websocket upgrade
+		h(c.Response(), controller.WithRouteParams(c.Request(), params))
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+// Package fiberadapter serves controller views from a Fiber app.
+//
+// fasthttp connections can't be hijacked through the net/http adaptor, so the websocket connections
+// are upgraded by fasthttp and their messages are handled by a controller.RemoteView. The live connections
+// then differ from the ones of the net/http handlers:
+//
+//   - the middleware of controller.WithMiddleware wraps the mount only, not the websocket upgrade;
+//   - the LiveEventReceiver of the view isn't supported, see controller.RemoteView;
+//   - the connections aren't pinged, controller.WithHeartbeat doesn't apply to them.
+package fiberadapter
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lithammer/shortuuid"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"github.com/goliveview/controller"
+)
+
+// Handler returns a fiber.Handler which mounts view and serves its websocket connections:
+//
+//	app.Get("/todos/:id", fiberadapter.Handler(glvc, &TodosView{}, websocket.FastHTTPUpgrader{}))
+//
+// The path parameters can be read in the view using controller.RouteParam(ctx.Context(), "id").
+func Handler(c controller.Controller, view controller.View, upgrader websocket.FastHTTPUpgrader) fiber.Handler {
+	mount := c.Handler(view)
+	remote := c.Remote(view)
+	return func(fc *fiber.Ctx) error {
+		params := fc.AllParams()
+		if !websocket.FastHTTPIsWebSocketUpgrade(fc.Context()) {
+			fasthttpadaptor.NewFastHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mount(w, controller.WithRouteParams(r, params))
+			}))(fc.Context())
+			return nil
+		}
+
+		r, err := httpRequest(fc.Context())
+		if err != nil {
+			return err
+		}
+		r = controller.WithRouteParams(r, params)
+		return upgrader.Upgrade(fc.Context(), func(ws *websocket.Conn) {
+			serve(remote, r, ws)
+		})
+	}
+}
+
+// httpRequest copies the upgrade request since the fasthttp request is released once hijacked.
+func httpRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+	r, err := http.NewRequest(string(ctx.Method()), string(ctx.Request.URI().FullURI()), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		r.Header.Add(string(key), string(value))
+	})
+	r.Host = string(ctx.Host())
+	r.RemoteAddr = ctx.RemoteAddr().String()
+	return r, nil
+}
+
+// conn serializes the writes of the broadcasts: the websocket supports one concurrent writer.
+type conn struct {
+	*websocket.Conn
+	writeMu *sync.Mutex
+}
+
+func (c conn) Send(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.WriteMessage(websocket.TextMessage, message)
+}
+
+func serve(remote controller.RemoteView, r *http.Request, ws *websocket.Conn) {
+	defer ws.Close()
+	connID := shortuuid.New()
+	c := conn{Conn: ws, writeMu: &sync.Mutex{}}
+	if err := remote.Connect(r, connID, c); err != nil {
+		remote.Logger().Warn("rejecting websocket connection", "conn", connID, "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""))
+		return
+	}
+	// registered first to run once the connection is removed from its topic
+	defer remote.Disconnect(r, connID)
+	topic := remote.Topic(r)
+	if topic != "" {
+		remote.AddConnection(topic, connID, c)
+		defer remote.RemoveConnection(topic, connID)
+	}
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			remote.Logger().Debug("reading websocket message", "conn", connID, "err", err)
+			return
+		}
+		remote.HandleMessage(r, connID, c, message)
+	}
+}
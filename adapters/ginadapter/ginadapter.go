@@ -0,0 +1,26 @@
+// Package ginadapter serves controller views from a Gin router.
+package ginadapter
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/goliveview/controller"
+)
+
+// Handler converts a handler returned by the controller to a gin.HandlerFunc:
+//
+//	r.GET("/todos/:id", ginadapter.Handler(glvc.Handler(&TodosView{})))
+//
+// The path parameters can be read in the view using controller.RouteParam(ctx.Context(), "id").
+func Handler(h http.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := make(map[string]string)
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+		}
+		// gin.ResponseWriter implements http.Hijacker for the websocket upgrade
+		h(c.Writer, controller.WithRouteParams(c.Request, params))
+	}
+}
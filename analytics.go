@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"time"
+)
+
+// AnalyticsEvent describes a mount or a handled event. It's passed to the hook set with WithAnalytics.
+type AnalyticsEvent struct {
+	// Kind is either "mount" or "event".
+	Kind    string
+	View    string
+	User    int
+	Topic   string
+	EventID string
+	// Status is the status code returned by OnMount.
+	Status   int
+	Err      error
+	Time     time.Time
+	Duration time.Duration
+}
+
+// WithAnalytics calls hook after each mount and handled event. It's called synchronously
+// so it should hand the event off e.g. to a buffered channel.
+func WithAnalytics(hook func(e AnalyticsEvent)) Option {
+	return func(o *controlOpt) {
+		o.analytics = hook
+	}
+}
+
+func (wc *websocketController) track(e AnalyticsEvent, start time.Time) {
+	if wc.analytics == nil {
+		return
+	}
+	e.Time = start
+	e.Duration = time.Since(start)
+	wc.analytics(e)
+}
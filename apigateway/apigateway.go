@@ -0,0 +1,260 @@
+// Package apigateway runs controller views behind an AWS API Gateway WebSocket API, with the
+// $connect, $disconnect and message routes integrated to a Lambda function.
+//
+// The Lambda instances don't share memory so the connections are kept in a shared Registry, and
+// the operations are sent to the clients through the API Gateway management API by a Poster. The
+// user of a connection must be the same on every instance, so the controller is configured with
+// controller.WithSessionKeys or controller.WithAuth:
+//
+//	glvc := controller.Websocket("app", controller.WithSessionKeys(hashKey, blockKey))
+//	rv := glvc.Remote(&TodosView{})
+//	gw := apigateway.New(rv, redis.NewRegistry(client), apigateway.PosterFunc(
+//		func(ctx context.Context, connID string, data []byte) error {
+//			_, err := api.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+//				ConnectionId: &connID,
+//				Data:         data,
+//			})
+//			var gone *types.GoneException
+//			if errors.As(err, &gone) {
+//				return apigateway.ErrGone
+//			}
+//			return err
+//		}))
+//	lambda.Start(gw.Handle)
+package apigateway
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/goliveview/controller"
+)
+
+// ErrGone is returned by a Poster when the connection is closed.
+var ErrGone = errors.New("connection is gone")
+
+// Registry keeps track of the open connections across the Lambda instances.
+type Registry interface {
+	// Add registers the connection connID subscribed to topic. header is the header of the request
+	// which opened the connection.
+	Add(ctx context.Context, connID, topic string, header http.Header) error
+	// Get returns the topic and the request header of the connection connID.
+	Get(ctx context.Context, connID string) (string, http.Header, error)
+	// Remove unregisters the connection connID.
+	Remove(ctx context.Context, connID string) error
+	// Connections returns the ids of the connections subscribed to topic.
+	Connections(ctx context.Context, topic string) ([]string, error)
+}
+
+// Poster sends data to a client connection, typically using the API Gateway management API.
+// It returns ErrGone if the connection is closed.
+type Poster interface {
+	PostToConnection(ctx context.Context, connID string, data []byte) error
+}
+
+type PosterFunc func(ctx context.Context, connID string, data []byte) error
+
+func (f PosterFunc) PostToConnection(ctx context.Context, connID string, data []byte) error {
+	return f(ctx, connID, data)
+}
+
+// ErrRejected is the error of a connection rejected by RemoteView.Connect, answered with 403 Forbidden.
+var ErrRejected = errors.New("connection rejected")
+
+// Request is the event received by the Lambda function. It has the same JSON encoding as
+// events.APIGatewayWebsocketProxyRequest.
+type Request struct {
+	Headers               map[string]string   `json:"headers"`
+	MultiValueHeaders     map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters map[string]string   `json:"queryStringParameters"`
+	RequestContext        RequestContext      `json:"requestContext"`
+	Body                  string              `json:"body"`
+	IsBase64Encoded       bool                `json:"isBase64Encoded,omitempty"`
+}
+
+type RequestContext struct {
+	RouteKey     string `json:"routeKey"`
+	EventType    string `json:"eventType"`
+	ConnectionID string `json:"connectionId"`
+	DomainName   string `json:"domainName"`
+	Stage        string `json:"stage"`
+}
+
+// Response is the response of the Lambda function. It has the same JSON encoding as
+// events.APIGatewayProxyResponse.
+type Response struct {
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body,omitempty"`
+}
+
+// Gateway maps the API Gateway events onto a controller.RemoteView.
+type Gateway struct {
+	view     controller.RemoteView
+	registry Registry
+	poster   Poster
+	logger   controller.Logger
+}
+
+// Option configures a Gateway.
+type Option func(g *Gateway)
+
+// WithLogger sends the logs of the gateway to logger instead of the Logger of the controller.
+func WithLogger(logger controller.Logger) Option {
+	return func(g *Gateway) {
+		g.logger = logger
+	}
+}
+
+func New(view controller.RemoteView, registry Registry, poster Poster, opts ...Option) *Gateway {
+	g := &Gateway{view: view, registry: registry, poster: poster, logger: view.Logger()}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Handle is the Lambda handler of the $connect, $disconnect and message routes.
+func (g *Gateway) Handle(ctx context.Context, req Request) (Response, error) {
+	connID := req.RequestContext.ConnectionID
+	var err error
+	switch req.RequestContext.EventType {
+	case "CONNECT":
+		err = g.connect(ctx, connID, req)
+	case "DISCONNECT":
+		err = g.disconnect(ctx, connID, req)
+	case "MESSAGE":
+		err = g.message(ctx, connID, req)
+	default:
+		err = fmt.Errorf("unknown event type %q", req.RequestContext.EventType)
+	}
+	if errors.Is(err, ErrRejected) {
+		g.logger.Warn("apigateway: connection rejected", "route", req.RequestContext.RouteKey, "conn", connID, "err", err)
+		return Response{StatusCode: http.StatusForbidden}, nil
+	}
+	if err != nil {
+		g.logger.Error("apigateway: handling event", "route", req.RequestContext.RouteKey, "conn", connID, "err", err)
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	return Response{StatusCode: http.StatusOK}, nil
+}
+
+func (g *Gateway) connect(ctx context.Context, connID string, req Request) error {
+	r, err := httpRequest(ctx, req.RequestContext, requestHeader(req))
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	for k, v := range req.QueryStringParameters {
+		query.Set(k, v)
+	}
+	r.URL.RawQuery = query.Encode()
+	if err := g.view.Connect(r, connID, g.conn(ctx, connID)); err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return g.registry.Add(ctx, connID, g.view.Topic(r), r.Header)
+}
+
+func (g *Gateway) disconnect(ctx context.Context, connID string, req Request) error {
+	var r *http.Request
+	if _, header, err := g.registry.Get(ctx, connID); err == nil {
+		r, _ = httpRequest(ctx, req.RequestContext, header)
+	}
+	g.view.Disconnect(r, connID)
+	return g.registry.Remove(ctx, connID)
+}
+
+func (g *Gateway) message(ctx context.Context, connID string, req Request) error {
+	topic, header, err := g.registry.Get(ctx, connID)
+	if err != nil {
+		return err
+	}
+	r, err := httpRequest(ctx, req.RequestContext, header)
+	if err != nil {
+		return err
+	}
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	conn := g.conn(ctx, connID)
+	if topic != "" {
+		// make the other connections of the topic receive the broadcasts
+		connIDs, err := g.registry.Connections(ctx, topic)
+		if err != nil {
+			return err
+		}
+		for _, id := range connIDs {
+			c := conn
+			if id != connID {
+				c = g.conn(ctx, id)
+			}
+			g.view.AddConnection(topic, id, c)
+		}
+		defer func() {
+			for _, id := range connIDs {
+				g.view.RemoveConnection(topic, id)
+			}
+		}()
+	}
+
+	g.view.HandleMessage(r, connID, conn, body)
+	return nil
+}
+
+func (g *Gateway) conn(ctx context.Context, connID string) *remoteConn {
+	return &remoteConn{ctx: ctx, connID: connID, poster: g.poster, registry: g.registry, logger: g.logger}
+}
+
+// remoteConn implements controller.Conn for an API Gateway connection.
+type remoteConn struct {
+	ctx      context.Context
+	connID   string
+	poster   Poster
+	registry Registry
+	logger   controller.Logger
+}
+
+func (c *remoteConn) Send(message []byte) error {
+	err := c.poster.PostToConnection(c.ctx, c.connID, message)
+	if errors.Is(err, ErrGone) {
+		if err := c.registry.Remove(c.ctx, c.connID); err != nil {
+			c.logger.Error("apigateway: removing gone connection", "conn", c.connID, "err", err)
+		}
+	}
+	return err
+}
+
+// Close is a no-op, the connection is closed by API Gateway.
+func (c *remoteConn) Close() error {
+	return nil
+}
+
+func requestHeader(req Request) http.Header {
+	header := make(http.Header)
+	for k, v := range req.Headers {
+		header.Set(k, v)
+	}
+	for k, vs := range req.MultiValueHeaders {
+		header.Del(k)
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	return header
+}
+
+func httpRequest(ctx context.Context, rc RequestContext, header http.Header) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/%s", rc.DomainName, rc.Stage), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header = header.Clone()
+	return r, nil
+}
@@ -0,0 +1,60 @@
+package apigateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+type connection struct {
+	topic  string
+	header http.Header
+}
+
+type inmemRegistry struct {
+	conns map[string]connection
+	sync.Mutex
+}
+
+// NewRegistry returns an in-memory Registry. It's only shared by the invocations of a single Lambda instance
+// and is meant for local development.
+func NewRegistry() Registry {
+	return &inmemRegistry{conns: make(map[string]connection)}
+}
+
+func (r *inmemRegistry) Add(_ context.Context, connID, topic string, header http.Header) error {
+	r.Lock()
+	defer r.Unlock()
+	r.conns[connID] = connection{topic: topic, header: header.Clone()}
+	return nil
+}
+
+func (r *inmemRegistry) Get(_ context.Context, connID string) (string, http.Header, error) {
+	r.Lock()
+	defer r.Unlock()
+	c, ok := r.conns[connID]
+	if !ok {
+		return "", nil, fmt.Errorf("conn %s is not registered", connID)
+	}
+	return c.topic, c.header.Clone(), nil
+}
+
+func (r *inmemRegistry) Remove(_ context.Context, connID string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.conns, connID)
+	return nil
+}
+
+func (r *inmemRegistry) Connections(_ context.Context, topic string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	var connIDs []string
+	for id, c := range r.conns {
+		if c.topic == topic {
+			connIDs = append(connIDs, id)
+		}
+	}
+	return connIDs, nil
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+)
+
+// UserID identifies a user. It's the user passed to the stores, the topic strategies and the flag providers.
+type UserID = int
+
+var (
+	// ErrUnauthorized rejects a request with 401 Unauthorized e.g. from the WithAuth func of an anonymous user.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden rejects a request with 403 Forbidden e.g. from OnAuthorize if the user can't see the view.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authorizer is implemented by views which restrict who can mount them and open their live connections.
+type Authorizer interface {
+	// OnAuthorize is called before the view is mounted and before its live connections are upgraded, with the
+	// user authenticated by WithAuth in ctx.User(). A non nil error rejects the request, see Reject.
+	OnAuthorize(ctx Context) error
+}
+
+// WithAuth authenticates the users with auth instead of giving each browser a new id kept in a cookie. auth is
+// called for every request to mount a view and every live connection. An error rejects the request, see Reject.
+func WithAuth(auth func(r *http.Request) (UserID, error)) Option {
+	return func(o *controlOpt) {
+		o.auth = auth
+	}
+}
+
+// AuthError is an error of WithAuth or OnAuthorize carrying the Status of the rejected request.
+type AuthError struct {
+	Status Status
+	Err    error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Status.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Reject returns an error which rejects the request with status, e.g. Reject(Status{Redirect: "/login"}) to send
+// the user to the login page or Reject(Status{Code: 402, Message: "Upgrade your plan"}) to render the error view.
+// A live connection redirected is upgraded to send a redirect operation, then closed.
+func Reject(status Status) error {
+	return &AuthError{Status: status}
+}
+
+// authStatus returns the status of the response rejecting a request with err. ErrUnauthorized and ErrForbidden
+// answer 401 and 403, the other errors 500.
+func authStatus(err error) Status {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		status := authErr.Status
+		if status.Code == 0 {
+			status.Code = http.StatusForbidden
+		}
+		if status.Message == "" {
+			status.Message = http.StatusText(status.Code)
+		}
+		return status
+	}
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		code = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		code = http.StatusForbidden
+	}
+	return Status{Code: code, Message: http.StatusText(code)}
+}
+
+// authenticate returns the user authenticated by WithAuth. ok is false without WithAuth.
+func (wc *websocketController) authenticate(r *http.Request) (user UserID, ok bool, err error) {
+	if wc.auth == nil {
+		return 0, false, nil
+	}
+	user, err = wc.auth(r)
+	if err != nil {
+		return -1, true, err
+	}
+	return user, true, nil
+}
+
+// reject answers a request which can't be authenticated or authorized with err. A live connection redirected is
+// upgraded to send the redirect operation.
+func (wc *websocketController) reject(w http.ResponseWriter, r *http.Request, err error) {
+	status := authStatus(err)
+	wc.logger.Warn("rejecting request", "method", r.Method, "path", r.URL.Path, "status", status.Code, "err", err)
+	if IsUpgrade(r) {
+		if status.Redirect != "" {
+			wc.redirectLive(w, r, status.Redirect)
+			return
+		}
+		http.Error(w, status.Message, status.Code)
+		return
+	}
+	if IsEventStream(r) {
+		http.Error(w, status.Message, status.Code)
+		return
+	}
+	if status.apply(w, r) {
+		return
+	}
+	http.Error(w, status.Message, status.Code)
+}
+
+// redirectLive upgrades the connection to send it a redirect operation to u, then closes it.
+func (wc *websocketController) redirectLive(w http.ResponseWriter, r *http.Request, u string) {
+	c, err := wc.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	m, err := NewRedirect(u)
+	if err != nil {
+		wc.logger.Error("redirecting live connection", "err", err)
+		return
+	}
+	wc.messageConn(newWSConn(c), m.encode(wc.logger))
+}
+
+// authorize calls the OnAuthorize hook of the view. It answers the request and returns false if it's rejected:
+// the mount renders the error view with the status of the error.
+func (v *viewHandler) authorize(ctx Context, w http.ResponseWriter, r *http.Request) bool {
+	a, ok := unwrapFragment(v.view).(Authorizer)
+	if !ok {
+		return true
+	}
+	err := a.OnAuthorize(ctx)
+	if err == nil {
+		return true
+	}
+	if IsUpgrade(r) || IsEventStream(r) {
+		v.wc.reject(w, r, err)
+		return false
+	}
+	status := authStatus(err)
+	v.wc.logger.Warn("rejecting mount", "path", r.URL.Path, "user", v.user, "status", status.Code, "err", err)
+	if status.apply(w, r) {
+		return false
+	}
+	w.WriteHeader(status.Code)
+	onMountError(ctx, w, v, &status)
+	return false
+}
+
+// authorizeLive calls the OnAuthorize hook of the view before its live connection is upgraded.
+func (v *viewHandler) authorizeLive(w http.ResponseWriter, r *http.Request) bool {
+	if err := v.authorizeConn(w, r); err != nil {
+		v.wc.reject(w, r, err)
+		return false
+	}
+	return true
+}
+
+// authorizeConn returns the error of the OnAuthorize hook of the view for the live connection opened with r.
+func (v *viewHandler) authorizeConn(w http.ResponseWriter, r *http.Request) error {
+	a, ok := unwrapFragment(v.view).(Authorizer)
+	if !ok {
+		return nil
+	}
+	v.reloadTemplates()
+	topic := ""
+	if t, _ := v.topic(r); t != nil {
+		topic = *t
+	}
+	locale, country := v.wc.localeHints(r)
+	ctx := sessionContext{
+		dom: &dom{
+			topic:          topic,
+			wc:             v.wc,
+			store:          v.userStore(r),
+			rootTemplate:   v.viewTemplate,
+			selectorPrefix: v.selectorPrefix(),
+			classScope:     v.classScope(),
+			variants:       v.variants,
+			flags:          v.flags(r),
+		},
+		topicStore: v.wc.topicStores.get(topic),
+		variants:   v.variants,
+		user:       v.user,
+		locale:     locale,
+		country:    country,
+		event:      Event{ID: "onAuthorize"},
+		meta:       &pageMeta{},
+		base:       v.wc.requestContext(r),
+		w:          w,
+		r:          r,
+	}
+	return a.OnAuthorize(ctx)
+}
+
+// authorizeNavigation calls the OnAuthorize hook of view, the view of the route navigated to with r. A rejected
+// navigation redirects the client: to the redirect of the status, else to the url so that the mount renders the
+// error view.
+func (v *viewHandler) authorizeNavigation(sessCtx *sessionContext, view View, r *http.Request) bool {
+	a, ok := unwrapFragment(view).(Authorizer)
+	if !ok {
+		return true
+	}
+	ctx := *sessCtx
+	ctx.r = r
+	ctx.event = Event{ID: "onAuthorize"}
+	err := a.OnAuthorize(ctx)
+	if err == nil {
+		return true
+	}
+	status := authStatus(err)
+	v.wc.logger.Warn("rejecting navigation", "path", r.URL.Path, "user", v.user, "status", status.Code, "err", err)
+	u := status.Redirect
+	if u == "" {
+		u = r.URL.RequestURI()
+	}
+	sessCtx.dom.Self().Redirect(u)
+	return false
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"encoding/json"
+)
+
+// EnableBatching accumulates the operations sent while an event is handled and sends them to the topic as a single
+// json array once the handler returns, so that the client applies them at once without flicker. A batch of one
+// operation is sent as is. DOM.Flush sends the operations accumulated so far e.g. before a slow call. The sensitive
+// and fragment cached operations aren't batched: they flush the batch and are sent on their own.
+func EnableBatching() Option {
+	return func(o *controlOpt) {
+		o.batchOps = true
+	}
+}
+
+// beginBatch starts accumulating the operations of an event.
+func (d *dom) beginBatch() {
+	if !d.wc.batchOps {
+		return
+	}
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+	d.batching = true
+}
+
+// batched adds m to the batch. It returns false if m must be sent on its own.
+func (d *dom) batched(m *Operation) bool {
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+	if !d.batching {
+		return false
+	}
+	if m.Hash != "" || (m.Hints != nil && m.Hints.Sensitive) {
+		d.flushLocked()
+		return false
+	}
+	d.batch = append(d.batch, m)
+	return true
+}
+
+// Flush sends the operations accumulated in the current batch. It's a no-op without EnableBatching.
+func (d *dom) Flush() {
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+	d.flushLocked()
+}
+
+// endBatch sends the batch of the event and stops batching.
+func (d *dom) endBatch() {
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+	d.flushLocked()
+	d.batching = false
+}
+
+func (d *dom) flushLocked() {
+	if len(d.batch) == 0 {
+		return
+	}
+	batch := d.batch
+	d.batch = nil
+	if d.wc.coalescer != nil {
+		d.wc.coalescer.flush(d.topic)
+	}
+	if len(batch) == 1 {
+		d.wc.broadcast(d.topic, batch[0])
+		return
+	}
+	message, err := json.Marshal(batch)
+	if err != nil {
+		d.wc.logger.Error("marshaling batch", "topic", d.topic, "err", err)
+		return
+	}
+	d.wc.publish(d.topic, message)
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// allTopics is the broker topic of the messages sent to all the connections e.g. maintenance and reload.
+const allTopics = "glv:all"
+
+// Broker relays the operations broadcast to a topic between the controller instances so that a broadcast reaches
+// the connections of the topic on every instance behind a load balancer. All the operations go through the broker,
+// including the ones for the connections of the local instance. A shared implementation e.g. redis.NewBroker
+// can be configured using WithBroker.
+type Broker interface {
+	// Publish sends message to the subscribers of topic on all the instances, this one included.
+	Publish(topic string, message []byte) error
+	// Subscribe calls handler with the messages published to topic until the returned unsubscribe func is called.
+	Subscribe(topic string, handler func(message []byte)) (unsubscribe func(), err error)
+}
+
+// WithBroker configures the Broker the operations are broadcast through. Defaults to an in-process broker which
+// only reaches the connections of this instance.
+func WithBroker(broker Broker) Option {
+	return func(o *controlOpt) {
+		o.broker = broker
+	}
+}
+
+type inmemSubscriber struct {
+	handler func(message []byte)
+}
+
+type inmemBroker struct {
+	topics map[string]map[*inmemSubscriber]struct{}
+	sync.Mutex
+}
+
+func newInmemBroker() *inmemBroker {
+	return &inmemBroker{topics: make(map[string]map[*inmemSubscriber]struct{})}
+}
+
+func (b *inmemBroker) Publish(topic string, message []byte) error {
+	b.Lock()
+	subscribers := make([]*inmemSubscriber, 0, len(b.topics[topic]))
+	for s := range b.topics[topic] {
+		subscribers = append(subscribers, s)
+	}
+	b.Unlock()
+	// the handlers run without the broker locked: they can subscribe or unsubscribe
+	for _, s := range subscribers {
+		s.handler(message)
+	}
+	return nil
+}
+
+func (b *inmemBroker) Subscribe(topic string, handler func(message []byte)) (func(), error) {
+	b.Lock()
+	defer b.Unlock()
+	s := &inmemSubscriber{handler: handler}
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make(map[*inmemSubscriber]struct{})
+	}
+	b.topics[topic][s] = struct{}{}
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+		delete(b.topics[topic], s)
+		if len(b.topics[topic]) == 0 {
+			delete(b.topics, topic)
+		}
+	}, nil
+}
+
+// subscriptions are the broker subscriptions of the topics with local connections.
+type subscriptions map[string]func()
+
+// syncSubscription subscribes the controller to topic when it has its first local connection and unsubscribes it
+// when its last one is gone. It's called once the connections of topic are changed, with the controller unlocked
+// since the broker may wait for the network. The calls of a topic are serialized so that the last one sees the
+// latest connections.
+func (wc *websocketController) syncSubscription(topic string) {
+	defer wc.subscribeLocks.lock(topic)()
+	wc.RLock()
+	unsubscribe, subscribed := wc.subscriptions[topic]
+	_, connected := wc.topicConnections[topic]
+	wc.RUnlock()
+	switch {
+	case connected && !subscribed:
+		unsubscribe, err := wc.broker.Subscribe(topic, func(message []byte) {
+			wc.deliver(topic, message)
+		})
+		if err != nil {
+			wc.logger.Error("subscribing to topic", "topic", topic, "err", err)
+			return
+		}
+		wc.Lock()
+		wc.subscriptions[topic] = unsubscribe
+		wc.Unlock()
+	case !connected && subscribed:
+		wc.Lock()
+		delete(wc.subscriptions, topic)
+		wc.Unlock()
+		unsubscribe()
+	}
+	if !connected {
+		wc.replay.remove(topic)
+		wc.removeTopicStore(topic)
+	}
+}
+
+// removeTopicStore drops the store of topic once it has no connections. The connections get the store after they
+// are added to their topic, so a connection added meanwhile keeps it.
+func (wc *websocketController) removeTopicStore(topic string) {
+	wc.Lock()
+	defer wc.Unlock()
+	if _, ok := wc.topicConnections[topic]; !ok {
+		wc.topicStores.remove(topic)
+	}
+}
+
+// publish sends the message to the connections of topic on all the instances.
+func (wc *websocketController) publish(topic string, message []byte) {
+	if message == nil {
+		return
+	}
+	wc.record(topic, message)
+	if err := wc.broker.Publish(topic, message); err != nil {
+		wc.logger.Error("publishing to topic", "topic", topic, "err", err)
+	}
+}
+
+// deliver writes a message received from the broker to the local connections of topic.
+func (wc *websocketController) deliver(topic string, message []byte) {
+	except, message := splitExcept(message)
+	defer wc.topicLocks.lock(topic)()
+	if wc.fragmentCacheSize > 0 {
+		var m Operation
+		if err := json.Unmarshal(message, &m); err == nil && m.Hash != "" {
+			wc.deliverCached(topic, &m, message, except)
+			return
+		}
+	}
+	conns, ok := wc.topicConns(topic, except)
+	if !ok {
+		wc.logger.Warn("topic doesn't exist", "topic", topic)
+		return
+	}
+	if wc.encryptOps && sensitive(message) {
+		wc.deliverSealed(topic, conns, message)
+		return
+	}
+	wc.broadcastPrepared(topic, conns, message)
+}
+
+// deliverAll writes a message received from the broker to all the local connections.
+func (wc *websocketController) deliverAll(message []byte) {
+	defer wc.topicLocks.lock(allTopics)()
+	wc.broadcastPrepared(allTopics, wc.allConns(), message)
+}
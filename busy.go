@@ -0,0 +1,51 @@
+package controller
+
+import "time"
+
+// Disable disables the form controls matched by selector e.g. a submit button.
+func (d *dom) Disable(selector string) {
+	m := &Operation{
+		Op:       Disable,
+		Selector: d.scoped(selector),
+	}
+	d.send(m)
+}
+
+// Enable enables the form controls matched by selector.
+func (d *dom) Enable(selector string) {
+	m := &Operation{
+		Op:       Enable,
+		Selector: d.scoped(selector),
+	}
+	d.send(m)
+}
+
+// SetBusy disables the elements matched by selector and marks them busy with aria-busy until the current event is
+// handled, its error is shown or timeout elapses, whichever comes first. A zero timeout only clears it with the event.
+func (d *dom) SetBusy(selector string, timeout time.Duration) {
+	d.event().busy = true
+	m := &Operation{
+		Op:       Busy,
+		Selector: d.scoped(selector),
+		Value: M{
+			"event":     d.eventID,
+			"timeoutMs": timeout.Milliseconds(),
+		},
+	}
+	d.send(m)
+}
+
+// settle clears the busy state set during the event eventID.
+func (d *dom) settle(eventID string) {
+	if !d.busy {
+		return
+	}
+	d.busy = false
+	m := &Operation{
+		Op: Idle,
+		Value: M{
+			"event": eventID,
+		},
+	}
+	d.send(m)
+}
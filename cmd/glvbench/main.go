@@ -0,0 +1,147 @@
+// Command glvbench measures the broadcast throughput and latency of the controller. It serves a view on a local
+// server, opens conns websocket connections to its topic and sends events whose handler morphs an element for the
+// whole topic, then reports the deliveries per second and the latency from the event to its delivery to each
+// connection.
+//
+//	glvbench -conns 5000 -events 200 -workers 8
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/goliveview/controller"
+)
+
+type benchView struct {
+	controller.DefaultView
+}
+
+func (benchView) Content() string {
+	return `{{define "content"}}<div id="seq">{{.seq}}</div>{{end}}{{define "seq"}}{{.seq}}{{end}}`
+}
+func (benchView) Layout() string     { return `{{template "content" .}}` }
+func (benchView) Partials() []string { return nil }
+
+func (benchView) OnLiveEvent(ctx controller.Context) error {
+	var params struct {
+		Seq int `json:"seq"`
+	}
+	if err := ctx.Event().DecodeParams(&params); err != nil {
+		return err
+	}
+	ctx.DOM().Morph("#seq", "seq", controller.M{"seq": params.Seq})
+	return nil
+}
+
+func main() {
+	conns := flag.Int("conns", 1000, "number of websocket connections subscribed to the topic.")
+	events := flag.Int("events", 100, "number of broadcast events.")
+	workers := flag.Int("workers", 0, "fan-out workers, defaults to GOMAXPROCS.")
+	interval := flag.Duration("interval", 10*time.Millisecond, "delay between two events.")
+	flag.Parse()
+
+	log.SetOutput(io.Discard)
+	wc := controller.Websocket("glvbench", controller.WithFanOutWorkers(*workers))
+	srv := httptest.NewServer(wc.Handler(benchView{}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/"
+
+	sent := make([]int64, *events)
+	var latencies []time.Duration
+	var latenciesMu sync.Mutex
+	var delivered int64
+	var wg sync.WaitGroup
+	clients := make([]*websocket.Conn, *conns)
+	for i := range clients {
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connection %d: %v\n", i, err)
+			os.Exit(1)
+		}
+		clients[i] = c
+		wg.Add(1)
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			received := make([]time.Duration, 0, *events)
+			for len(received) < *events {
+				_, message, err := c.ReadMessage()
+				if err != nil {
+					break
+				}
+				var m controller.Operation
+				if err := json.Unmarshal(message, &m); err != nil || m.Selector != "#seq" {
+					continue
+				}
+				var seq int
+				if _, err := fmt.Sscan(fmt.Sprint(m.Value), &seq); err != nil || seq >= len(sent) {
+					continue
+				}
+				received = append(received, time.Since(time.Unix(0, atomic.LoadInt64(&sent[seq]))))
+				atomic.AddInt64(&delivered, 1)
+			}
+			latenciesMu.Lock()
+			latencies = append(latencies, received...)
+			latenciesMu.Unlock()
+		}(c)
+	}
+	// let the server register the connections
+	time.Sleep(500 * time.Millisecond)
+
+	start := time.Now()
+	sender := clients[0]
+	for seq := 0; seq < *events; seq++ {
+		atomic.StoreInt64(&sent[seq], time.Now().UnixNano())
+		event := controller.Event{ID: "bench", Params: json.RawMessage(fmt.Sprintf(`{"seq":%d}`, seq))}
+		if err := sender.WriteJSON(event); err != nil {
+			fmt.Fprintf(os.Stderr, "sending event %d: %v\n", seq, err)
+			os.Exit(1)
+		}
+		time.Sleep(*interval)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		fmt.Fprintln(os.Stderr, "timed out waiting for the deliveries")
+	}
+	elapsed := time.Since(start)
+	for _, c := range clients {
+		c.Close()
+	}
+
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	n := atomic.LoadInt64(&delivered)
+	fmt.Printf("connections  %d\n", *conns)
+	fmt.Printf("events       %d\n", *events)
+	fmt.Printf("delivered    %d/%d\n", n, *conns**events)
+	fmt.Printf("throughput   %.0f deliveries/s\n", float64(n)/elapsed.Seconds())
+	fmt.Printf("latency p50  %v\n", percentile(0.5))
+	fmt.Printf("latency p99  %v\n", percentile(0.99))
+	fmt.Printf("latency max  %v\n", percentile(1))
+}
@@ -0,0 +1,181 @@
+// Command glvgen generates typed render helpers from annotated templates so that passing the wrong data shape
+// to DOM.Morph or from OnMount is a compile error.
+//
+// A {{/* @data Type */}} annotation inside a {{define "name"}} block generates:
+//
+//	func MorphName(dom controller.DOM, selector string, data Type, hints ...controller.Hint)
+//
+// and an annotation outside of any define block, i.e. for the page itself, generates:
+//
+//	func MountType(status controller.Status, data Type) (controller.Status, controller.M)
+//
+// The data types must be declared in the output package. Usage:
+//
+//	//go:generate glvgen -dir ../templates -pkg views -out templates_gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	defineRe     = regexp.MustCompile(`{{-?\s*(define|end)\b\s*(?:"([^"]+)")?`)
+	annotationRe = regexp.MustCompile(`{{-?\s*/\*\s*@data\s+([A-Za-z_][A-Za-z0-9_.]*)\s*\*/\s*-?}}`)
+	extensions   = map[string]bool{".gohtml": true, ".gotmpl": true, ".html": true, ".tmpl": true}
+)
+
+type binding struct {
+	template string
+	dataType string
+	file     string
+}
+
+func main() {
+	dir := flag.String("dir", "templates", "directory containing the templates.")
+	pkg := flag.String("pkg", "views", "package name of the generated file.")
+	out := flag.String("out", "templates_gen.go", "generated file.")
+	flag.Parse()
+
+	var morphs, mounts []binding
+	err := filepath.WalkDir(*dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !extensions[filepath.Ext(path)] {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		m, p := annotations(string(data), path)
+		morphs = append(morphs, m...)
+		mounts = append(mounts, p...)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	src, err := generate(*pkg, morphs, mounts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// annotations returns the @data annotations of define blocks and of the page.
+func annotations(src, file string) (morphs, mounts []binding) {
+	type block struct {
+		name  string
+		start int
+	}
+	// track the define block enclosing each annotation. Only define blocks are tracked, other actions
+	// closed by {{end}} are counted to match the ends.
+	var stack []block
+	depth := 0
+	openRe := regexp.MustCompile(`{{-?\s*(if|range|with|block)\b`)
+	type token struct {
+		pos  int
+		kind string
+		name string
+	}
+	var tokens []token
+	for _, m := range defineRe.FindAllStringSubmatchIndex(src, -1) {
+		kind := src[m[2]:m[3]]
+		name := ""
+		if m[4] >= 0 {
+			name = src[m[4]:m[5]]
+		}
+		tokens = append(tokens, token{pos: m[0], kind: kind, name: name})
+	}
+	for _, m := range openRe.FindAllStringIndex(src, -1) {
+		tokens = append(tokens, token{pos: m[0], kind: "open"})
+	}
+	for _, m := range annotationRe.FindAllStringSubmatchIndex(src, -1) {
+		tokens = append(tokens, token{pos: m[0], kind: "data", name: src[m[2]:m[3]]})
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].pos < tokens[j].pos })
+
+	for _, t := range tokens {
+		switch t.kind {
+		case "define":
+			stack = append(stack, block{name: t.name, start: depth})
+			depth++
+		case "open":
+			depth++
+		case "end":
+			depth--
+			if len(stack) > 0 && stack[len(stack)-1].start == depth {
+				stack = stack[:len(stack)-1]
+			}
+		case "data":
+			if len(stack) == 0 {
+				mounts = append(mounts, binding{dataType: t.name, file: file})
+				continue
+			}
+			morphs = append(morphs, binding{template: stack[len(stack)-1].name, dataType: t.name, file: file})
+		}
+	}
+	return morphs, mounts
+}
+
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func generate(pkg string, morphs, mounts []binding) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by glvgen. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import \"github.com/goliveview/controller\"\n\n")
+	seen := make(map[string]string)
+	for _, m := range morphs {
+		fn := "Morph" + identifier(m.template)
+		if prev, ok := seen[fn]; ok {
+			return nil, fmt.Errorf("%s: template %q is already annotated in %s", m.file, m.template, prev)
+		}
+		seen[fn] = m.file
+		fmt.Fprintf(&buf, "// %s morphs selector with the template %q defined in %s.\n", fn, m.template, filepath.ToSlash(m.file))
+		fmt.Fprintf(&buf, "func %s(dom controller.DOM, selector string, data %s, hints ...controller.Hint) {\n", fn, m.dataType)
+		fmt.Fprintf(&buf, "\tdom.Morph(selector, %q, controller.ToM(data), hints...)\n}\n\n", m.template)
+	}
+	for _, m := range mounts {
+		fn := "Mount" + identifier(m.dataType)
+		if _, ok := seen[fn]; ok {
+			continue
+		}
+		seen[fn] = m.file
+		fmt.Fprintf(&buf, "// %s returns the typed mount data of %s from OnMount.\n", fn, filepath.ToSlash(m.file))
+		fmt.Fprintf(&buf, "func %s(status controller.Status, data %s) (controller.Status, controller.M) {\n", fn, m.dataType)
+		fmt.Fprintf(&buf, "\treturn status, controller.ToM(data)\n}\n\n")
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,93 @@
+// Command glvvet statically analyses the templates of a goliveview project. It reports undefined templates,
+// unused partials, nested field accesses at risk with missingkey=zero and morph bindings whose selector or template
+// doesn't exist. It exits with status 1 if any problem is found so it can be used in CI.
+//
+//	glvvet -project . -layout templates/layout.html -bindings bindings.json templates/index.html templates/todos
+//
+// The funcs added with controller.WithFuncs are listed with -funcs, e.g. -funcs currency,icon, on top of the
+// controller.DefaultFuncMap.
+//
+// The bindings file maps a content path to the bindings of its view:
+//
+//	{"templates/index.html": [{"selector": "#todos", "template": "todos"}]}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+
+	"github.com/goliveview/controller"
+)
+
+type fileView struct {
+	controller.DefaultView
+	layout   string
+	content  string
+	partials []string
+	bindings []controller.Binding
+}
+
+func (f fileView) Layout() string                 { return f.layout }
+func (f fileView) Content() string                { return f.content }
+func (f fileView) Partials() []string             { return f.partials }
+func (f fileView) Bindings() []controller.Binding { return f.bindings }
+func (f fileView) String() string                 { return f.content }
+
+func main() {
+	project := flag.String("project", ".", "project root directory that contains the template files.")
+	layout := flag.String("layout", "", "layout shared by the views.")
+	partials := flag.String("partials", "./templates/partials", "comma separated partials directories.")
+	bindingsFile := flag.String("bindings", "", "json file mapping the content paths to their morph bindings.")
+	funcNames := flag.String("funcs", "", "comma separated names of the funcs added with controller.WithFuncs.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: glvvet [flags] content...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	bindings := make(map[string][]controller.Binding)
+	if *bindingsFile != "" {
+		data, err := os.ReadFile(*bindingsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := json.Unmarshal(data, &bindings); err != nil {
+			fmt.Fprintf(os.Stderr, "parsing %s: %v\n", *bindingsFile, err)
+			os.Exit(2)
+		}
+	}
+
+	var views []controller.View
+	for _, content := range flag.Args() {
+		views = append(views, fileView{
+			layout:   *layout,
+			content:  content,
+			partials: strings.Split(*partials, ","),
+			bindings: bindings[content],
+		})
+	}
+
+	// the templates are only parsed and walked, the funcs are never called
+	funcs := make(template.FuncMap)
+	for _, name := range strings.Split(*funcNames, ",") {
+		if name != "" {
+			funcs[name] = func(...interface{}) string { return "" }
+		}
+	}
+	problems := controller.Vet(*project, funcs, views...)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// WithCoalescing coalesces the morphs of the same selector on a topic sent within window: the first one is
+// sent right away and only the latest of the following ones is sent at the end of the window. It cuts the
+// bandwidth and the client reflows of ticker-style updates. The pending morphs of a topic are flushed before
+// any other operation is sent to it so that the order is preserved.
+func WithCoalescing(window time.Duration) Option {
+	return func(o *controlOpt) {
+		o.coalesceWindow = window
+	}
+}
+
+type coalesceKey struct {
+	topic    string
+	selector string
+}
+
+type coalescer struct {
+	window  time.Duration
+	send    func(topic string, m *Operation)
+	pending map[coalesceKey]*Operation
+	last    map[coalesceKey]time.Time
+	sync.Mutex
+}
+
+func newCoalescer(window time.Duration, send func(topic string, m *Operation)) *coalescer {
+	return &coalescer{
+		window:  window,
+		send:    send,
+		pending: make(map[coalesceKey]*Operation),
+		last:    make(map[coalesceKey]time.Time),
+	}
+}
+
+func (c *coalescer) push(topic, selector string, m *Operation) {
+	key := coalesceKey{topic: topic, selector: selector}
+	c.Lock()
+	if _, ok := c.pending[key]; ok {
+		c.pending[key] = m
+		c.Unlock()
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(c.last[key])
+	if elapsed >= c.window {
+		c.last[key] = now
+		c.evict(now)
+		c.Unlock()
+		c.send(topic, m)
+		return
+	}
+	c.pending[key] = m
+	c.Unlock()
+	time.AfterFunc(c.window-elapsed, func() {
+		c.fire(key)
+	})
+}
+
+func (c *coalescer) fire(key coalesceKey) {
+	c.Lock()
+	m, ok := c.pending[key]
+	if !ok {
+		c.Unlock()
+		return
+	}
+	delete(c.pending, key)
+	c.last[key] = time.Now()
+	c.Unlock()
+	c.send(key.topic, m)
+}
+
+// flush sends the pending morphs of topic.
+func (c *coalescer) flush(topic string) {
+	c.Lock()
+	var keys []coalesceKey
+	for key := range c.pending {
+		if key.topic == topic {
+			keys = append(keys, key)
+		}
+	}
+	c.Unlock()
+	for _, key := range keys {
+		c.fire(key)
+	}
+}
+
+// evict removes the send times older than the window.
+func (c *coalescer) evict(now time.Time) {
+	if len(c.last) < 1024 {
+		return
+	}
+	for key, t := range c.last {
+		if _, ok := c.pending[key]; !ok && now.Sub(t) >= c.window {
+			delete(c.last, key)
+		}
+	}
+}
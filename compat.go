@@ -0,0 +1,98 @@
+package controller
+
+import "net/http"
+
+// SessionStore is the store of the Session API which preceded Context and Store: Set saves the values of m and
+// Decode reads the value of key into data.
+//
+// Deprecated: use Store. SessionStoreOf and StoreOfSessionStore convert between the two while the code is migrated.
+type SessionStore interface {
+	Set(m M) error
+	Decode(key string, data interface{}) error
+}
+
+// Session is the event context of the Session API which preceded Context. The DOM operations are called on the
+// Session itself.
+//
+// Deprecated: use Context. SessionOf and SessionHandler let the handlers written against Session run unchanged.
+type Session interface {
+	DOM
+	Event() Event
+	Store() SessionStore
+	Request() *http.Request
+}
+
+// SessionStoreOf returns store as a SessionStore.
+func SessionStoreOf(store Store) SessionStore {
+	if s, ok := store.(storeOfSessionStore); ok {
+		return s.SessionStore
+	}
+	return sessionStoreOf{Store: store}
+}
+
+// StoreOfSessionStore returns a SessionStore implementation as a Store e.g. to keep using a custom SessionStore
+// with WithStoreFactory.
+func StoreOfSessionStore(store SessionStore) Store {
+	if s, ok := store.(sessionStoreOf); ok {
+		return s.Store
+	}
+	return storeOfSessionStore{SessionStore: store}
+}
+
+// SessionOf returns ctx as a Session.
+func SessionOf(ctx Context) Session {
+	return session{DOM: ctx.DOM(), ctx: ctx}
+}
+
+// SessionHandler adapts a handler written against Session to an EventHandler e.g. to register it in
+// EventHandlers or to call it from OnLiveEvent:
+//
+//	func (t *TodosView) OnLiveEvent(ctx controller.Context) error {
+//		return controller.SessionHandler(t.onEvent)(ctx)
+//	}
+func SessionHandler(h func(s Session) error) EventHandler {
+	return func(ctx Context) error {
+		return h(SessionOf(ctx))
+	}
+}
+
+type sessionStoreOf struct {
+	Store
+}
+
+func (s sessionStoreOf) Set(m M) error {
+	return s.Put(m)
+}
+
+func (s sessionStoreOf) Decode(key string, data interface{}) error {
+	return s.Get(key, data)
+}
+
+type storeOfSessionStore struct {
+	SessionStore
+}
+
+func (s storeOfSessionStore) Put(m M) error {
+	return s.Set(m)
+}
+
+func (s storeOfSessionStore) Get(key string, data interface{}) error {
+	return s.Decode(key, data)
+}
+
+type session struct {
+	DOM
+	ctx Context
+}
+
+func (s session) Event() Event {
+	return s.ctx.Event()
+}
+
+func (s session) Store() SessionStore {
+	return SessionStoreOf(s.ctx.Store())
+}
+
+func (s session) Request() *http.Request {
+	return s.ctx.Request()
+}
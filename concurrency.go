@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTooManyInFlight = errors.New("too many events in progress")
+
+// WithUserConcurrencyLimit caps the number of event handlers running at the same time for a user across all
+// of its connections. Events beyond the limit wait for a free slot if queue is true, otherwise they are rejected.
+func WithUserConcurrencyLimit(limit int, queue bool) Option {
+	return func(o *controlOpt) {
+		o.userConcurrency = limit
+		o.queueUserEvents = queue
+	}
+}
+
+type userSlots struct {
+	slots map[int]*userSlot
+	sync.Mutex
+}
+
+// userSlot holds the running handlers of a user in sem. refs counts the handlers running or waiting so that the
+// slot is dropped once the user has none.
+type userSlot struct {
+	sem  chan struct{}
+	refs int
+}
+
+func (u *userSlots) get(user, limit int) *userSlot {
+	u.Lock()
+	defer u.Unlock()
+	s, ok := u.slots[user]
+	if !ok {
+		s = &userSlot{sem: make(chan struct{}, limit)}
+		u.slots[user] = s
+	}
+	s.refs++
+	return s
+}
+
+func (u *userSlots) put(user int, s *userSlot) {
+	u.Lock()
+	defer u.Unlock()
+	s.refs--
+	if s.refs == 0 {
+		delete(u.slots, user)
+	}
+}
+
+// acquireSlot reserves an event handling slot for the user. The returned func releases it and must be deferred so that
+// a panicking handler doesn't keep the slot.
+func (wc *websocketController) acquireSlot(user int) (func(), error) {
+	if wc.userConcurrency <= 0 {
+		return func() {}, nil
+	}
+	s := wc.userSlots.get(user, wc.userConcurrency)
+	if wc.queueUserEvents {
+		s.sem <- struct{}{}
+	} else {
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			wc.userSlots.put(user, s)
+			return nil, ErrTooManyInFlight
+		}
+	}
+	return func() {
+		<-s.sem
+		wc.userSlots.put(user, s)
+	}, nil
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+)
+
+// WithRequestContext derives the context returned by ctx.Context() from the request of the mount or of the live
+// connection with f, e.g. to add the values read by the handlers. The context of a live connection is cancelled
+// once the connection is closed and its handlers have returned, or earlier when it misses its heartbeats, see
+// WithHeartbeat, or can't be written: a handler isn't aborted as soon as the client goes away. Defaults to
+// r.Context().
+func WithRequestContext(f func(r *http.Request) context.Context) Option {
+	return func(o *controlOpt) {
+		o.requestContextFunc = f
+	}
+}
+
+// requestContext returns the context derived from r with WithRequestContext.
+func (wc *websocketController) requestContext(r *http.Request) context.Context {
+	if wc.requestContextFunc == nil {
+		return r.Context()
+	}
+	return wc.requestContextFunc(r)
+}
+
+// connContext returns the context of a live connection opened with r, cancelled with cancel once the connection
+// is closed.
+func (wc *websocketController) connContext(r *http.Request) (ctx context.Context, cancel context.CancelFunc) {
+	return context.WithCancel(wc.requestContext(r))
+}
+
+// closeConn closes a connection which failed to be written and cancels the context of its session: its read loop
+// only notices the close once the event being handled returns.
+func (wc *websocketController) closeConn(c connRef) {
+	c.conn.Close()
+	if lc, ok := wc.liveConns.get(c.id); ok && lc.session.cancel != nil {
+		lc.session.cancel()
+	}
+}
+
+// baseContext returns the context of the connection of the session, or of the request on mount.
+func (s sessionContext) baseContext() context.Context {
+	if s.base != nil {
+		return s.base
+	}
+	return s.r.Context()
+}
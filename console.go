@@ -0,0 +1,50 @@
+package controller
+
+// ConsoleLevel is the browser console method used by DOM.ConsoleLog.
+type ConsoleLevel string
+
+const (
+	ConsoleDebug ConsoleLevel = "debug"
+	ConsoleInfo  ConsoleLevel = "info"
+	ConsoleLog   ConsoleLevel = "log"
+	ConsoleWarn  ConsoleLevel = "warn"
+	ConsoleError ConsoleLevel = "error"
+)
+
+// EnableConsoleOps enables DOM.ConsoleLog outside of the development mode.
+func EnableConsoleOps() Option {
+	return func(o *controlOpt) {
+		o.consoleOps = true
+	}
+}
+
+// ConsoleLog prints args in the browser console of the clients next to the client runtime logs. It's a debugging
+// aid available in the development mode or when EnableConsoleOps is set. The args are redacted with the
+// RedactionPolicy before being sent.
+func (d *dom) ConsoleLog(level ConsoleLevel, args ...interface{}) {
+	if !d.wc.developmentMode && !d.wc.consoleOps {
+		return
+	}
+	switch level {
+	case ConsoleDebug, ConsoleInfo, ConsoleLog, ConsoleWarn, ConsoleError:
+	default:
+		d.wc.logger.Warn("unknown console level, using log", "level", level)
+		level = ConsoleLog
+	}
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			arg = err.Error()
+		}
+		redacted[i] = d.wc.redaction.Redact(arg)
+	}
+	m := &Operation{
+		Op: Console,
+		Value: M{
+			"level": level,
+			"args":  redacted,
+			"event": d.eventID,
+		},
+	}
+	d.send(m)
+}
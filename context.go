@@ -2,10 +2,16 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
 type M map[string]interface{}
@@ -15,6 +21,11 @@ type Event struct {
 	Selector string          `json:"selector"`
 	Template string          `json:"template"`
 	Params   json.RawMessage `json:"params"`
+	// Meta carries runtime and app-defined metadata e.g. the element value, dataset entries
+	// and keyboard modifiers so it doesn't need to be mixed into Params.
+	Meta map[string]string `json:"meta,omitempty"`
+	// View is the id of the view the event is for on a connection shared by several views.
+	View string `json:"view,omitempty"`
 }
 
 func (e Event) String() string {
@@ -26,22 +37,110 @@ type EventHandler func(ctx Context) error
 
 type Context interface {
 	Event() Event
+	// Params returns the params of the event decoded into the type registered with RegisterEvent, or nil.
+	Params() interface{}
 	DOM() DOM
 	Store() Store
+	// TopicStore returns the store shared by all the users subscribed to the same topic. It's dropped once the last
+	// connection leaves the topic: on mount, it's only shared if the topic has connections.
+	TopicStore() SharedStore
+	// Doc returns the CRDT document shared by all the users subscribed to the same topic, kept like the TopicStore.
+	Doc() *Doc
+	// Lock acquires or renews the lease name for this connection for ttl. It returns ErrLocked if the lease
+	// is held by another connection. Leases are released when the connection is closed. They only hold across the
+	// instances with a shared Locker, see WithLocker.
+	Lock(name string, ttl time.Duration) error
+	// WaitLock is like Lock but waits up to timeout for the lease to be released.
+	WaitLock(name string, ttl, timeout time.Duration) error
+	Unlock(name string) error
+	// Variant returns the variant of the experiment name assigned to the user.
+	Variant(name string) string
+	// Flag reports whether the feature flag name is on for the user.
+	Flag(name string) bool
+	// Locale returns the preferred language tag of the client parsed from Accept-Language.
+	Locale() string
+	// Country returns the country code of the client if a GeoLookup is configured.
+	Country() string
+	// Preferences returns the user preferences.
+	Preferences() Preferences
+	// SetPreferences updates the user preferences in the Store and in the signed preferences cookie.
+	SetPreferences(p Preferences) error
+	// Temporary marks keys of the data passed to DOM calls as not to be saved to the Store during this event.
 	Temporary(keys ...string)
+	// Persistent overrides the default temporary keys, see WithTemporaryKeys, during this event.
+	Persistent(keys ...string)
+	// Resubscribe moves the connection to topic without reconnecting e.g. from a lobby to a room.
+	Resubscribe(topic string) error
+	// Subscribe subscribes the connection to the broadcasts of topic in addition to its own topic.
+	Subscribe(topic string) error
+	// Unsubscribe removes a subscription added with Subscribe or WithSubscriber.
+	Unsubscribe(topic string) error
+	// PathParams returns the values of the path params of the route of the view, see Controller.Route.
+	PathParams() map[string]string
+	// DecodeForm decodes the form sent with the event, or posted on mount, into the struct pointed to by v, see
+	// Event.DecodeForm.
+	DecodeForm(v interface{}) error
+	// Uploads returns the files uploaded since the previous event, see EnableUploads.
+	Uploads() []Upload
+	// Topic returns the topic the connection is subscribed to.
+	Topic() string
+	// ConnID returns the id of the live connection, or an empty string on mount.
+	ConnID() string
+	// Presence returns the users present on the topic of the connection, see EnablePresence.
+	Presence() []PresenceUser
+	// User returns the id of the user, authenticated by WithAuth or kept in the session cookie.
+	User() UserID
 	Request() *http.Request
 	ResponseWriter() http.ResponseWriter
+	// Context returns the context of the request, carrying the values set by the middleware and the span of the
+	// mount or the event, see WithTracer. The context of a live connection is cancelled once it's closed, see
+	// WithRequestContext.
+	Context() context.Context
+	// SetMeta sets the title, description and OpenGraph tags of the page.
+	SetMeta(m MetaTags)
 }
 
+// DecodeParams decodes the event params into v. Params can be JSON or a urlencoded string as produced by
+// serializing a form, in which case the values are strings: use the json ",string" option for numeric fields.
 func (e Event) DecodeParams(v interface{}) error {
-	return json.NewDecoder(bytes.NewReader(e.Params)).Decode(v)
+	params, err := formParams(e.Params)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(params)).Decode(v)
 }
 
 type sessionContext struct {
-	event Event
-	dom   *dom
-	r     *http.Request
-	w     http.ResponseWriter
+	event      Event
+	dom        *dom
+	topicStore *topicStore
+	connID     string
+	conn       Conn
+	variants   map[string]string
+	user       int
+	locale     string
+	country    string
+	meta       *pageMeta
+	observer   bool
+	uploads    *uploads
+	// taken are the uploads passed to the handler of the event, removed once it returns.
+	taken *[]Upload
+	// handling counts the events of the session being handled off the read loop, see WithEventWorkers.
+	handling *sync.WaitGroup
+	// serial is held while the session is used by the handlers of the connection which aren't run concurrently
+	// with each other: the hooks, the events handled in order and the jobs posted to the connection.
+	serial  *sync.Mutex
+	params  interface{}
+	ctx     context.Context
+	base    context.Context
+	cancel  context.CancelFunc
+	limiter *rate.Limiter
+	// stateToken is the token the session is saved under once the connection is closed, see EnableSessionResume.
+	stateToken string
+	r          *http.Request
+	w          http.ResponseWriter
+	// page is the url of the page of a live connection, changed by the navigations.
+	page *url.URL
 }
 
 func (s sessionContext) setError(userMessage string, errs ...error) {
@@ -53,7 +152,7 @@ func (s sessionContext) setError(userMessage string, errs ...error) {
 			}
 			errstrs = append(errstrs, err.Error())
 		}
-		log.Printf("err: %v, errors: %v\n", userMessage, strings.Join(errstrs, ","))
+		s.dom.wc.logger.Error(userMessage, "topic", s.dom.topic, "conn", s.connID, "user", s.user, "event", s.event.ID, "err", strings.Join(errstrs, ","))
 	}
 
 	s.dom.Morph("#glv-error", "glv-error", M{"error": userMessage})
@@ -72,6 +171,18 @@ func (s sessionContext) Event() Event {
 	return s.event
 }
 
+func (s sessionContext) Topic() string {
+	return s.dom.topic
+}
+
+func (s sessionContext) ConnID() string {
+	return s.connID
+}
+
+func (s sessionContext) User() UserID {
+	return s.user
+}
+
 func (s sessionContext) Request() *http.Request {
 	return s.r
 }
@@ -80,10 +191,94 @@ func (s sessionContext) ResponseWriter() http.ResponseWriter {
 	return s.w
 }
 
-func (s sessionContext) Temporary(keys ...string) {
-	s.dom.temporaryKeys = append(s.dom.temporaryKeys, keys...)
+func (s sessionContext) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return s.baseContext()
 }
 
 func (s sessionContext) Store() Store {
+	if s.dom.owned != nil {
+		return ownedStore{Store: s.dom.store, owned: s.dom.owned}
+	}
 	return s.dom.store
 }
+
+func (s sessionContext) TopicStore() SharedStore {
+	return s.topicStore
+}
+
+func (s sessionContext) Doc() *Doc {
+	return s.topicStore.doc
+}
+
+func (s sessionContext) Lock(name string, ttl time.Duration) error {
+	if s.connID == "" {
+		return fmt.Errorf("lock %s: no live connection", name)
+	}
+	ok, err := s.dom.wc.locker.Acquire(name, s.connID, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLocked
+	}
+	return nil
+}
+
+func (s sessionContext) WaitLock(name string, ttl, timeout time.Duration) error {
+	if s.connID == "" {
+		return fmt.Errorf("lock %s: no live connection", name)
+	}
+	return waitLock(s.dom.wc.locker, name, s.connID, ttl, timeout)
+}
+
+func (s sessionContext) Unlock(name string) error {
+	return s.dom.wc.locker.Release(name, s.connID)
+}
+
+func (s sessionContext) Variant(name string) string {
+	return s.variants[name]
+}
+
+func (s sessionContext) Flag(name string) bool {
+	if s.dom.wc.flagProvider == nil {
+		return false
+	}
+	return s.dom.wc.flagProvider.Enabled(s.r, s.user, name)
+}
+
+func (s sessionContext) Locale() string {
+	return s.locale
+}
+
+func (s sessionContext) Country() string {
+	return s.country
+}
+
+func (s sessionContext) Preferences() Preferences {
+	var p Preferences
+	if err := s.dom.store.Get(preferencesKey, &p); err != nil {
+		return Preferences{}
+	}
+	return p
+}
+
+func (s sessionContext) SetPreferences(p Preferences) error {
+	err := s.Store().Put(M{preferencesKey: p})
+	if err != nil {
+		return err
+	}
+	cookie, err := s.dom.wc.encodePreferences(p)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		http.SetCookie(s.w, cookie)
+		return nil
+	}
+	// cookies can't be set over the live connection, let the client set it
+	s.dom.wc.messageConn(s.conn, setCookieOperation(cookie).encode(s.dom.wc.logger))
+	return nil
+}
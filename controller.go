@@ -1,22 +1,47 @@
 package controller
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"html/template"
+	"io"
+	"io/fs"
+	"math"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/securecookie"
 
 	"github.com/gorilla/sessions"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 type Controller interface {
 	Handler(view View) http.HandlerFunc
+	Fragment(containerID string, view View) http.HandlerFunc
+	SetMaintenance(on bool, message string)
+	Validate(views ...View) error
+	Remote(view View) RemoteView
+	Socket() http.HandlerFunc
+	Render(w io.Writer, view View, data M) error
+	Export(path string, view View, data M) error
+	PublishRender(topic, selector, template string, dataFn func(c ConnInfo) M)
+	Publish(topic string, op Operation) error
+	MorphTopic(topic, selector, template string, data M) error
+	Presence(topic string) []PresenceUser
+	Stats(topN int) Stats
+	Inspector() http.HandlerFunc
+	MoveConnections(oldTopic, newTopic string)
+	Preload(views ...View) error
+	Shutdown(ctx context.Context) error
+	History(topic string, n int) []Operation
+	Route(pattern string, view View)
+	Router() http.HandlerFunc
 }
 
 type controlOpt struct {
@@ -31,6 +56,86 @@ type controlOpt struct {
 	projectRoot          string
 	developmentMode      bool
 	errorView            View
+	announceRegion       bool
+	locker               Locker
+	rateLimiter          RateLimiter
+	serverTiming         bool
+	slowThreshold        time.Duration
+	slowHooks            []func(s Slow)
+	userConcurrency      int
+	queueUserEvents      bool
+	maxGoroutines        int
+	maxInFlight          int64
+	retryAfter           time.Duration
+	drainOnMaintenance   bool
+	experiments          []experiment
+	variantAssigner      VariantAssigner
+	flagProvider         FlagProvider
+	geoLookup            GeoLookup
+	preferencesKey       []byte
+	allowScriptOps       bool
+	stateCodec           *securecookie.SecureCookie
+	middleware           []Middleware
+	earlyHints           bool
+	preloadAssets        []string
+	socketPath           string
+	crawlerUserAgents    []string
+	analytics            func(e AnalyticsEvent)
+	redaction            RedactionPolicy
+	coalesceWindow       time.Duration
+	storeQuota           *StoreQuota
+	generation           string
+	reconnectJitter      time.Duration
+	consoleOps           bool
+	temporaryKeys        []string
+	fragmentCacheSize    int
+	funcs                template.FuncMap
+	templateFS           fs.FS
+	storeFactory         func(user int) Store
+	sessionKeys          [][]byte
+	nextUserID           func() (int, error)
+	topicStrategy        TopicStrategy
+	broker               Broker
+	subscriberFunc       func(r *http.Request) []string
+	unknownEventHandler  EventHandler
+	encryptOps           bool
+	pingInterval         time.Duration
+	pongTimeout          time.Duration
+	observe              func(r *http.Request) bool
+	observerEvents       []string
+	shutdownNotice       string
+	idGenerator          func() string
+	reconnectTokenTTL    time.Duration
+	tokenStore           TokenStore
+	batchOps             bool
+	replayBufferSize     int
+	missedHeartbeats     int
+	offlineGrace         time.Duration
+	presenceHook         func(change PresenceChange)
+	scopedClasses        bool
+	fanOutWorkers        int
+	uploadMaxSize        int64
+	uploadTypes          []string
+	csrf                 bool
+	csrfKey              []byte
+	allowedOrigins       []string
+	auth                 func(r *http.Request) (UserID, error)
+	eventDecoders        map[string]eventDecoder
+	presenceTracking     bool
+	presenceMeta         func(c ConnInfo) M
+	hotReload            bool
+	logger               Logger
+	metrics              Metrics
+	tracer               Tracer
+	requestContextFunc   func(r *http.Request) context.Context
+	writeQueueSize       int
+	queuePolicy          QueuePolicy
+	eventRate            rate.Limit
+	eventBurst           int
+	maxMessageSize       int64
+	eventWorkers         int
+	eventOrder           EventOrder
+	resumeTTL            time.Duration
 }
 
 type Option func(*controlOpt)
@@ -38,6 +143,8 @@ type Option func(*controlOpt)
 func WithSubscribeTopic(f func(r *http.Request) *string) Option {
 	return func(o *controlOpt) {
 		o.subscribeTopicFunc = f
+		o.topicStrategy = nil
+		o.subscriberFunc = nil
 	}
 }
 
@@ -53,12 +160,34 @@ func WithErrorView(view View) Option {
 	}
 }
 
+// WithLocker configures the Locker used by Context.Lock. Defaults to an in-memory locker, whose leases only hold
+// within one instance: use a Locker backed by a shared store, e.g. redis.NewLocker, when running several instances.
+func WithLocker(locker Locker) Option {
+	return func(o *controlOpt) {
+		o.locker = locker
+	}
+}
+
+// WithRateLimiter limits the number of events a user can send. The limit is applied per controller and session, the
+// cookie of a browser, whose id is unique across the instances: with a RateLimiter backed by a shared store, e.g.
+// redis.NewRateLimiter, the limit holds across the instances. It's checked after the limit of the connection set by
+// WithEventRateLimit and answers the events over the limit the same way, without the retryAfterMs.
+// e.g. WithRateLimiter(NewRateLimiter(10, time.Second))
+func WithRateLimiter(rateLimiter RateLimiter) Option {
+	return func(o *controlOpt) {
+		o.rateLimiter = rateLimiter
+	}
+}
+
 func EnableHTMLFormatting() Option {
 	return func(o *controlOpt) {
 		o.enableHTMLFormatting = true
 	}
 }
 
+// DisableTemplateCache recompiles the templates of a view when one of its files changed. The files are checked on
+// each request unless they are watched, see EnableWatch, in which case the templates are only recompiled when the
+// watcher reports a change.
 func DisableTemplateCache() Option {
 	return func(o *controlOpt) {
 		o.disableTemplateCache = true
@@ -81,6 +210,20 @@ func EnableWatch(rootDir string, extensions ...string) Option {
 	}
 }
 
+// EnableAnnounceRegion injects the aria-live region used by DOM.Announce into the rendered views.
+func EnableAnnounceRegion() Option {
+	return func(o *controlOpt) {
+		o.announceRegion = true
+	}
+}
+
+// EnableServerTiming attaches the event receive time and handler duration to the operations.
+func EnableServerTiming() Option {
+	return func(o *controlOpt) {
+		o.serverTiming = true
+	}
+}
+
 func DevelopmentMode(enable bool) Option {
 	return func(o *controlOpt) {
 		o.developmentMode = enable
@@ -100,42 +243,82 @@ func Websocket(name string, options ...Option) Controller {
 
 	o := &controlOpt{
 		subscribeTopicFunc: func(r *http.Request) *string {
-			topic := "root"
-			if r.URL.Path != "/" {
-				topic = strings.Replace(r.URL.Path, "/", "_", -1)
-			}
-
-			log.Println("client subscribed to topic: ", topic)
-			return &topic
+			return TopicPerPath(r, 0, "")
 		},
-		upgrader:    websocket.Upgrader{EnableCompression: true},
-		watchExts:   DefaultWatchExtensions,
-		projectRoot: projectRoot,
-		errorView:   &DefaultErrorView{},
+		upgrader:        websocket.Upgrader{EnableCompression: true},
+		watchExts:       DefaultWatchExtensions,
+		logger:          stdLogger{},
+		metrics:         noMetrics{},
+		tracer:          noTracer{},
+		writeQueueSize:  DefaultWriteQueueSize,
+		projectRoot:     projectRoot,
+		errorView:       &DefaultErrorView{},
+		locker:          newInmemLocker(),
+		variantAssigner: assignVariant,
+		redaction:       DefaultRedactionPolicy,
+		reconnectJitter: DefaultReconnectJitter,
+		temporaryKeys:   DefaultTemporaryKeys,
+		broker:          newInmemBroker(),
+		tokenStore:      newInmemTokenStore(),
 	}
 
 	for _, option := range options {
 		option(o)
 	}
+	if o.generation == "" {
+		o.generation = newGeneration(o.idGenerator)
+	}
+	if o.csrf {
+		o.csrfKey = newCSRFKey(o.csrfKey)
+	}
 
 	wc := &websocketController{
-		cookieStore:      sessions.NewCookieStore(securecookie.GenerateRandomKey(32)),
-		topicConnections: make(map[string]map[string]*websocket.Conn),
+		cookieStore:      newCookieStore(o.sessionKeys),
+		topicConnections: make(map[string]map[string]Conn),
+		subscriptions:    make(subscriptions),
 		controlOpt:       *o,
 		name:             name,
 		userSessions: userSessions{
-			stores: make(map[int]Store),
+			stores:  make(map[int]Store),
+			quota:   o.storeQuota,
+			factory: o.storeFactory,
+		},
+		topicStores: topicStores{
+			stores: make(map[string]*topicStore),
+		},
+		userSlots: userSlots{
+			slots: make(map[int]*userSlot),
 		},
+		preferencesCodec: newPreferencesCodec(o.preferencesKey),
+		shutdown:         shutdown{done: make(chan struct{})},
+		fanOut:           newFanOut(o.fanOutWorkers),
+		eventPool:        newEventPool(o.eventWorkers),
+		posted:           newEventPool(0),
 	}
-	log.Println("controller starting in developer mode ...", wc.developmentMode)
+	if len(wc.allowedOrigins) > 0 {
+		wc.upgrader.CheckOrigin = wc.checkOrigin
+	}
+	if _, err := wc.broker.Subscribe(allTopics, wc.deliverAll); err != nil {
+		panic(fmt.Sprintf("subscribing to the broker: %v", err))
+	}
+	if wc.coalesceWindow > 0 {
+		wc.coalescer = newCoalescer(wc.coalesceWindow, wc.broadcast)
+	}
+	if wc.awayEnabled() {
+		go wc.trackPresence()
+	}
+	wc.logger.Info("controller starting", "developmentMode", wc.developmentMode)
 	if wc.developmentMode {
 		wc.debugLog = true
 		wc.enableWatch = true
 		wc.enableHTMLFormatting = true
 		wc.disableTemplateCache = true
+		wc.serverTiming = true
 	}
 
-	if wc.enableWatch {
+	if wc.enableWatch && wc.templateFS != nil {
+		wc.logger.Warn("templates are loaded from a filesystem, not watching", "path", wc.projectRoot)
+	} else if wc.watchingTemplates() {
 		go watchTemplates(wc)
 	}
 	return wc
@@ -154,23 +337,27 @@ func (u *userCount) incr() int {
 }
 
 type userSessions struct {
-	stores map[int]Store
+	stores  map[int]Store
+	quota   *StoreQuota
+	factory func(user int) Store
 	sync.RWMutex
 }
 
-func (u *userSessions) getOrCreate(key int) Store {
+// getOrCreate returns the store of the user key and whether it existed.
+func (u *userSessions) getOrCreate(key int) (Store, bool) {
 	u.Lock()
 	defer u.Unlock()
 	s, ok := u.stores[key]
 	if ok {
-		log.Println("existing user ", key)
-		return s
+		return s, true
 	}
-	s = &inmemStore{
-		data: make(map[string][]byte),
+	if u.factory != nil {
+		s = u.factory(key)
+	} else {
+		s = newInmemStore(nil, u.quota)
 	}
 	u.stores[key] = s
-	return s
+	return s, false
 }
 
 type websocketController struct {
@@ -178,141 +365,248 @@ type websocketController struct {
 	userCount userCount
 	controlOpt
 	cookieStore      *sessions.CookieStore
-	topicConnections map[string]map[string]*websocket.Conn
+	topicConnections map[string]map[string]Conn
 	userSessions     userSessions
+	topicStores      topicStores
+	userSlots        userSlots
+	load             loadCounter
+	maintenance      maintenance
+	preferencesCodec *securecookie.SecureCookie
+	compiledViews    compiledViews
+	socketViews      socketViews
+	liveConns        liveConns
+	coalescer        *coalescer
+	fragments        fragmentCaches
+	subscriptions    subscriptions
+	payloadKeys      payloadKeys
+	streams          streams
+	shutdown         shutdown
+	replay           replayBuffers
+	presence         presenceTracker
+	routes           routes
+	fanOut           *fanOut
+	topicLocks       topicLocks
+	subscribeLocks   topicLocks
+	eventPool        *eventPool
+	posted           *eventPool
 	sync.RWMutex
 }
 
-func (wc *websocketController) addConnection(topic, connID string, sess *websocket.Conn) {
+func (wc *websocketController) addConnection(topic, connID string, sess Conn) {
 	wc.Lock()
-	defer wc.Unlock()
 	_, ok := wc.topicConnections[topic]
 	if !ok {
 		// topic doesn't exit. create
-		wc.topicConnections[topic] = make(map[string]*websocket.Conn)
+		wc.topicConnections[topic] = make(map[string]Conn)
+	}
+	if !wc.registered(connID) {
+		wc.metrics.ConnOpened()
 	}
 	wc.topicConnections[topic][connID] = sess
-	log.Println("addConnection", topic, connID, len(wc.topicConnections[topic]))
+	conns := len(wc.topicConnections[topic])
+	wc.Unlock()
+	wc.syncSubscription(topic)
+	wc.logger.Info("connection added", "topic", topic, "conn", connID, "conns", conns)
 }
 
+// removeConnection removes the connection from topic and from the topics it subscribed to.
 func (wc *websocketController) removeConnection(topic, connID string) {
 	wc.Lock()
-	defer wc.Unlock()
-	connMap, ok := wc.topicConnections[topic]
-	if !ok {
-		return
+	var conn Conn
+	var topics []string
+	// the connection may have been moved to another topic or subscribed to more topics
+	for t, cm := range wc.topicConnections {
+		c, ok := cm[connID]
+		if !ok {
+			continue
+		}
+		conn = c
+		delete(cm, connID)
+		// no connections for the topic, remove it
+		if len(cm) == 0 {
+			delete(wc.topicConnections, t)
+		}
+		topics = append(topics, t)
 	}
-	// delete connection from topic
-	conn, ok := connMap[connID]
-	if ok {
-		delete(connMap, connID)
-		conn.Close()
+	conns := len(wc.topicConnections[topic])
+	wc.Unlock()
+	for _, t := range topics {
+		wc.syncSubscription(t)
 	}
-	// no connections for the topic, remove it
-	if len(connMap) == 0 {
-		delete(wc.topicConnections, topic)
+	if conn == nil {
+		return
 	}
+	conn.Close()
+	wc.metrics.ConnClosed()
+	wc.fragments.remove(connID)
+	wc.payloadKeys.remove(connID)
 
-	log.Println("removeConnection", topic, connID, len(wc.topicConnections[topic]))
+	wc.logger.Info("connection removed", "topic", topic, "conn", connID, "conns", conns)
 }
 
+// message broadcasts the message to the connections of topic on all the instances.
 func (wc *websocketController) message(topic string, message []byte) {
-	wc.Lock()
-	defer wc.Unlock()
-	preparedMessage, err := websocket.NewPreparedMessage(websocket.TextMessage, message)
+	wc.publish(topic, message)
+}
+
+// messageConn writes the message only to the given connection.
+func (wc *websocketController) messageConn(conn Conn, message []byte) {
+	err := conn.Send(message)
 	if err != nil {
-		log.Printf("err preparing message %v\n", err)
-		return
+		wc.logger.Error("writing message", "message", string(message), "err", err)
+		wc.metrics.MessageDropped("", DropWriteFailed)
 	}
+}
 
-	conns, ok := wc.topicConnections[topic]
-	if !ok {
-		log.Printf("warn: topic %v doesn't exist\n", topic)
-		return
-	}
+// messageAll broadcasts the message to all the connections on all the instances.
+func (wc *websocketController) messageAll(message []byte) {
+	wc.publish(allTopics, message)
+}
 
-	for connID, conn := range conns {
-		err := conn.WritePreparedMessage(preparedMessage)
-		if err != nil {
-			log.Printf("error: writing message for topic:%v, closing conn %s with err %v", topic, connID, err)
-			conn.Close()
-			continue
-		}
-	}
+// resolvedUserKey is the context key of the user resolved by withUser.
+type resolvedUserKey struct{}
+
+type resolvedUser struct {
+	user      int
+	sessionID string
+	variants  map[string]string
 }
 
-func (wc *websocketController) messageAll(message []byte) {
-	wc.Lock()
-	defer wc.Unlock()
-	preparedMessage, err := websocket.NewPreparedMessage(websocket.TextMessage, message)
+// withUser resolves the user of r once for the handlers r is passed to, e.g. the views sharing a socket: getUser
+// returns the same user for the returned request instead of authenticating it and saving the cookie again.
+func (wc *websocketController) withUser(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	user, sessionID, variants, err := wc.getUser(w, r)
 	if err != nil {
-		log.Printf("err preparing message %v\n", err)
-		return
-	}
-
-	for _, cm := range wc.topicConnections {
-		for connID, conn := range cm {
-			err := conn.WritePreparedMessage(preparedMessage)
-			if err != nil {
-				log.Printf("error: writing message %v, closing conn %s with err %v", message, connID, err)
-				conn.Close()
-				continue
-			}
-		}
+		return nil, err
 	}
+	return r.WithContext(context.WithValue(r.Context(), resolvedUserKey{}, resolvedUser{
+		user:      user,
+		sessionID: sessionID,
+		variants:  variants,
+	})), nil
 }
 
-func (wc *websocketController) getUser(w http.ResponseWriter, r *http.Request) (int, error) {
+func (wc *websocketController) getUser(w http.ResponseWriter, r *http.Request) (int, string, map[string]string, error) {
+	if u, ok := r.Context().Value(resolvedUserKey{}).(resolvedUser); ok {
+		return u.user, u.sessionID, u.variants, nil
+	}
 	name := strings.TrimSpace(wc.name)
 	wc.cookieStore.MaxAge(0)
 	cookieSession, _ := wc.cookieStore.Get(r, fmt.Sprintf("_glv_key_%s", name))
 	user := cookieSession.Values["user"]
-	if user == nil {
-		c := wc.userCount.incr()
+	authUser, authenticated, err := wc.authenticate(r)
+	if err != nil {
+		return -1, "", nil, err
+	}
+	if authenticated {
+		user = authUser
+	} else if user == nil {
+		c, err := wc.newUserID()
+		if err != nil {
+			wc.logger.Error("generating user id", "err", err)
+			return -1, "", nil, err
+		}
 		cookieSession.Values["user"] = c
 		user = c
 	}
-	err := cookieSession.Save(r, w)
+	sessionID, ok := cookieSession.Values["session"].(string)
+	if !ok {
+		sessionID = wc.newID()
+		cookieSession.Values["session"] = sessionID
+	}
+	variants := make(map[string]string)
+	for _, e := range wc.experiments {
+		v, ok := cookieSession.Values[variantKey(e.name)].(string)
+		if !ok || !contains(e.variants, v) {
+			v = wc.variantAssigner(r, user.(int), e.name, e.variants)
+			cookieSession.Values[variantKey(e.name)] = v
+		}
+		variants[e.name] = v
+	}
+	err = cookieSession.Save(r, w)
 	if err != nil {
-		log.Printf("getUser err %v\n", err)
-		return -1, err
+		wc.logger.Error("saving session cookie", "err", err)
+		return -1, "", nil, err
 	}
 
-	return user.(int), nil
+	return user.(int), sessionID, variants, nil
 }
 
 func (wc *websocketController) Handler(view View) http.HandlerFunc {
-	viewTemplate, err := parseTemplate(wc.projectRoot, view)
-	if err != nil {
-		panic(err)
-	}
+	return wc.handler(view, "")
+}
 
-	errorViewTemplate, err := parseTemplate(wc.projectRoot, wc.errorView)
-	if err != nil {
-		panic(err)
+// Fragment returns a handler which renders the view as an embeddable fragment: the layout is skipped,
+// the content is wrapped in an element with id containerID, the connections are subscribed to a topic
+// scoped to the fragment and all DOM selectors are resolved relative to the container.
+// It can be used to drop a live widget into an existing server-rendered or static page.
+func (wc *websocketController) Fragment(containerID string, view View) http.HandlerFunc {
+	if containerID == "" {
+		panic("fragment container id is required")
 	}
+	return wc.handler(fragmentView{View: view}, containerID)
+}
+
+func (wc *websocketController) handler(view View, fragmentID string) http.HandlerFunc {
+	viewTemplate, errorViewTemplate := wc.compiledTemplates(view)
 
 	mountData := make(M)
-	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := wc.getUser(w, r)
+	var socketKey string
+	newViewHandler := func(w http.ResponseWriter, r *http.Request) *viewHandler {
+		user, sessionID, variants, err := wc.getUser(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			wc.reject(w, r, err)
+			return nil
 		}
-		v := &viewHandler{
+		return &viewHandler{
 			view:              view,
 			errorView:         wc.errorView,
-			viewTemplate:      viewTemplate,
-			errorViewTemplate: errorViewTemplate,
+			compiledView:      viewTemplate,
+			compiledErrorView: errorViewTemplate,
 			mountData:         mountData,
 			wc:                wc,
 			user:              user,
+			variants:          variants,
+			sessionID:         sessionID,
+			fragmentID:        fragmentID,
+			socketKey:         socketKey,
 		}
-		if r.Header.Get("Connection") == "Upgrade" &&
-			r.Header.Get("Upgrade") == "websocket" {
-			onLiveEvent(w, r, v)
-		} else {
-			onMount(w, r, v)
-		}
 	}
+	name := viewName(view)
+	if fragmentID != "" {
+		name += "#" + fragmentID
+	}
+	socketKey = wc.socketViews.add(name, newViewHandler)
+
+	return wc.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if wc.rejectShutdown(w) {
+			return
+		}
+		if isStreamEvent(r) {
+			wc.serveStreamEvent(w, r)
+			return
+		}
+		if IsUpgrade(r) || IsEventStream(r) {
+			if wc.socketPath != "" {
+				http.Error(w, fmt.Sprintf("websocket is served at %s", wc.socketPath), http.StatusNotFound)
+				return
+			}
+			wc.serveLive(w, r, r.URL.Query().Get(CSRFTokenKey), newViewHandler)
+			return
+		}
+		if wc.overloaded() {
+			// rounded up since a Retry-After of 0 asks to retry at once
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Max(1, math.Ceil(wc.retryAfter.Seconds())))))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		v := newViewHandler(w, r)
+		if v == nil {
+			return
+		}
+		if !wc.verifyMountCSRF(w, r, v.sessionID) {
+			return
+		}
+		onMount(w, r, v)
+	})
 }
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type CRDTKind string
+
+const (
+	CounterCRDT CRDTKind = "counter"
+	MapCRDT     CRDTKind = "map"
+	ListCRDT    CRDTKind = "list"
+	TextCRDT    CRDTKind = "text"
+)
+
+type CRDTAction string
+
+const (
+	CRDTIncrement CRDTAction = "increment"
+	CRDTSetKey    CRDTAction = "set"
+	CRDTDeleteKey CRDTAction = "delete"
+	CRDTInsert    CRDTAction = "insert"
+	CRDTRemove    CRDTAction = "remove"
+)
+
+// CRDTID uniquely identifies an operation and, for lists, the element it inserted.
+// IDs are ordered by the lamport clock and then by replica.
+type CRDTID struct {
+	Clock   uint64 `json:"clock"`
+	Replica string `json:"replica"`
+}
+
+func (c CRDTID) IsZero() bool {
+	return c.Clock == 0 && c.Replica == ""
+}
+
+func (c CRDTID) Less(o CRDTID) bool {
+	if c.Clock != o.Clock {
+		return c.Clock < o.Clock
+	}
+	return c.Replica < o.Replica
+}
+
+// CRDTOp is a single operation on a named CRDT of a Doc. Ops are commutative and idempotent
+// so they can be applied in any order, any number of times, from any replica.
+type CRDTOp struct {
+	ID     CRDTID      `json:"id"`
+	Kind   CRDTKind    `json:"kind"`
+	Name   string      `json:"name"`
+	Action CRDTAction  `json:"action"`
+	Key    string      `json:"key,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Delta  int64       `json:"delta,omitempty"`
+	// Ref is the element after which CRDTInsert places the value or the element removed by CRDTRemove.
+	Ref CRDTID `json:"ref,omitempty"`
+}
+
+type lwwEntry struct {
+	id      CRDTID
+	value   interface{}
+	deleted bool
+}
+
+// ListElement is a visible element of a list or text CRDT.
+type ListElement struct {
+	ID    CRDTID      `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+type rgaElement struct {
+	ListElement
+	deleted bool
+}
+
+// Doc is a container of named counters, maps, lists and texts which converge when replicas
+// apply the same set of operations. It is safe for concurrent use.
+type Doc struct {
+	replica  string
+	clock    uint64
+	seen     map[CRDTID]struct{}
+	counters map[string]int64
+	maps     map[string]map[string]*lwwEntry
+	lists    map[string][]*rgaElement
+	// pending are the list ops received before the element they refer to, by the id of the element.
+	pending map[CRDTID][]CRDTOp
+	sync.Mutex
+}
+
+func NewDoc(replica string) *Doc {
+	return &Doc{
+		replica:  replica,
+		seen:     make(map[CRDTID]struct{}),
+		counters: make(map[string]int64),
+		maps:     make(map[string]map[string]*lwwEntry),
+		lists:    make(map[string][]*rgaElement),
+		pending:  make(map[CRDTID][]CRDTOp),
+	}
+}
+
+// Apply merges the ops into the doc and returns the ops which changed it. The returned ops are the minimal
+// set to be broadcast to the other replicas. A list op which refers to an element not received yet, e.g. relayed
+// by another replica before the insert of the element, is buffered until the element is inserted.
+func (d *Doc) Apply(ops ...CRDTOp) ([]CRDTOp, error) {
+	d.Lock()
+	defer d.Unlock()
+	var applied []CRDTOp
+	for _, op := range ops {
+		ok, err := d.apply(op)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied = append(applied, op)
+		}
+	}
+	return applied, nil
+}
+
+func (d *Doc) apply(op CRDTOp) (bool, error) {
+	if op.ID.IsZero() {
+		return false, fmt.Errorf("crdt op %s %s on %s: id is required", op.Kind, op.Action, op.Name)
+	}
+	if _, ok := d.seen[op.ID]; ok {
+		return false, nil
+	}
+	if op.ID.Clock > d.clock {
+		d.clock = op.ID.Clock
+	}
+
+	var err error
+	switch op.Kind {
+	case CounterCRDT:
+		err = d.applyCounter(op)
+	case MapCRDT:
+		err = d.applyMap(op)
+	case ListCRDT, TextCRDT:
+		err = d.applyList(op)
+	default:
+		err = fmt.Errorf("crdt op on %s: unknown kind %q", op.Name, op.Kind)
+	}
+	if err != nil {
+		return false, err
+	}
+	d.seen[op.ID] = struct{}{}
+	return true, nil
+}
+
+func (d *Doc) applyCounter(op CRDTOp) error {
+	if op.Action != CRDTIncrement {
+		return fmt.Errorf("crdt counter %s: unsupported action %q", op.Name, op.Action)
+	}
+	d.counters[op.Name] += op.Delta
+	return nil
+}
+
+func (d *Doc) applyMap(op CRDTOp) error {
+	if op.Action != CRDTSetKey && op.Action != CRDTDeleteKey {
+		return fmt.Errorf("crdt map %s: unsupported action %q", op.Name, op.Action)
+	}
+	m, ok := d.maps[op.Name]
+	if !ok {
+		m = make(map[string]*lwwEntry)
+		d.maps[op.Name] = m
+	}
+	// last writer wins
+	if e, ok := m[op.Key]; ok && !e.id.Less(op.ID) {
+		return nil
+	}
+	m[op.Key] = &lwwEntry{id: op.ID, value: op.Value, deleted: op.Action == CRDTDeleteKey}
+	return nil
+}
+
+func (d *Doc) applyList(op CRDTOp) error {
+	list := d.lists[op.Name]
+	switch op.Action {
+	case CRDTInsert:
+		pos := 0
+		if !op.Ref.IsZero() {
+			i := indexOf(list, op.Ref)
+			if i < 0 {
+				d.pending[op.Ref] = append(d.pending[op.Ref], op)
+				return nil
+			}
+			pos = i + 1
+		}
+		// concurrent inserts at the same position are ordered by descending id
+		for pos < len(list) && op.ID.Less(list[pos].ID) {
+			pos++
+		}
+		e := &rgaElement{ListElement: ListElement{ID: op.ID, Value: op.Value}}
+		list = append(list, nil)
+		copy(list[pos+1:], list[pos:])
+		list[pos] = e
+		d.lists[op.Name] = list
+		pending := d.pending[op.ID]
+		delete(d.pending, op.ID)
+		for _, p := range pending {
+			if err := d.applyList(p); err != nil {
+				return err
+			}
+		}
+	case CRDTRemove:
+		i := indexOf(list, op.Ref)
+		if i < 0 {
+			d.pending[op.Ref] = append(d.pending[op.Ref], op)
+			return nil
+		}
+		list[i].deleted = true
+	default:
+		return fmt.Errorf("crdt list %s: unsupported action %q", op.Name, op.Action)
+	}
+	return nil
+}
+
+func indexOf(list []*rgaElement, id CRDTID) int {
+	for i, e := range list {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Doc) has(name string, id CRDTID) bool {
+	d.Lock()
+	defer d.Unlock()
+	return indexOf(d.lists[name], id) >= 0
+}
+
+func (d *Doc) next(kind CRDTKind, name string, action CRDTAction) CRDTOp {
+	d.Lock()
+	defer d.Unlock()
+	d.clock++
+	return CRDTOp{
+		ID:     CRDTID{Clock: d.clock, Replica: d.replica},
+		Kind:   kind,
+		Name:   name,
+		Action: action,
+	}
+}
+
+func (d *Doc) local(op CRDTOp) CRDTOp {
+	if _, err := d.Apply(op); err != nil {
+		panic(err)
+	}
+	return op
+}
+
+// Increment adds delta to the named counter and returns the op to broadcast.
+func (d *Doc) Increment(name string, delta int64) CRDTOp {
+	op := d.next(CounterCRDT, name, CRDTIncrement)
+	op.Delta = delta
+	return d.local(op)
+}
+
+// Set sets key of the named map and returns the op to broadcast.
+func (d *Doc) Set(name, key string, value interface{}) CRDTOp {
+	op := d.next(MapCRDT, name, CRDTSetKey)
+	op.Key = key
+	op.Value = value
+	return d.local(op)
+}
+
+// Delete deletes key from the named map and returns the op to broadcast.
+func (d *Doc) Delete(name, key string) CRDTOp {
+	op := d.next(MapCRDT, name, CRDTDeleteKey)
+	op.Key = key
+	return d.local(op)
+}
+
+// Insert inserts value after the element after, or at the head if after is zero, of the named list.
+func (d *Doc) Insert(kind CRDTKind, name string, after CRDTID, value interface{}) (CRDTOp, error) {
+	if !after.IsZero() && !d.has(name, after) {
+		return CRDTOp{}, fmt.Errorf("crdt list %s: insert after unknown element %+v", name, after)
+	}
+	op := d.next(kind, name, CRDTInsert)
+	op.Ref = after
+	op.Value = value
+	_, err := d.Apply(op)
+	return op, err
+}
+
+// Remove removes the element id from the named list.
+func (d *Doc) Remove(kind CRDTKind, name string, id CRDTID) (CRDTOp, error) {
+	if !d.has(name, id) {
+		return CRDTOp{}, fmt.Errorf("crdt list %s: remove unknown element %+v", name, id)
+	}
+	op := d.next(kind, name, CRDTRemove)
+	op.Ref = id
+	_, err := d.Apply(op)
+	return op, err
+}
+
+func (d *Doc) Counter(name string) int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.counters[name]
+}
+
+func (d *Doc) Map(name string) M {
+	d.Lock()
+	defer d.Unlock()
+	m := make(M)
+	for k, e := range d.maps[name] {
+		if e.deleted {
+			continue
+		}
+		m[k] = e.value
+	}
+	return m
+}
+
+func (d *Doc) List(name string) []ListElement {
+	d.Lock()
+	defer d.Unlock()
+	var elements []ListElement
+	for _, e := range d.lists[name] {
+		if e.deleted {
+			continue
+		}
+		elements = append(elements, e.ListElement)
+	}
+	return elements
+}
+
+func (d *Doc) Text(name string) string {
+	var b strings.Builder
+	for _, e := range d.List(name) {
+		b.WriteString(fmt.Sprint(e.Value))
+	}
+	return b.String()
+}
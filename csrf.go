@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// CSRFTokenKey is the key of the CSRF token in the mount data when EnableCSRF is set. It's also the name of the
+// query parameter of the live connection url and of the form field of a form posted on mount carrying the token.
+// The token is read in the templates with the csrfToken and csrfField template funcs, e.g. in the layout
+// <meta name="glv-csrf-token" content="{{csrfToken .}}">.
+const CSRFTokenKey = "glv_csrf_token"
+
+// CSRFTokenHeader is the header carrying the CSRF token of a form posted on mount with fetch.
+const CSRFTokenHeader = "X-CSRF-Token"
+
+var (
+	ErrInvalidCSRFToken = errors.New("invalid csrf token")
+	ErrOriginNotAllowed = errors.New("origin not allowed")
+)
+
+// EnableCSRF requires the live connections and the forms posted on mount to present the CSRF token rendered in
+// the page, so that another site can't open a connection or submit a form with the cookies of the user. The token
+// is bound to the session cookie and signed with key. If key is nil, it's generated at startup: the tokens of the
+// pages rendered before a restart or by another instance are then rejected.
+func EnableCSRF(key []byte) Option {
+	return func(o *controlOpt) {
+		o.csrf = true
+		o.csrfKey = key
+	}
+}
+
+// WithAllowedOrigins accepts the live connections from the pages of origins besides the origin of the request,
+// e.g. https://app.example.com. An origin can start with a wildcard subdomain e.g. https://*.example.com, and *
+// accepts all the origins. It replaces the CheckOrigin of the upgrader and is also enforced for the server-sent
+// events streams. By default only the same origin is accepted.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(o *controlOpt) {
+		o.allowedOrigins = origins
+	}
+}
+
+func newCSRFKey(key []byte) []byte {
+	if len(key) == 0 {
+		return securecookie.GenerateRandomKey(32)
+	}
+	return key
+}
+
+// csrfToken returns the CSRF token of the session.
+func (wc *websocketController) csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, wc.csrfKey)
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (wc *websocketController) validCSRFToken(token, sessionID string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(wc.csrfToken(sessionID)))
+}
+
+// verifyLiveCSRF checks the token of a new live connection of the session. It answers 403 and returns false if the
+// token is missing or invalid.
+func (wc *websocketController) verifyLiveCSRF(w http.ResponseWriter, token, sessionID string) bool {
+	if !wc.csrf || wc.validCSRFToken(token, sessionID) {
+		return true
+	}
+	wc.logger.Warn("rejecting live connection", "session", sessionID, "err", ErrInvalidCSRFToken)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// verifyMountCSRF checks the token of a request to mount a view with an unsafe method e.g. a form posted to the
+// view. The token is read from the CSRFTokenHeader header, then the CSRFTokenKey form field. It answers 403 and
+// returns false if the token is missing or invalid.
+func (wc *websocketController) verifyMountCSRF(w http.ResponseWriter, r *http.Request, sessionID string) bool {
+	if !wc.csrf {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	token := r.Header.Get(CSRFTokenHeader)
+	if token == "" {
+		if err := r.ParseMultipartForm(maxFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			wc.logger.Warn("parsing form", "path", r.URL.Path, "err", err)
+		}
+		token = r.PostFormValue(CSRFTokenKey)
+	}
+	if wc.validCSRFToken(token, sessionID) {
+		return true
+	}
+	wc.logger.Warn("rejecting request", "method", r.Method, "path", r.URL.Path, "session", sessionID, "err", ErrInvalidCSRFToken)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// verifyOrigin answers 403 and returns false if the origin of a live connection isn't allowed.
+func (wc *websocketController) verifyOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if wc.checkOrigin(r) {
+		return true
+	}
+	wc.logger.Warn("rejecting live connection", "origin", r.Header.Get("Origin"), "err", ErrOriginNotAllowed)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// checkOrigin reports whether the Origin header of r is absent, e.g. a client which isn't a browser, the origin of
+// the request or in the allowed origins. Without allowed origins, the CheckOrigin of the upgrader is used if set.
+func (wc *websocketController) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(wc.allowedOrigins) == 0 && wc.upgrader.CheckOrigin != nil {
+		return wc.upgrader.CheckOrigin(r)
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range wc.allowedOrigins {
+		if allowedOrigin(allowed, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin reports whether origin matches the allowed origin, which can start with a wildcard subdomain.
+func allowedOrigin(allowed string, origin *url.URL) bool {
+	if allowed == "*" {
+		return true
+	}
+	a, err := url.Parse(allowed)
+	if err != nil || !strings.EqualFold(a.Scheme, origin.Scheme) {
+		return false
+	}
+	if suffix := strings.TrimPrefix(a.Host, "*"); suffix != a.Host {
+		host := strings.ToLower(origin.Host)
+		return strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, strings.ToLower(suffix)) && len(host) > len(suffix)
+	}
+	return strings.EqualFold(a.Host, origin.Host)
+}
+
+// csrfTokenFunc is the template func returning the CSRF token in the CSRFTokenKey data.
+func csrfTokenFunc(data interface{}) string {
+	m, ok := data.(M)
+	if !ok {
+		return ""
+	}
+	token, _ := m[CSRFTokenKey].(string)
+	return token
+}
+
+// csrfField is the template func rendering the hidden input of the CSRF token in a form posted on mount, e.g.
+// <form method="post">{{csrfField .}}...</form>.
+func csrfField(data interface{}) template.HTML {
+	token := csrfTokenFunc(data)
+	if token == "" {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, CSRFTokenKey, template.HTMLEscapeString(token)))
+}
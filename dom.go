@@ -2,10 +2,12 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"html/template"
-	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/yosssi/gohtml"
 )
@@ -23,18 +25,144 @@ const (
 	RemoveClass      Op = "removeClass"
 	SetValue         Op = "setValue"
 	SetInnerHTML     Op = "setInnerHTML"
+	Announce         Op = "announce"
+	CRDT             Op = "crdt"
+	Retry            Op = "retry"
+	Maintenance      Op = "maintenance"
+	SetCookie        Op = "setCookie"
+	Eval             Op = "eval"
+	SetMeta          Op = "setMeta"
+	Generation       Op = "generation"
+	BindKey          Op = "bindKey"
+	UnbindKey        Op = "unbindKey"
+	StartInterval    Op = "startInterval"
+	StopInterval     Op = "stopInterval"
+	Console          Op = "console"
+	Disable          Op = "disable"
+	Enable           Op = "enable"
+	Busy             Op = "busy"
+	Idle             Op = "idle"
+	Encrypted        Op = "encrypted"
+	ReconnectToken   Op = "reconnectToken"
+	StateToken       Op = "stateToken"
+	Append           Op = "append"
+	Prepend          Op = "prepend"
+	InsertBefore     Op = "insertBefore"
+	InsertAfter      Op = "insertAfter"
+	RemoveElement    Op = "remove"
+	Presence         Op = "presence"
+	PresenceState    Op = "presenceState"
+	Redirect         Op = "redirect"
+	PushState        Op = "pushState"
+	SetFieldErrors   Op = "setFieldErrors"
+	UploadProgress   Op = "uploadProgress"
+	Error            Op = "error"
 )
 
+// Politeness is the aria-live setting used when announcing a message to assistive technologies.
+type Politeness string
+
+const (
+	Polite    Politeness = "polite"
+	Assertive Politeness = "assertive"
+)
+
+// AnnounceRegionID is the id of the aria-live region updated by DOM.Announce.
+const AnnounceRegionID = "glv-announcer"
+
+// announceRegion is injected into the rendered page when EnableAnnounceRegion is set.
+const announceRegion = `<div id="` + AnnounceRegionID + `" aria-live="polite" aria-atomic="true" ` +
+	`style="position:absolute;width:1px;height:1px;margin:-1px;padding:0;overflow:hidden;clip:rect(0,0,0,0);border:0"></div>`
+
 type Operation struct {
 	Op       Op          `json:"op"`
 	Selector string      `json:"selector"`
 	Value    interface{} `json:"value"`
+	Hints    *Hints      `json:"hints,omitempty"`
+	Timing   *Timing     `json:"timing,omitempty"`
+	// Hash identifies the rendered html when EnableFragmentCache is set. A null Value with a Hash tells the
+	// client to reuse the html it cached for the hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Timing is attached to the operations when EnableServerTiming is set so that the client can
+// display the end-to-end latency of an interaction.
+type Timing struct {
+	// ReceivedAt is the unix time in milliseconds when the server received the event.
+	ReceivedAt int64 `json:"receivedAt"`
+	// SentAt is the unix time in milliseconds when the server sent the operation.
+	SentAt int64 `json:"sentAt"`
+	// HandlerMs is the time spent handling the event until the operation was sent.
+	HandlerMs float64 `json:"handlerMs"`
+}
+
+// Hints tell the client how to treat the surrounding page state while applying an operation.
+type Hints struct {
+	// PreserveScroll keeps the scroll position of the target and its scrollable ancestors.
+	PreserveScroll bool `json:"preserveScroll,omitempty"`
+	// ScrollAnchor is a selector of an element whose viewport position is kept stable.
+	ScrollAnchor string `json:"scrollAnchor,omitempty"`
+	// PreserveFocus restores focus and the cursor/selection range of the active input
+	// when the operation replaces one of its ancestors.
+	PreserveFocus bool `json:"preserveFocus,omitempty"`
+	// Sensitive marks the operation to be encrypted for the connection of the event. The client only sees the
+	// encrypted op.
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+type Hint func(h *Hints)
+
+// PreserveScroll keeps the scroll position when the target is replaced e.g. a long list.
+func PreserveScroll() Hint {
+	return func(h *Hints) {
+		h.PreserveScroll = true
+	}
+}
+
+// AnchorTo keeps the element matched by selector at the same viewport position after the operation.
+func AnchorTo(selector string) Hint {
+	return func(h *Hints) {
+		h.ScrollAnchor = selector
+	}
+}
+
+// PreserveFocus keeps the active input focused with its caret and selection intact
+// so that typing isn't interrupted by a server update.
+func PreserveFocus() Hint {
+	return func(h *Hints) {
+		h.PreserveFocus = true
+	}
+}
+
+func newHints(hints []Hint) *Hints {
+	if len(hints) == 0 {
+		return nil
+	}
+	h := &Hints{}
+	for _, hint := range hints {
+		hint(h)
+	}
+	return h
+}
+
+func (d *dom) hints(hints []Hint) *Hints {
+	h := newHints(hints)
+	if h == nil {
+		return nil
+	}
+	h.ScrollAnchor = d.scoped(h.ScrollAnchor)
+	return h
 }
 
 func (m *Operation) Bytes() []byte {
+	return m.encode(stdLogger{})
+}
+
+// encode marshals the operation, logging the error to logger.
+func (m *Operation) encode(logger Logger) []byte {
 	b, err := json.Marshal(m)
 	if err != nil {
-		log.Printf("error marshalling dom %v\n", err)
+		logger.Error("marshaling operation", "op", m.Op, "err", err)
 		return nil
 	}
 	return b
@@ -44,40 +172,133 @@ type DOM interface {
 	SetDataset(selector string, data M)
 	SetAttributes(selector string, data M)
 	SetValue(selector string, value interface{})
-	SetInnerHTML(selector string, value interface{})
+	SetInnerHTML(selector string, value interface{}, hints ...Hint)
 	RemoveAttributes(selector string, data []string)
 	ToggleClassList(selector string, classList map[string]bool)
 	AddClass(selector, class string)
 	RemoveClass(selector, class string)
-	Morph(selector, template string, data M)
+	Morph(selector, template string, data M, hints ...Hint)
+	Append(selector, template string, data M)
+	Prepend(selector, template string, data M)
+	InsertBefore(selector, template string, data M)
+	InsertAfter(selector, template string, data M)
+	Remove(selector string)
 	Reload()
+	Redirect(url string)
+	PushState(url string, replace bool)
+	Announce(message string, politeness Politeness)
+	ApplyCRDT(selector string, ops []CRDTOp)
+	Eval(script string)
+	BindKey(shortcut, eventID string, params M)
+	UnbindKey(shortcut string)
+	StartInterval(id string, every time.Duration, event Event)
+	StopInterval(id string)
+	ConsoleLog(level ConsoleLevel, args ...interface{})
+	Disable(selector string)
+	Enable(selector string)
+	SetBusy(selector string, timeout time.Duration)
+	SetFieldErrors(selector string, errs FieldErrors)
+	// Flush sends the operations batched so far when EnableBatching is set.
+	Flush()
+	// Self returns a DOM sending the operations only to the connection of the event.
+	Self() DOM
+	// Others returns a DOM sending the operations to the other connections of the topic.
+	Others() DOM
+	// Broadcast returns a DOM sending the operations to all the connections of the topic.
+	Broadcast() DOM
 }
 
 type dom struct {
-	rootTemplate  *template.Template
-	store         Store
-	temporaryKeys []string
-	topic         string
-	wc            *websocketController
+	rootTemplate   *template.Template
+	store          Store
+	temporaryKeys  []string
+	persistentKeys []string
+	busy           bool
+	topic          string
+	wc             *websocketController
+	selectorPrefix string
+	classScope     string
+	receivedAt     time.Time
+	eventID        string
+	batch          []*Operation
+	batching       bool
+	batchMu        sync.Mutex
+	connID         string
+	conn           Conn
+	target         target
+	ctx            context.Context
+	owned          *ownedKeys
+	nonce          string
+	// variants are the experiment variants of the user, read by the `variant` template func.
+	variants map[string]string
+	// flags are the feature flags of the user, read by the `flag` template func.
+	flags map[string]bool
+	// origin is the dom of the event a Self, Others or Broadcast dom is derived from.
+	origin *dom
+}
+
+func (d *dom) send(m *Operation) {
+	if d.wc.serverTiming && !d.receivedAt.IsZero() {
+		now := time.Now()
+		m.Timing = &Timing{
+			ReceivedAt: d.receivedAt.UnixMilli(),
+			SentAt:     now.UnixMilli(),
+			HandlerMs:  float64(now.Sub(d.receivedAt).Microseconds()) / 1000,
+		}
+	}
+	sensitive := m.Hints != nil && m.Hints.Sensitive
+	if d.wc.encryptOps && sensitive && d.target == toSelf {
+		d.sendSealed(m)
+		return
+	}
+	if d.target != toTopic {
+		d.sendTargeted(m)
+		return
+	}
+	if d.wc.fragmentCacheSize > 0 && !sensitive && (m.Op == Morph || m.Op == SetInnerHTML) {
+		if html, ok := m.Value.(string); ok {
+			m.Hash = fragmentHash(html)
+		}
+	}
+	if d.batched(m) {
+		return
+	}
+	if d.wc.coalescer != nil {
+		if m.Op == Morph {
+			d.wc.coalescer.push(d.topic, m.Selector, m)
+			return
+		}
+		d.wc.coalescer.flush(d.topic)
+	}
+	d.wc.broadcast(d.topic, m)
+}
+
+// scoped resolves the selector relative to the fragment container, if any.
+func (d *dom) scoped(selector string) string {
+	selector = scopeSelector(d.classScope, selector)
+	if d.selectorPrefix == "" || selector == "" {
+		return selector
+	}
+	return d.selectorPrefix + " " + selector
 }
 
 func (d *dom) SetAttributes(selector string, data M) {
 	m := &Operation{
 		Op:       SetAttributes,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    data,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 	d.setStore(data)
 }
 
 func (d *dom) RemoveAttributes(selector string, data []string) {
 	m := &Operation{
 		Op:       RemoveAttributes,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    data,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 }
 
 func (d *dom) SetDataset(selector string, data M) {
@@ -89,10 +310,10 @@ func (d *dom) SetDataset(selector string, data M) {
 
 	m := &Operation{
 		Op:       Dataset,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    dataset,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 	d.setStore(data)
 }
 
@@ -100,15 +321,15 @@ func (d *dom) ToggleClassList(selector string, boolData map[string]bool) {
 
 	classList := make(map[string]interface{})
 	for k, v := range boolData {
-		classList[k] = v
+		classList[scopeClass(d.classScope, k)] = v
 	}
 
 	m := &Operation{
 		Op:       ClassList,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    classList,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 
 	// update inmemStore
 	data := make(map[string]interface{})
@@ -122,10 +343,10 @@ func (d *dom) AddClass(selector, class string) {
 
 	m := &Operation{
 		Op:       AddClass,
-		Selector: selector,
-		Value:    class,
+		Selector: d.scoped(selector),
+		Value:    scopeClass(d.classScope, class),
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 
 	// update store
 	data := make(map[string]interface{})
@@ -137,10 +358,10 @@ func (d *dom) RemoveClass(selector, class string) {
 
 	m := &Operation{
 		Op:       RemoveClass,
-		Selector: selector,
-		Value:    class,
+		Selector: d.scoped(selector),
+		Value:    scopeClass(d.classScope, class),
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 
 	// update store
 	data := make(map[string]interface{})
@@ -152,10 +373,10 @@ func (d *dom) SetValue(selector string, value interface{}) {
 
 	m := &Operation{
 		Op:       SetValue,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    value,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
 
 	// update store
 	data := make(map[string]interface{})
@@ -163,58 +384,143 @@ func (d *dom) SetValue(selector string, value interface{}) {
 	d.setStore(data)
 }
 
-func (d *dom) SetInnerHTML(selector string, value interface{}) {
+func (d *dom) SetInnerHTML(selector string, value interface{}, hints ...Hint) {
 
 	m := &Operation{
 		Op:       SetInnerHTML,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    value,
+		Hints:    d.hints(hints),
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
+}
+
+func (d *dom) Morph(selector, template string, data M, hints ...Hint) {
+	m, ok := d.morphOperation(selector, template, data, hints)
+	if !ok {
+		return
+	}
+	d.send(m)
+	d.setStore(data)
 }
 
-func (d *dom) Morph(selector, template string, data M) {
+// addSelfData adds the timezone, variants and flags of the user of d, and the CSP nonce of its page, to data unless
+// set by the caller.
+func (d *dom) addSelfData(data M) {
+	if d.nonce != "" {
+		data[NonceKey] = d.nonce
+	}
+	if _, ok := data[timezoneKey]; !ok {
+		data[timezoneKey] = storedTimezone(d.store)
+	}
+	if _, ok := data["variants"]; !ok && d.variants != nil {
+		data["variants"] = d.variants
+	}
+	if _, ok := data["flags"]; !ok && d.flags != nil {
+		data["flags"] = d.flags
+	}
+}
+
+// morphOperation renders the template with data. It returns false if the rendering failed. The keys added to the
+// data for the template funcs are set on a copy: data is the caller's and is saved to the Store. The timezone,
+// variants, flags and nonce of the connection are only added for the connection of the event: the operations of
+// the other targets are rendered once for the connections of other users.
+func (d *dom) morphOperation(selector, template string, data M, hints []Hint) (*Operation, bool) {
+	if data != nil {
+		m := make(M, len(data)+5)
+		for k, v := range data {
+			m[k] = v
+		}
+		data = m
+		if d.target == toSelf {
+			d.addSelfData(data)
+		} else if _, ok := data[timezoneKey]; !ok {
+			data[timezoneKey] = ""
+		}
+		if d.classScope != "" {
+			data[ScopeKey] = d.classScope
+		}
+	}
 	var buf bytes.Buffer
+	start := time.Now()
+	_, end := d.wc.tracer.Start(d.context(), "glv.render", "template", template, "event", d.eventID, "topic", d.topic)
 	err := d.rootTemplate.ExecuteTemplate(&buf, template, data)
+	end(err)
+	d.wc.checkSlow("render", d.eventID, template, start)
 	if err != nil {
-		log.Printf("err %v with data => \n %+v\n", err, getJSON(data))
-		return
+		d.wc.logger.Error("rendering template", "topic", d.topic, "event", d.eventID, "template", template, "err", err, "data", getJSON(d.wc.redaction, data))
+		return nil, false
 	}
 	if d.wc.debugLog {
-		log.Printf("rendered template %+v, with data => \n %+v\n", template, getJSON(data))
+		d.wc.logger.Debug("rendered template", "topic", d.topic, "event", d.eventID, "template", template, "data", getJSON(d.wc.redaction, data))
 	}
 	html := buf.String()
 	if d.wc.enableHTMLFormatting {
 		html = gohtml.Format(html)
 	}
-	buf.Reset()
 
-	m := &Operation{
+	return &Operation{
 		Op:       Morph,
-		Selector: selector,
+		Selector: d.scoped(selector),
 		Value:    html,
-	}
-	d.wc.message(d.topic, m.Bytes())
-	d.setStore(data)
+		Hints:    d.hints(hints),
+	}, true
 }
 
 func (d *dom) Reload() {
 	m := &Operation{
 		Op: Reload,
 	}
-	d.wc.message(d.topic, m.Bytes())
+	d.send(m)
+}
+
+// Announce updates the aria-live region so that screen readers announce the message.
+func (d *dom) Announce(message string, politeness Politeness) {
+	if politeness == "" {
+		politeness = Polite
+	}
+	m := &Operation{
+		Op:       Announce,
+		Selector: d.scoped("#" + AnnounceRegionID),
+		Value: M{
+			"message":    message,
+			"politeness": politeness,
+		},
+	}
+	d.send(m)
+}
+
+// ApplyCRDT broadcasts the CRDT ops, usually the ones returned by Doc.Apply, to the element
+// bound to the doc so that the client replicas converge.
+func (d *dom) ApplyCRDT(selector string, ops []CRDTOp) {
+	if len(ops) == 0 {
+		return
+	}
+	m := &Operation{
+		Op:       CRDT,
+		Selector: d.scoped(selector),
+		Value:    ops,
+	}
+	d.send(m)
 }
 
 func (d *dom) setStore(data M) {
 	// delete keys which are marked temporary
-	for _, t := range d.temporaryKeys {
-		delete(data, t)
+	for k := range data {
+		if d.isTemporary(k) {
+			delete(data, k)
+		}
 	}
-	// update inmemStore
-	err := d.store.Put(data)
+	changed := changedKeys(d.store, data)
+	if len(changed) == 0 {
+		return
+	}
+	err := d.store.Put(changed)
 	if err != nil {
-		log.Printf("error inmemStore.set %v\n", err)
+		d.wc.logger.Error("saving data in the store", "topic", d.topic, "event", d.eventID, "err", err)
+		return
 	}
+	d.owned.add(changed)
 }
 
 // https://github.com/siongui/userpages/blob/master/content/code/go/kebab-case-to-camelCase/converter.go
@@ -235,8 +541,8 @@ func kebabToCamelCase(kebab string) (camelCase string) {
 	return
 }
 
-func getJSON(data M) string {
-	b, err := json.MarshalIndent(data, "", " ")
+func getJSON(policy RedactionPolicy, data M) string {
+	b, err := json.MarshalIndent(policy.Redact(data), "", " ")
 	if err != nil {
 		return err.Error()
 	}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Preloader is implemented by views which declare critical assets, e.g. stylesheets, to be hinted before they are mounted.
+type Preloader interface {
+	Preload() []string
+}
+
+// EnableEarlyHints sends a 103 Early Hints response with preload links for the assets, e.g. the client JS, and the
+// ones declared by views implementing Preloader before the view's OnMount runs. The links are also set as Link
+// headers on the final response for the clients and proxies which ignore informational responses. The 103 requires
+// Go 1.19: built with an older Go, only the Link headers are set.
+func EnableEarlyHints(assets ...string) Option {
+	return func(o *controlOpt) {
+		o.earlyHints = true
+		o.preloadAssets = append(o.preloadAssets, assets...)
+	}
+}
+
+func (wc *websocketController) sendEarlyHints(w http.ResponseWriter, r *http.Request, view View) {
+	if !wc.earlyHints || wc.isCrawler(r) {
+		return
+	}
+	assets := wc.preloadAssets
+	if f, ok := view.(fragmentView); ok {
+		view = f.View
+	}
+	if p, ok := view.(Preloader); ok {
+		assets = append(assets[:len(assets):len(assets)], p.Preload()...)
+	}
+	if len(assets) == 0 {
+		return
+	}
+	for _, asset := range assets {
+		w.Header().Add("Link", preloadLink(asset))
+	}
+	if informationalResponses {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
+
+func preloadLink(asset string) string {
+	link := fmt.Sprintf("<%s>; rel=preload", asset)
+	ext := strings.ToLower(path.Ext(strings.SplitN(asset, "?", 2)[0]))
+	switch ext {
+	case ".js", ".mjs":
+		return link + "; as=script"
+	case ".css":
+		return link + "; as=style"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		return link + "; as=font; crossorigin"
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg":
+		return link + "; as=image"
+	}
+	return link + "; as=fetch; crossorigin"
+}
@@ -0,0 +1,7 @@
+//go:build !go1.19
+
+package controller
+
+// informationalResponses reports whether net/http sends the 1xx responses written by a handler: before Go 1.19 a
+// 103 would be sent as the final status of the response.
+const informationalResponses = false
@@ -0,0 +1,6 @@
+//go:build go1.19
+
+package controller
+
+// informationalResponses reports whether net/http sends the 1xx responses written by a handler, from Go 1.19.
+const informationalResponses = true
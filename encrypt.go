@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+// PayloadKey is the key of the user's payload key in the mount data and in the user's store when
+// EnableEncryptedOps is set. The client runtime decrypts the encrypted operations with it, so it must only be
+// rendered into the user's own page.
+const PayloadKey = "glv_payload_key"
+
+// EnableEncryptedOps encrypts the operations sent with the Sensitive hint with a per-user key so that they can't
+// be read or tampered with on a shared infrastructure e.g. a proxy. A sensitive operation is encrypted for each
+// connection it's sent to with the key of the connection's user: the ones for the connection of the event, see
+// DOM.Self, before they leave the handler, the broadcasts by each instance for its own connections once relayed by
+// the Broker. The key is generated at the first mount, kept in the user's store and passed to the page in the
+// PayloadKey mount data, which is always redacted from the logs and the inspector. The encrypted operations are
+// sent as an encrypted op whose value holds the AES-GCM nonce and sealed operation.
+func EnableEncryptedOps() Option {
+	return func(o *controlOpt) {
+		o.encryptOps = true
+	}
+}
+
+// Sensitive encrypts the operation for each connection it's sent to when EnableEncryptedOps is set. It isn't cached
+// by EnableFragmentCache.
+func Sensitive() Hint {
+	return func(h *Hints) {
+		h.Sensitive = true
+	}
+}
+
+// userPayloadKey returns the payload key of the user of store, generating it on the first call.
+func userPayloadKey(store Store) ([]byte, error) {
+	var encoded string
+	if err := store.Get(PayloadKey, &encoded); err == nil && encoded != "" {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	key := securecookie.GenerateRandomKey(32)
+	if key == nil {
+		return nil, fmt.Errorf("generating payload key")
+	}
+	encoded = base64.StdEncoding.EncodeToString(key)
+	if err := store.Put(M{PayloadKey: encoded}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// payloadKeys are the payload keys of the live connections.
+type payloadKeys struct {
+	conns map[string]cipher.AEAD
+	sync.Mutex
+}
+
+func (p *payloadKeys) add(connID string, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.conns == nil {
+		p.conns = make(map[string]cipher.AEAD)
+	}
+	p.conns[connID] = aead
+	return nil
+}
+
+func (p *payloadKeys) get(connID string) (cipher.AEAD, bool) {
+	p.Lock()
+	defer p.Unlock()
+	aead, ok := p.conns[connID]
+	return aead, ok
+}
+
+func (p *payloadKeys) remove(connID string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.conns, connID)
+}
+
+// encryptedValue is the value of an encrypted operation.
+type encryptedValue struct {
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+func sealOperation(aead cipher.AEAD, message []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	m := &Operation{
+		Op: Encrypted,
+		Value: encryptedValue{
+			Nonce: base64.StdEncoding.EncodeToString(nonce),
+			Data:  base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, message, nil)),
+		},
+	}
+	return json.Marshal(m)
+}
+
+// registerPayloadKey keeps the payload key of the user of the connection for the sensitive operations.
+func (wc *websocketController) registerPayloadKey(connID string, store Store) {
+	if !wc.encryptOps || connID == "" {
+		return
+	}
+	key, err := userPayloadKey(store)
+	if err == nil {
+		err = wc.payloadKeys.add(connID, key)
+	}
+	if err != nil {
+		wc.logger.Error("payload key", "conn", connID, "err", err)
+	}
+}
+
+// sendSealed sends the sensitive operation m of a Self DOM to the connection of the event, encrypted with the key of
+// its user. It's dropped outside of a live connection.
+func (d *dom) sendSealed(m *Operation) {
+	d.event().Flush()
+	if d.conn == nil {
+		d.wc.logger.Warn("not the connection of the event, dropping sensitive operation", "topic", d.topic, "event", d.eventID, "op", m.Op)
+		d.wc.metrics.MessageDropped(d.topic, DropNoConnection)
+		return
+	}
+	aead, ok := d.wc.payloadKeys.get(d.connID)
+	if !ok {
+		d.wc.logger.Warn("no payload key, dropping sensitive operation", "topic", d.topic, "conn", d.connID)
+		d.wc.metrics.MessageDropped(d.topic, DropNoPayloadKey)
+		return
+	}
+	sealed, err := sealOperation(aead, m.encode(d.wc.logger))
+	if err != nil {
+		d.wc.logger.Error("encrypting operation", "topic", d.topic, "conn", d.connID, "err", err)
+		return
+	}
+	d.wc.messageConn(d.conn, sealed)
+}
+
+// deliverSealed writes the sensitive operation message received from the broker to the local connections of
+// topic, encrypted with the key of the user of each connection.
+func (wc *websocketController) deliverSealed(topic string, conns []connRef, message []byte) {
+	wc.fanOut.run(conns, func(c connRef) {
+		aead, ok := wc.payloadKeys.get(c.id)
+		if !ok {
+			wc.logger.Warn("no payload key, dropping sensitive operation", "topic", topic, "conn", c.id)
+			wc.metrics.MessageDropped(topic, DropNoPayloadKey)
+			return
+		}
+		sealed, err := sealOperation(aead, message)
+		if err != nil {
+			wc.logger.Error("encrypting operation", "topic", topic, "conn", c.id, "err", err)
+			return
+		}
+		if err := c.conn.Send(sealed); err != nil {
+			wc.logger.Error("writing message, closing conn", "topic", topic, "conn", c.id, "err", err)
+			wc.metrics.MessageDropped(topic, DropWriteFailed)
+			wc.closeConn(c)
+		}
+	})
+	wc.metrics.Broadcast(topic, len(conns))
+}
+
+// sensitive reports whether the message is an operation sent with the Sensitive hint.
+func sensitive(message []byte) bool {
+	var m struct {
+		Hints *Hints `json:"hints"`
+	}
+	return json.Unmarshal(message, &m) == nil && m.Hints != nil && m.Hints.Sensitive
+}
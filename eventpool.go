@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"sync"
+)
+
+// EventOrder decides which events of a connection wait for each other with WithEventWorkers.
+type EventOrder int
+
+const (
+	// OrderPerConnection handles the events of a connection one at a time, in the order they are received. The
+	// reading of the connection isn't held by a slow handler: the heartbeats, the uploads and the rate limits go on.
+	OrderPerConnection EventOrder = iota
+	// OrderPerEvent handles the events of a connection with the same id one at a time, in the order they are
+	// received. The events with different ids are handled concurrently.
+	OrderPerEvent
+)
+
+// WithEventWorkers handles the events on a pool of workers goroutines rather than on the goroutine reading the
+// connection, so that a slow handler, e.g. a database call, doesn't hold the next events of the client. order sets
+// which events wait for each other. The navigation events wait for the events being handled. Handlers running
+// concurrently share the Store of the user and ctx.Context(), so they must be safe for concurrent use.
+func WithEventWorkers(workers int, order EventOrder) Option {
+	return func(o *controlOpt) {
+		o.eventWorkers = workers
+		o.eventOrder = order
+	}
+}
+
+// AsyncEvents is implemented by the views whose handlers of some events can run concurrently with all the other
+// events of the connection, e.g. a slow search which mustn't delay the next keystrokes. The events are handled on
+// the workers of WithEventWorkers, or on a goroutine of their own without, in no particular order.
+type AsyncEvents interface {
+	AsyncEvents() []string
+}
+
+// eventPool runs the event handlers scheduled off the read loops of the connections.
+type eventPool struct {
+	jobs chan func()
+	// queues holds the jobs waiting for the job of the same key being run, a key is present while one runs.
+	queues map[string][]func()
+	sync.Mutex
+}
+
+// newEventPool starts workers goroutines, none runs each job on a goroutine of its own.
+func newEventPool(workers int) *eventPool {
+	p := &eventPool{queues: make(map[string][]func())}
+	if workers <= 0 {
+		return p
+	}
+	p.jobs = make(chan func())
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// run runs job on a free worker, waiting for one if they are all busy.
+func (p *eventPool) run(job func()) {
+	if p.jobs == nil {
+		go job()
+		return
+	}
+	p.jobs <- job
+}
+
+// runOrdered runs job once the jobs scheduled before with the same key have returned.
+func (p *eventPool) runOrdered(key string, job func()) {
+	p.Lock()
+	queue, running := p.queues[key]
+	p.queues[key] = append(queue, job)
+	p.Unlock()
+	if running {
+		return
+	}
+	p.run(func() {
+		p.drain(key)
+	})
+}
+
+// drain runs the jobs of key until its queue is empty.
+func (p *eventPool) drain(key string) {
+	for {
+		p.Lock()
+		queue := p.queues[key]
+		if len(queue) == 0 {
+			delete(p.queues, key)
+			p.Unlock()
+			return
+		}
+		job := queue[0]
+		p.queues[key] = queue[1:]
+		p.Unlock()
+		job()
+	}
+}
+
+// schedule hands event over to the event pool, unless it's handled on the read loop. It reports whether it did.
+func (v *viewHandler) schedule(sessCtx *sessionContext, event Event) bool {
+	async := v.isAsync(event.ID)
+	if event.ID == NavigateEventID || (v.wc.eventWorkers <= 0 && !async) {
+		return false
+	}
+	sessCtx.serial.Lock()
+	forked := sessCtx.fork()
+	sessCtx.serial.Unlock()
+	sessCtx.handling.Add(1)
+	job := func() {
+		defer sessCtx.handling.Done()
+		v.handleEvent(forked, event)
+	}
+	switch {
+	case async:
+		v.wc.eventPool.run(job)
+	case v.wc.eventOrder == OrderPerEvent:
+		v.wc.eventPool.runOrdered(sessCtx.connID+"\n"+event.ID, job)
+	default:
+		v.wc.eventPool.runOrdered(sessCtx.connID, func() {
+			sessCtx.serial.Lock()
+			defer sessCtx.serial.Unlock()
+			job()
+		})
+	}
+	return true
+}
+
+// post runs job with the session of a live connection once the events of the connection being handled in order
+// have returned, so that the hooks called from other goroutines, e.g. OnPresence, don't run concurrently with them.
+// The jobs of a connection run one at a time, in the order they are posted.
+func (wc *websocketController) post(sessCtx *sessionContext, job func(sessCtx *sessionContext)) {
+	wc.posted.runOrdered(sessCtx.connID, func() {
+		sessCtx.serial.Lock()
+		defer sessCtx.serial.Unlock()
+		job(sessCtx)
+	})
+}
+
+func (v *viewHandler) isAsync(eventID string) bool {
+	a, ok := unwrapFragment(v.view).(AsyncEvents)
+	if !ok {
+		return false
+	}
+	for _, id := range a.AsyncEvents() {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// fork returns a copy of the session for an event handled concurrently with the other events of the connection,
+// with a dom of its own since the event being handled is held by the dom.
+func (s *sessionContext) fork() *sessionContext {
+	forked := *s
+	forked.dom = &dom{
+		rootTemplate:   s.dom.rootTemplate,
+		store:          s.dom.store,
+		topic:          s.dom.topic,
+		wc:             s.dom.wc,
+		selectorPrefix: s.dom.selectorPrefix,
+		classScope:     s.dom.classScope,
+		connID:         s.dom.connID,
+		conn:           s.dom.conn,
+		target:         s.dom.target,
+		owned:          s.dom.owned,
+		nonce:          s.dom.nonce,
+		variants:       s.dom.variants,
+		flags:          s.dom.flags,
+	}
+	return &forked
+}
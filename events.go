@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Validator is implemented by the params registered with RegisterEvent which check their values once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// eventDecoder decodes and validates the params of an event.
+type eventDecoder func(e Event) (interface{}, error)
+
+// RegisterEvent decodes the params of the events id into a T before they are handled: the handler reads the
+// value with ctx.Params() or EventParams, e.g.
+//
+//	type AddTodo struct {
+//		Text string `json:"text"`
+//	}
+//
+//	func (a AddTodo) Validate() error {
+//		if a.Text == "" {
+//			return errors.New("the todo is empty")
+//		}
+//		return nil
+//	}
+//
+//	controller.Websocket("todos", controller.RegisterEvent[AddTodo]("todos/add"))
+//
+//	func (t *TodosView) Add(ctx controller.Context) error {
+//		todo := controller.EventParams[AddTodo](ctx)
+//		...
+//	}
+//
+// The params are decoded as JSON, or like Event.DecodeForm if the values of a form are strings. If T implements
+// Validator, Validate is called. The handler isn't called if the params can't be decoded or aren't valid: the
+// error is rendered in the glv-error element instead.
+func RegisterEvent[T any](id string) Option {
+	return func(o *controlOpt) {
+		if o.eventDecoders == nil {
+			o.eventDecoders = make(map[string]eventDecoder)
+		}
+		o.eventDecoders[id] = decodeEvent[T]
+	}
+}
+
+func decodeEvent[T any](e Event) (interface{}, error) {
+	params := new(T)
+	if len(e.Params) != 0 {
+		err := e.DecodeParams(params)
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			// e.g. a number sent as a string by a serialized form
+			err = e.DecodeForm(params)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := interface{}(params).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return *params, nil
+}
+
+// decodeParams decodes the params of the event of ctx if its type is registered with RegisterEvent.
+func (v *viewHandler) decodeParams(sessCtx *sessionContext) error {
+	sessCtx.params = nil
+	decode, ok := v.wc.eventDecoders[sessCtx.event.ID]
+	if !ok {
+		return nil
+	}
+	params, err := decode(sessCtx.event)
+	if err != nil {
+		return fmt.Errorf("event %s params: %w", sessCtx.event.ID, err)
+	}
+	sessCtx.params = params
+	return nil
+}
+
+// Params returns the params of the event decoded into the type registered with RegisterEvent, or nil.
+func (s sessionContext) Params() interface{} {
+	return s.params
+}
+
+// EventParams returns the params of the event of ctx decoded into the T registered with RegisterEvent. It returns
+// the zero T if the event has no params of type T.
+func EventParams[T any](ctx Context) T {
+	params, _ := ctx.Params().(T)
+	return params
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+)
+
+type experiment struct {
+	name     string
+	variants []string
+}
+
+// VariantAssigner picks one of the variants of the experiment name for a new user.
+type VariantAssigner func(r *http.Request, user int, name string, variants []string) string
+
+// WithExperiment registers an A/B test. A variant is assigned to each user on its first visit, persisted in the
+// session cookie and exposed through Context.Variant and the `variant` template func: {{ if eq (variant . "checkout") "b" }}
+func WithExperiment(name string, variants ...string) Option {
+	return func(o *controlOpt) {
+		if len(variants) == 0 {
+			panic(fmt.Sprintf("experiment %s: variants are required", name))
+		}
+		o.experiments = append(o.experiments, experiment{name: name, variants: variants})
+	}
+}
+
+// WithVariantAssigner overrides the default assignment which spreads the users evenly and deterministically.
+func WithVariantAssigner(assigner VariantAssigner) Option {
+	return func(o *controlOpt) {
+		o.variantAssigner = assigner
+	}
+}
+
+func assignVariant(r *http.Request, user int, name string, variants []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%s:%d", name, user)))
+	return variants[h.Sum32()%uint32(len(variants))]
+}
+
+func variantKey(name string) string {
+	return "variant:" + name
+}
+
+// variant is the template func which looks up the variant from the view data. The DOM adds the variants of the user
+// to the data of the templates it renders for the connection of the event, see DOM.Self.
+func variant(data interface{}, name string) string {
+	m, ok := data.(M)
+	if !ok {
+		return ""
+	}
+	variants, ok := m["variants"].(map[string]string)
+	if !ok {
+		return ""
+	}
+	return variants[name]
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yosssi/gohtml"
+)
+
+// Render writes the html of the view, honoring its layout and partials, executed with data. OnMount isn't
+// called, the output is a static snapshot for e.g. prerendered landing pages, emails or exports.
+func (wc *websocketController) Render(w io.Writer, view View, data M) error {
+	t, err := parseTemplate(wc.templates(view), view, wc.funcs)
+	if err != nil {
+		return err
+	}
+	mountData := M{"app_name": wc.name}
+	for k, v := range data {
+		mountData[k] = v
+	}
+	t.Option("missingkey=zero")
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, mountData); err != nil {
+		return err
+	}
+	html := buf.Bytes()
+	if wc.enableHTMLFormatting {
+		html = gohtml.FormatBytes(html)
+	}
+	_, err = w.Write(html)
+	return err
+}
+
+// Export renders the view with data to the file at path, creating its parent directories.
+func (wc *websocketController) Export(path string, view View, data M) error {
+	var buf bytes.Buffer
+	if err := wc.Render(&buf, view, data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// minFanOutPartition is the minimum number of connections written by a fan-out worker: the smaller broadcasts
+// use fewer workers, down to none, since handing the writes over costs more than doing them.
+const minFanOutPartition = 64
+
+// WithFanOutWorkers sets the number of goroutines writing the broadcasts, each to a partition of the connections
+// of the topic, so that the connections are written concurrently and a slow one only delays its partition. The
+// broadcasts of a topic are still delivered one at a time, so that its connections receive them in the same order.
+// Defaults to GOMAXPROCS.
+func WithFanOutWorkers(n int) Option {
+	return func(o *controlOpt) {
+		o.fanOutWorkers = n
+	}
+}
+
+// topicLocks serializes the deliveries of each topic so that all its connections receive the broadcasts in the
+// same order, while the partitions of a delivery are written in parallel.
+type topicLocks struct {
+	locks map[string]*topicLock
+	sync.Mutex
+}
+
+type topicLock struct {
+	sync.Mutex
+	// waiters counts the deliveries holding or waiting for the lock, which is dropped once there are none.
+	waiters int
+}
+
+// lock locks topic and returns the func unlocking it.
+func (t *topicLocks) lock(topic string) func() {
+	t.Lock()
+	if t.locks == nil {
+		t.locks = make(map[string]*topicLock)
+	}
+	l, ok := t.locks[topic]
+	if !ok {
+		l = &topicLock{}
+		t.locks[topic] = l
+	}
+	l.waiters++
+	t.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(t.locks, topic)
+		}
+		t.Unlock()
+	}
+}
+
+// connRef is a connection of a snapshot of the registry.
+type connRef struct {
+	id   string
+	conn Conn
+}
+
+// fanOut is the pool of the goroutines writing the broadcasts.
+type fanOut struct {
+	workers int
+	jobs    chan func()
+}
+
+func newFanOut(workers int) *fanOut {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	f := &fanOut{workers: workers, jobs: make(chan func(), workers)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range f.jobs {
+				job()
+			}
+		}()
+	}
+	return f
+}
+
+// run calls write for each connection and returns once they are all written. The connections are partitioned
+// across the workers, the caller writing the last partition.
+func (f *fanOut) run(conns []connRef, write func(c connRef)) {
+	parts := len(conns) / minFanOutPartition
+	if parts > f.workers {
+		parts = f.workers
+	}
+	if parts <= 1 {
+		for _, c := range conns {
+			write(c)
+		}
+		return
+	}
+	size := (len(conns) + parts - 1) / parts
+	var wg sync.WaitGroup
+	start := 0
+	for ; start+size < len(conns); start += size {
+		partition := conns[start : start+size]
+		wg.Add(1)
+		f.jobs <- func() {
+			defer wg.Done()
+			for _, c := range partition {
+				write(c)
+			}
+		}
+	}
+	for _, c := range conns[start:] {
+		write(c)
+	}
+	wg.Wait()
+}
+
+// topicConns returns a snapshot of the connections of topic but except, so that the registry isn't locked while
+// they are written. It returns false if the topic has no local connection.
+func (wc *websocketController) topicConns(topic, except string) ([]connRef, bool) {
+	wc.RLock()
+	defer wc.RUnlock()
+	conns, ok := wc.topicConnections[topic]
+	if !ok {
+		return nil, false
+	}
+	refs := make([]connRef, 0, len(conns))
+	for connID, conn := range conns {
+		if connID == except {
+			continue
+		}
+		refs = append(refs, connRef{id: connID, conn: conn})
+	}
+	return refs, true
+}
+
+// allConns returns a snapshot of all the local connections, each once even if it's subscribed to several topics.
+func (wc *websocketController) allConns() []connRef {
+	wc.RLock()
+	defer wc.RUnlock()
+	seen := make(map[string]bool)
+	var refs []connRef
+	for _, conns := range wc.topicConnections {
+		for connID, conn := range conns {
+			if seen[connID] {
+				continue
+			}
+			seen[connID] = true
+			refs = append(refs, connRef{id: connID, conn: conn})
+		}
+	}
+	return refs
+}
+
+// broadcastPrepared writes the message, prepared once for all the websocket connections, to conns. The
+// connections which fail are closed, their read loop removes them.
+func (wc *websocketController) broadcastPrepared(topic string, conns []connRef, message []byte) {
+	preparedMessage, err := websocket.NewPreparedMessage(websocket.TextMessage, message)
+	if err != nil {
+		wc.logger.Error("preparing message", "topic", topic, "err", err)
+		return
+	}
+	wc.fanOut.run(conns, func(c connRef) {
+		if err := writePrepared(c.conn, preparedMessage, message); err != nil {
+			wc.logger.Error("writing message, closing conn", "topic", topic, "conn", c.id, "err", err)
+			wc.metrics.MessageDropped(topic, DropWriteFailed)
+			wc.closeConn(c)
+		}
+	})
+	wc.metrics.Broadcast(topic, len(conns))
+}
@@ -0,0 +1,56 @@
+package controller
+
+import "net/http"
+
+// FlagProvider evaluates feature flags for a user.
+type FlagProvider interface {
+	// Enabled reports whether the flag name is on for the user.
+	Enabled(r *http.Request, user int, name string) bool
+	// Flags returns all the flags evaluated for the user. They are exposed to the templates through the `flag` func.
+	Flags(r *http.Request, user int) map[string]bool
+}
+
+// StaticFlags is a FlagProvider which returns the same flags for every user.
+type StaticFlags map[string]bool
+
+func (s StaticFlags) Enabled(r *http.Request, user int, name string) bool {
+	return s[name]
+}
+
+func (s StaticFlags) Flags(r *http.Request, user int) map[string]bool {
+	flags := make(map[string]bool, len(s))
+	for k, v := range s {
+		flags[k] = v
+	}
+	return flags
+}
+
+// WithFlagProvider configures the provider used by Context.Flag and the `flag` template func: {{ if flag . "new-nav" }}
+func WithFlagProvider(provider FlagProvider) Option {
+	return func(o *controlOpt) {
+		o.flagProvider = provider
+	}
+}
+
+// flags returns the flags of the user of v evaluated for r, nil without a FlagProvider.
+func (v *viewHandler) flags(r *http.Request) map[string]bool {
+	if v.wc.flagProvider == nil {
+		return nil
+	}
+	return v.wc.flagProvider.Flags(r, v.user)
+}
+
+// flagEnabled is the template func which looks up the flag from the view data. The DOM adds the flags of the user,
+// evaluated once per page load or live connection, to the data of the templates it renders for the connection of the
+// event, see DOM.Self.
+func flagEnabled(data interface{}, name string) bool {
+	m, ok := data.(M)
+	if !ok {
+		return false
+	}
+	flags, ok := m["flags"].(map[string]bool)
+	if !ok {
+		return false
+	}
+	return flags[name]
+}
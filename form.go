@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFormMemory is the memory used to parse a multipart form posted on mount, the rest is stored on disk.
+const maxFormMemory = 10 << 20
+
+// FieldErrorsKey is the key of the FieldErrors in the template data read by the fieldError template func.
+const FieldErrorsKey = "field_errors"
+
+// FieldErrors maps the names of the invalid fields of a form to their error message. It's returned by DecodeForm
+// when some values can't be converted to the type of their field, and can be rendered with DOM.SetFieldErrors or
+// passed to the templates in the FieldErrorsKey data and read with the fieldError template func.
+type FieldErrors map[string]string
+
+func (f FieldErrors) Error() string {
+	fields := make([]string, 0, len(f))
+	for field := range f {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for i, field := range fields {
+		fields[i] = fmt.Sprintf("%s: %s", field, f[field])
+	}
+	return "invalid form: " + strings.Join(fields, ", ")
+}
+
+// Add sets the error message of field e.g. after a server side validation.
+func (f FieldErrors) Add(field, message string) {
+	f[field] = message
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// DecodeForm decodes the event params into the struct pointed to by v. Unlike DecodeParams, the values of a
+// urlencoded form, which are all strings, are converted to the type of their field: numbers, booleans (a checked
+// checkbox sends "on"), time.Time from the date, datetime-local and RFC 3339 formats, encoding.TextUnmarshaler
+// and slices of those. The field name is read from the form tag, then the json tag, then the field name; a "-"
+// skips the field. The values which can't be converted are returned as FieldErrors, the other fields are set.
+func (e Event) DecodeForm(v interface{}) error {
+	var params map[string]interface{}
+	if err := e.DecodeParams(&params); err != nil {
+		return err
+	}
+	return decodeForm(params, v)
+}
+
+// DecodeForm decodes the params of the event into v like Event.DecodeForm. On mount, the form of a POST request,
+// urlencoded or multipart, is decoded instead.
+func (s sessionContext) DecodeForm(v interface{}) error {
+	if s.connID != "" || s.r == nil || s.r.Method != http.MethodPost {
+		return s.event.DecodeForm(v)
+	}
+	if err := s.r.ParseMultipartForm(maxFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	params := make(map[string]interface{}, len(s.r.PostForm))
+	for k, vs := range s.r.PostForm {
+		params[strings.TrimSuffix(k, "[]")] = stringsToInterfaces(vs)
+	}
+	return decodeForm(params, v)
+}
+
+func stringsToInterfaces(vs []string) []interface{} {
+	values := make([]interface{}, len(vs))
+	for i, v := range vs {
+		values[i] = v
+	}
+	return values
+}
+
+func decodeForm(params map[string]interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode form: expected a pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	errs := make(FieldErrors)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := formFieldName(field)
+		if name == "" {
+			continue
+		}
+		value, ok := params[name]
+		if !ok || value == nil {
+			continue
+		}
+		if err := setFormValue(rv.Field(i), value); err != nil {
+			errs.Add(name, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func formFieldName(field reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		tag, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// setFormValue sets the field to the value decoded from JSON, converting the strings to the type of the field.
+func setFormValue(field reflect.Value, value interface{}) error {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setFormValue(field.Elem(), value)
+	}
+	if values, ok := value.([]interface{}); ok {
+		if field.Kind() != reflect.Slice || field.Type().Elem().Kind() == reflect.Uint8 {
+			if len(values) == 0 {
+				return nil
+			}
+			// e.g. a hidden input followed by a checkbox of the same name: the last value wins
+			return setFormValue(field, values[len(values)-1])
+		}
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := setFormValue(slice.Index(i), v); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		return setFormValue(field, []interface{}{value})
+	}
+	// before encoding.TextUnmarshaler which only accepts RFC 3339
+	if field.Type() == reflect.TypeOf(time.Time{}) {
+		t, err := parseFormTime(formString(value))
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(formString(value)))
+	}
+	s, isString := value.(string)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(formString(value))
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			field.SetBool(b)
+			return nil
+		}
+		b, err := parseFormBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !isString {
+			s = formString(value)
+		}
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !isString {
+			s = formString(value)
+		}
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a positive integer")
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if f, ok := value.(float64); ok {
+			field.SetFloat(f)
+			return nil
+		}
+		if s == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// formString formats a value decoded from JSON. The numbers are decoded as float64, whose large whole values
+// fmt.Sprint formats with an exponent e.g. 1e+06.
+func formString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func parseFormBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "yes", "1", "true":
+		return true, nil
+	case "", "off", "no", "0", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("must be a boolean")
+}
+
+// formTimeLayouts are the formats of the date and time inputs, then RFC 3339.
+var formTimeLayouts = []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02T15:04:05", time.RFC3339, "15:04"}
+
+func parseFormTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range formTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("must be a date")
+}
+
+// SetFieldErrors renders errs in the form matched by selector: the client clears the messages of the previous
+// call, sets the text of the [data-glv-error="field"] element of each field and marks the input named field with
+// aria-invalid. A nil errs clears the errors, e.g. after a successful submit.
+func (d *dom) SetFieldErrors(selector string, errs FieldErrors) {
+	if errs == nil {
+		errs = FieldErrors{}
+	}
+	m := &Operation{
+		Op:       SetFieldErrors,
+		Selector: d.scoped(selector),
+		Value:    errs,
+	}
+	d.send(m)
+}
+
+// fieldError is the template func returning the error message of field in the FieldErrorsKey data, e.g.
+// <span data-glv-error="email">{{fieldError . "email"}}</span>.
+func fieldError(data interface{}, field string) string {
+	m, ok := data.(M)
+	if !ok {
+		return ""
+	}
+	switch errs := m[FieldErrorsKey].(type) {
+	case FieldErrors:
+		return errs[field]
+	case map[string]string:
+		return errs[field]
+	case map[string]interface{}:
+		s, _ := errs[field].(string)
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// fragmentView renders only the content of the wrapped view. The layout is owned by the host page.
+type fragmentView struct {
+	View
+}
+
+func (f fragmentView) Layout() string {
+	return ""
+}
+
+// unwrapFragment returns the view wrapped by a fragment, to look up the optional interfaces it implements.
+func unwrapFragment(view View) View {
+	if f, ok := view.(fragmentView); ok {
+		return f.View
+	}
+	return view
+}
+
+func fragmentTopic(fragmentID, topic string) string {
+	return fmt.Sprintf("fragment_%s%s", fragmentID, topic)
+}
+
+func fragmentOpen(fragmentID string) string {
+	return fmt.Sprintf(`<div id="%s" data-glv-fragment="%s">`,
+		template.HTMLEscapeString(fragmentID), template.HTMLEscapeString(fragmentID))
+}
+
+func fragmentClose() string {
+	return "</div>"
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// EnableFragmentCache tags the html of the morph and setInnerHTML operations with a hash. The client keeps the
+// html of the last size hashes it received and the controller sends only the hash to a connection which already
+// has the html e.g. when the same content is broadcast again to a topic with late subscribers.
+// The client cache must hold at least size entries and evict the least recently used one.
+func EnableFragmentCache(size int) Option {
+	return func(o *controlOpt) {
+		o.fragmentCacheSize = size
+	}
+}
+
+func fragmentHash(html string) string {
+	h := fnv.New64a()
+	h.Write([]byte(html))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// fragmentCache mirrors the hashes held by the client cache of a connection. It's locked while an operation is
+// written to the connection so that the mirror follows the order of the writes.
+type fragmentCache struct {
+	size   int
+	order  *list.List
+	hashes map[string]*list.Element
+	sync.Mutex
+}
+
+// seen reports whether the client has the html of hash and marks hash as the most recently used.
+func (f *fragmentCache) seen(hash string) bool {
+	if e, ok := f.hashes[hash]; ok {
+		f.order.MoveToFront(e)
+		return true
+	}
+	f.hashes[hash] = f.order.PushFront(hash)
+	if f.order.Len() > f.size {
+		oldest := f.order.Back()
+		f.order.Remove(oldest)
+		delete(f.hashes, oldest.Value.(string))
+	}
+	return false
+}
+
+type fragmentCaches struct {
+	conns map[string]*fragmentCache
+	sync.Mutex
+}
+
+func (f *fragmentCaches) get(connID string, size int) *fragmentCache {
+	f.Lock()
+	defer f.Unlock()
+	if f.conns == nil {
+		f.conns = make(map[string]*fragmentCache)
+	}
+	c, ok := f.conns[connID]
+	if !ok {
+		c = &fragmentCache{size: size, order: list.New(), hashes: make(map[string]*list.Element)}
+		f.conns[connID] = c
+	}
+	return c
+}
+
+func (f *fragmentCaches) remove(connID string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.conns, connID)
+}
+
+// broadcast sends the operation to the connections of topic on all the instances.
+func (wc *websocketController) broadcast(topic string, m *Operation) {
+	wc.publish(topic, m.encode(wc.logger))
+}
+
+// deliverCached writes the operation received from the broker to the local connections of topic, replacing the
+// html with its hash for the connections which have it cached.
+func (wc *websocketController) deliverCached(topic string, m *Operation, full []byte, except string) {
+	reuse := *m
+	reuse.Value = nil
+	reuseBytes := reuse.encode(wc.logger)
+
+	conns, ok := wc.topicConns(topic, except)
+	if !ok {
+		wc.logger.Warn("topic doesn't exist", "topic", topic)
+		return
+	}
+	preparedFull, err := websocket.NewPreparedMessage(websocket.TextMessage, full)
+	if err != nil {
+		wc.logger.Error("preparing message", "topic", topic, "err", err)
+		return
+	}
+	preparedReuse, err := websocket.NewPreparedMessage(websocket.TextMessage, reuseBytes)
+	if err != nil {
+		wc.logger.Error("preparing message", "topic", topic, "err", err)
+		return
+	}
+	wc.fanOut.run(conns, func(c connRef) {
+		cache := wc.fragments.get(c.id, wc.fragmentCacheSize)
+		cache.Lock()
+		defer cache.Unlock()
+		prepared, message := preparedFull, full
+		if cache.seen(m.Hash) {
+			prepared, message = preparedReuse, reuseBytes
+		}
+		if err := writePrepared(c.conn, prepared, message); err != nil {
+			wc.logger.Error("writing message, closing conn", "topic", topic, "conn", c.id, "err", err)
+			wc.metrics.MessageDropped(topic, DropWriteFailed)
+			wc.closeConn(c)
+		}
+	})
+	wc.metrics.Broadcast(topic, len(conns))
+}
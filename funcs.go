@@ -26,9 +26,65 @@ func DefaultFuncMap() template.FuncMap {
 	allFuncs["bytesToMap"] = bytesToMap
 	allFuncs["bytesToString"] = bytesToString
 	allFuncs["dump"] = dump
+	allFuncs["variant"] = variant
+	allFuncs["flag"] = flagEnabled
+	allFuncs["localtime"] = localtime
+	allFuncs["reltime"] = reltime
+	allFuncs["render"] = renderUnbound
+	allFuncs["scoped"] = scoped
+	allFuncs["fieldError"] = fieldError
+	allFuncs["csrfToken"] = csrfTokenFunc
+	allFuncs["csrfField"] = csrfField
 	return allFuncs
 }
 
+// WithFuncs adds funcs to the FuncMap of every view e.g. organization wide currency or icon helpers.
+// The view's FuncMap wins on conflicts.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(o *controlOpt) {
+		if o.funcs == nil {
+			o.funcs = make(template.FuncMap)
+		}
+		for k, v := range funcs {
+			o.funcs[k] = v
+		}
+	}
+}
+
+// mergeFuncs returns the funcs overridden by the view's funcs.
+func mergeFuncs(funcs, viewFuncs template.FuncMap) template.FuncMap {
+	if len(funcs) == 0 {
+		return viewFuncs
+	}
+	merged := make(template.FuncMap, len(funcs)+len(viewFuncs))
+	for k, v := range funcs {
+		merged[k] = v
+	}
+	for k, v := range viewFuncs {
+		merged[k] = v
+	}
+	return merged
+}
+
+func renderUnbound(name string, data interface{}) (template.HTML, error) {
+	return "", fmt.Errorf("render %s: template is not compiled by the controller", name)
+}
+
+// bindRender binds the `render` func to t. render executes a partial with only the given data
+// instead of the whole dot: {{ render "card" (dict "title" .title) }}
+func bindRender(t *template.Template) {
+	t.Funcs(template.FuncMap{
+		"render": func(name string, data interface{}) (template.HTML, error) {
+			var buf bytes.Buffer
+			err := t.ExecuteTemplate(&buf, name, data)
+			if err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+	})
+}
+
 func bytesToMap(data []byte) map[string]interface{} {
 	m := make(map[string]interface{})
 	err := json.Unmarshal(data, &m)
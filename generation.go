@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+)
+
+// GenerationKey is the key of the generation token in the mount data. The client sends it back in the
+// generation query parameter of the websocket url when it reconnects.
+const GenerationKey = "glv_generation"
+
+// DefaultReconnectJitter is the default upper bound of the delay before the clients of a previous
+// generation re-mount.
+var DefaultReconnectJitter = 5 * time.Second
+
+// WithGeneration sets the generation token of the server, e.g. a build id, instead of a random one per process.
+// Clients whose page was mounted by another generation re-mount when they reconnect.
+func WithGeneration(token string) Option {
+	return func(o *controlOpt) {
+		o.generation = token
+	}
+}
+
+// WithReconnectJitter sets the upper bound of the random delay before the clients of a previous generation
+// re-mount, so that they don't all hit the server at once after a restart. It's zero in DevelopmentMode.
+func WithReconnectJitter(max time.Duration) Option {
+	return func(o *controlOpt) {
+		o.reconnectJitter = max
+	}
+}
+
+// checkGeneration asks the client on conn to re-mount if its page was mounted by another generation of the server.
+func (wc *websocketController) checkGeneration(r *http.Request, conn Conn) {
+	generation := r.URL.Query().Get("generation")
+	if generation == "" || generation == wc.generation {
+		return
+	}
+	var delay time.Duration
+	if !wc.developmentMode && wc.reconnectJitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(wc.reconnectJitter)))
+	}
+	m := &Operation{
+		Op: Generation,
+		Value: M{
+			"generation":    wc.generation,
+			"reloadAfterMs": delay.Milliseconds(),
+		},
+	}
+	wc.messageConn(conn, m.encode(wc.logger))
+}
+
+func newGeneration(newID func() string) string {
+	if newID != nil {
+		return newID()
+	}
+	return shortuuid.New()
+}
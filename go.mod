@@ -12,6 +12,8 @@ require (
 	github.com/gorilla/websocket v1.5.0
 	github.com/lithammer/shortuuid v3.0.0+incompatible
 	github.com/yosssi/gohtml v0.0.0-20201013000340-ee4748c638f4
+	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
+	golang.org/x/time v0.0.0-20220722155302-e5dcc9cfc0b9
 )
 
 require (
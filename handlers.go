@@ -0,0 +1,41 @@
+package controller
+
+// EventHandlers is implemented by the views which register a handler per event id instead of switching on the
+// event id in OnLiveEvent:
+//
+//	func (t *TodosView) EventHandlers() map[string]controller.EventHandler {
+//		return map[string]controller.EventHandler{
+//			"todos/new":    t.Add,
+//			"todos/delete": t.Delete,
+//		}
+//	}
+//
+// The events without a handler are passed to the handler set with WithUnknownEventHandler, or to OnLiveEvent.
+type EventHandlers interface {
+	EventHandlers() map[string]EventHandler
+}
+
+// WithUnknownEventHandler handles the events of the views implementing EventHandlers which have no handler
+// registered for the event id e.g. to log and report them. Defaults to the view's OnLiveEvent.
+func WithUnknownEventHandler(h EventHandler) Option {
+	return func(o *controlOpt) {
+		o.unknownEventHandler = h
+	}
+}
+
+// dispatch calls the handler registered for the event of ctx.
+func (v *viewHandler) dispatch(ctx Context) error {
+	view := v.view
+	if f, ok := view.(fragmentView); ok {
+		view = f.View
+	}
+	if hv, ok := view.(EventHandlers); ok {
+		if h, ok := hv.EventHandlers()[ctx.Event().ID]; ok && h != nil {
+			return h(ctx)
+		}
+		if v.wc.unknownEventHandler != nil {
+			return v.wc.unknownEventHandler(ctx)
+		}
+	}
+	return v.view.OnLiveEvent(ctx)
+}
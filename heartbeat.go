@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithHeartbeat pings the websocket connections every interval and closes the ones which haven't answered, or
+// sent anything, within interval plus timeout, so that the half-open connections of the clients which went away
+// without closing are removed from their topics. The server-sent events connections receive a keep-alive comment
+// every interval.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(o *controlOpt) {
+		o.pingInterval = interval
+		o.pongTimeout = timeout
+	}
+}
+
+// startHeartbeat pings c until the returned stop func is called. The read loop of c fails once the read deadline
+// is missed. A failed ping closes c and calls cancel, aborting the event being handled.
+func (wc *websocketController) startHeartbeat(c *websocket.Conn, cancel func()) (stop func()) {
+	if wc.pingInterval <= 0 {
+		return func() {}
+	}
+	deadline := func() time.Time {
+		return time.Now().Add(wc.pingInterval + wc.pongTimeout)
+	}
+	c.SetReadDeadline(deadline())
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(deadline())
+	})
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(wc.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(wc.pongTimeout))
+				if err != nil {
+					wc.logger.Warn("ping failed, closing conn", "addr", c.RemoteAddr(), "err", err)
+					c.Close()
+					cancel()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// extendReadDeadline moves the read deadline of c after a message was read from it.
+func (wc *websocketController) extendReadDeadline(c *websocket.Conn) {
+	if wc.pingInterval > 0 {
+		c.SetReadDeadline(time.Now().Add(wc.pingInterval + wc.pongTimeout))
+	}
+}
+
+// keepAlive writes a comment to the stream every ping interval until done. A failed write closes the stream.
+func (wc *websocketController) keepAlive(conn *sseConn, done <-chan struct{}) {
+	if wc.pingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(wc.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.comment("ping"); err != nil {
+				conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
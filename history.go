@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// EnableReplayBuffer keeps the last size operations broadcast to each topic by this instance so that they can be
+// read with Controller.History e.g. to show the recent activity. The sensitive operations aren't kept and the
+// operations of a topic are dropped once its last connection to this instance is gone.
+func EnableReplayBuffer(size int) Option {
+	return func(o *controlOpt) {
+		o.replayBufferSize = size
+	}
+}
+
+// replayBuffer is a ring of the last messages of a topic.
+type replayBuffer struct {
+	messages [][]byte
+	next     int
+	full     bool
+}
+
+func (b *replayBuffer) add(message []byte) {
+	b.messages[b.next] = message
+	b.next = (b.next + 1) % len(b.messages)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// last returns the messages from the oldest to the newest.
+func (b *replayBuffer) last() [][]byte {
+	if !b.full {
+		return append([][]byte(nil), b.messages[:b.next]...)
+	}
+	return append(append([][]byte(nil), b.messages[b.next:]...), b.messages[:b.next]...)
+}
+
+type replayBuffers struct {
+	topics map[string]*replayBuffer
+	sync.Mutex
+}
+
+func (r *replayBuffers) add(topic string, size int, message []byte) {
+	r.Lock()
+	defer r.Unlock()
+	if r.topics == nil {
+		r.topics = make(map[string]*replayBuffer)
+	}
+	b, ok := r.topics[topic]
+	if !ok {
+		b = &replayBuffer{messages: make([][]byte, size)}
+		r.topics[topic] = b
+	}
+	b.add(message)
+}
+
+func (r *replayBuffers) remove(topic string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.topics, topic)
+}
+
+func (r *replayBuffers) get(topic string) [][]byte {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.topics[topic]
+	if !ok {
+		return nil
+	}
+	return b.last()
+}
+
+// record keeps a message broadcast to topic in the replay buffer.
+func (wc *websocketController) record(topic string, message []byte) {
+	if wc.replayBufferSize <= 0 || topic == allTopics {
+		return
+	}
+	_, message = splitExcept(message)
+	if sensitive(message) {
+		return
+	}
+	wc.replay.add(topic, wc.replayBufferSize, message)
+}
+
+// History returns the last n operations broadcast to topic, from the oldest to the newest, when EnableReplayBuffer
+// is set. A batch counts as one entry of the buffer.
+func (wc *websocketController) History(topic string, n int) []Operation {
+	var ops []Operation
+	for _, message := range wc.replay.get(topic) {
+		if len(message) > 0 && message[0] == '[' {
+			var batch []Operation
+			if err := json.Unmarshal(message, &batch); err != nil {
+				wc.logger.Error("decoding history", "topic", topic, "err", err)
+				continue
+			}
+			ops = append(ops, batch...)
+			continue
+		}
+		var m Operation
+		if err := json.Unmarshal(message, &m); err != nil {
+			wc.logger.Error("decoding history", "topic", topic, "err", err)
+			continue
+		}
+		ops = append(ops, m)
+	}
+	if n >= 0 && len(ops) > n {
+		ops = ops[len(ops)-n:]
+	}
+	return ops
+}
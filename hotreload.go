@@ -0,0 +1,83 @@
+package controller
+
+import ()
+
+// EnableHotReload re-renders the views whose templates changed instead of reloading the pages, so that the
+// clients keep their state e.g. the scroll position and the form inputs. The views of the live connections call
+// OnMount again and their content is morphed into the OutletID element of the layout, like with Route. The pages
+// are still reloaded when a layout file changes, when the changed file isn't a template of a view or when the view
+// is served as a fragment. It applies
+// to the templates watched with EnableWatch or in development mode.
+func EnableHotReload() Option {
+	return func(o *controlOpt) {
+		o.hotReload = true
+	}
+}
+
+// rerenderViews re-renders the live connections of the views invalidated by the change of path. It returns false
+// if the pages must be reloaded instead.
+func (wc *websocketController) rerenderViews(path string, invalidated []*compiledView) bool {
+	errorViewKey := viewKey(wc.errorView)
+	affected := make(map[*compiledView]bool)
+	for _, cv := range invalidated {
+		if viewKey(cv.view) == errorViewKey {
+			continue
+		}
+		if cv.layoutFile(path) {
+			return false
+		}
+		affected[cv] = true
+	}
+	if len(affected) == 0 {
+		return false
+	}
+	wc.liveConns.Lock()
+	conns := make([]*liveConn, 0, len(wc.liveConns.conns))
+	for _, c := range wc.liveConns.conns {
+		if c.handler == nil || !affected[c.handler.compiledView] {
+			continue
+		}
+		// the content of a fragment has no outlet
+		if c.handler.fragmentID != "" {
+			wc.liveConns.Unlock()
+			return false
+		}
+		conns = append(conns, c)
+	}
+	wc.liveConns.Unlock()
+	for _, c := range conns {
+		v := c.handler
+		wc.post(c.session, func(sessCtx *sessionContext) {
+			if err := v.rerender(sessCtx.fork()); err != nil {
+				wc.logger.Error("hot reloading", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "err", err)
+			}
+		})
+	}
+	wc.logger.Info("hot reloaded", "path", path, "conns", len(conns))
+	return true
+}
+
+// rerender mounts the view again and morphs its content on the connection of sessCtx. It's posted to the
+// connection so that it doesn't run concurrently with its events.
+func (v *viewHandler) rerender(sessCtx *sessionContext) error {
+	t, err := v.compiledView.template(false)
+	if err != nil {
+		return err
+	}
+	self := sessCtx.dom.targeted(toSelf)
+	self.rootTemplate = t
+	ctx := *sessCtx
+	ctx.dom = self
+	ctx.event = Event{ID: "onMount"}
+	status, data := v.view.OnMount(ctx)
+	if status.Redirect != "" {
+		self.Redirect(status.Redirect)
+		return nil
+	}
+	if data == nil {
+		data = make(M)
+	}
+	data["url_path"] = sessCtx.pageURL().Path
+	self.Morph("#"+OutletID, v.view.LayoutContentName(), data)
+	return nil
+}
@@ -0,0 +1,19 @@
+package controller
+
+import "github.com/lithammer/shortuuid"
+
+// WithIDGenerator sets the generator of the connection and session ids, and of the generation token if it isn't
+// set with WithGeneration, e.g. ULIDs matching the ids of the application logs or a deterministic sequence in
+// tests. The ids must be unique. Defaults to shortuuid.
+func WithIDGenerator(newID func() string) Option {
+	return func(o *controlOpt) {
+		o.idGenerator = newID
+	}
+}
+
+func (wc *websocketController) newID() string {
+	if wc.idGenerator != nil {
+		return wc.idGenerator()
+	}
+	return shortuuid.New()
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInboxFull = errors.New("inbox is full")
+
+// OverflowPolicy decides what happens when an event is sent to a full Inbox.
+type OverflowPolicy int
+
+const (
+	// BlockOnOverflow blocks the producer until there is room in the inbox.
+	BlockOnOverflow OverflowPolicy = iota
+	// DropNewest drops the event being sent and returns ErrInboxFull.
+	DropNewest
+	// DropOldest drops the oldest queued event to make room for the event being sent.
+	DropOldest
+)
+
+// InboxBackend keeps the events queued in an Inbox. A persistent backend e.g. redis.NewInbox keeps the events
+// across restarts.
+type InboxBackend interface {
+	Push(e Event) error
+	// Pop removes and returns the oldest event. It returns false if the queue is empty.
+	Pop() (Event, bool, error)
+	Len() (int, error)
+}
+
+// InboxConfig configures an Inbox.
+type InboxConfig struct {
+	// Capacity is the maximum number of queued events. Defaults to 1024.
+	Capacity int
+	Overflow OverflowPolicy
+	// Backend defaults to an in-memory queue.
+	Backend InboxBackend
+	// Logger receives the errors of the backend. Defaults to the standard log package.
+	Logger Logger
+}
+
+// InboxStats are the counters of an Inbox.
+type InboxStats struct {
+	Enqueued  uint64
+	Delivered uint64
+	Dropped   uint64
+	Depth     int
+}
+
+// Inbox is a buffered queue of events for a view's LiveEventReceiver so that producers aren't blocked by, and
+// don't drop events because of, slow event handlers. Return Inbox.Receive from LiveEventReceiver and send the
+// events with Inbox.Send.
+type Inbox struct {
+	capacity int
+	overflow OverflowPolicy
+	backend  InboxBackend
+	logger   Logger
+	out      chan Event
+	notify   chan struct{}
+	done     chan struct{}
+	depth    int
+	room     *sync.Cond
+	mu       sync.Mutex
+
+	enqueued  uint64
+	delivered uint64
+	dropped   uint64
+}
+
+// NewInbox returns an Inbox and starts delivering the events already queued in the backend.
+func NewInbox(cfg InboxConfig) *Inbox {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 1024
+	}
+	if cfg.Backend == nil {
+		cfg.Backend = &inmemInbox{}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = stdLogger{}
+	}
+	i := &Inbox{
+		capacity: cfg.Capacity,
+		overflow: cfg.Overflow,
+		backend:  cfg.Backend,
+		logger:   cfg.Logger,
+		out:      make(chan Event),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	i.room = sync.NewCond(&i.mu)
+	depth, err := i.backend.Len()
+	if err != nil {
+		cfg.Logger.Error("inbox length", "err", err)
+	}
+	i.depth = depth
+	go i.pump()
+	return i
+}
+
+// Send queues the event, applying the overflow policy if the inbox is full.
+func (i *Inbox) Send(e Event) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for i.depth >= i.capacity {
+		switch i.overflow {
+		case DropNewest:
+			atomic.AddUint64(&i.dropped, 1)
+			return fmt.Errorf("event %s: %w", e.ID, ErrInboxFull)
+		case DropOldest:
+			_, ok, err := i.backend.Pop()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// the only queued event is being delivered
+				return i.push(e)
+			}
+			atomic.AddUint64(&i.dropped, 1)
+			i.depth--
+		default:
+			select {
+			case <-i.done:
+				return fmt.Errorf("event %s: inbox closed", e.ID)
+			default:
+			}
+			i.room.Wait()
+		}
+	}
+	return i.push(e)
+}
+
+// push queues the event. It's called with the inbox locked.
+func (i *Inbox) push(e Event) error {
+	if err := i.backend.Push(e); err != nil {
+		return err
+	}
+	i.depth++
+	atomic.AddUint64(&i.enqueued, 1)
+	select {
+	case i.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Receive returns the channel the queued events are delivered to. Return it from LiveEventReceiver.
+func (i *Inbox) Receive() <-chan Event {
+	return i.out
+}
+
+// Stats returns the counters of the inbox.
+func (i *Inbox) Stats() InboxStats {
+	i.mu.Lock()
+	depth := i.depth
+	i.mu.Unlock()
+	return InboxStats{
+		Enqueued:  atomic.LoadUint64(&i.enqueued),
+		Delivered: atomic.LoadUint64(&i.delivered),
+		Dropped:   atomic.LoadUint64(&i.dropped),
+		Depth:     depth,
+	}
+}
+
+// Close stops the delivery. The events left in a persistent backend are delivered by the next Inbox using it.
+func (i *Inbox) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	select {
+	case <-i.done:
+	default:
+		close(i.done)
+		i.room.Broadcast()
+	}
+}
+
+func (i *Inbox) pump() {
+	for {
+		i.mu.Lock()
+		e, ok, err := i.backend.Pop()
+		i.mu.Unlock()
+		if err != nil {
+			i.logger.Error("inbox pop", "err", err)
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-i.done:
+				return
+			}
+		}
+		if !ok {
+			select {
+			case <-i.notify:
+				continue
+			case <-i.done:
+				return
+			}
+		}
+		select {
+		case i.out <- e:
+			atomic.AddUint64(&i.delivered, 1)
+			i.mu.Lock()
+			i.depth--
+			i.room.Broadcast()
+			i.mu.Unlock()
+		case <-i.done:
+			// put the event back for the next inbox, it loses its place in the queue
+			i.mu.Lock()
+			if err := i.backend.Push(e); err != nil {
+				i.logger.Error("inbox requeue", "event", e.ID, "err", err)
+			}
+			i.mu.Unlock()
+			return
+		}
+	}
+}
+
+type inmemInbox struct {
+	events []Event
+}
+
+func (q *inmemInbox) Push(e Event) error {
+	q.events = append(q.events, e)
+	return nil
+}
+
+func (q *inmemInbox) Pop() (Event, bool, error) {
+	if len(q.events) == 0 {
+		return Event{}, false, nil
+	}
+	e := q.events[0]
+	q.events[0] = Event{}
+	q.events = q.events[1:]
+	return e, true, nil
+}
+
+func (q *inmemInbox) Len() (int, error) {
+	return len(q.events), nil
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type sessionSummary struct {
+	SessionStats
+	Topics      []string `json:"topics"`
+	Connections int      `json:"connections"`
+}
+
+type sessionDump struct {
+	sessionSummary
+	Store map[string]interface{} `json:"store"`
+}
+
+func (s *inmemStore) dump() map[string]json.RawMessage {
+	s.RLock()
+	defer s.RUnlock()
+	out := make(map[string]json.RawMessage, len(s.data))
+	for k, v := range s.data {
+		out[k] = v
+	}
+	return out
+}
+
+// Inspector returns a handler which lists the sessions as JSON and, given the user query parameter, dumps the
+// user's store and subscribed topics. The values are redacted using the redaction policy. It's only served in
+// DevelopmentMode.
+func (wc *websocketController) Inspector() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wc.developmentMode {
+			http.NotFound(w, r)
+			return
+		}
+		summaries := wc.sessionSummaries()
+		var body interface{} = summaries
+		if u := r.URL.Query().Get("user"); u != "" {
+			user, err := strconv.Atoi(u)
+			if err != nil {
+				http.Error(w, "invalid user", http.StatusBadRequest)
+				return
+			}
+			dump, ok := wc.dumpSession(user, summaries)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			body = dump
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		if err := enc.Encode(body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (wc *websocketController) sessionSummaries() []sessionSummary {
+	summaries := make(map[int]*sessionSummary)
+	wc.userSessions.RLock()
+	for user, store := range wc.userSessions.stores {
+		s := &sessionSummary{SessionStats: SessionStats{User: user}}
+		if sizer, ok := store.(Sizer); ok {
+			s.Bytes, s.Keys = sizer.Size()
+		}
+		summaries[user] = s
+	}
+	wc.userSessions.RUnlock()
+
+	wc.liveConns.Lock()
+	for _, c := range wc.liveConns.conns {
+		s, ok := summaries[c.info.User]
+		if !ok {
+			// stateless mode
+			s = &sessionSummary{SessionStats: SessionStats{User: c.info.User}}
+			summaries[c.info.User] = s
+		}
+		s.Connections++
+		if c.info.Topic != "" && !contains(s.Topics, c.info.Topic) {
+			s.Topics = append(s.Topics, c.info.Topic)
+		}
+	}
+	wc.liveConns.Unlock()
+
+	out := make([]sessionSummary, 0, len(summaries))
+	for _, s := range summaries {
+		sort.Strings(s.Topics)
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].User < out[j].User
+	})
+	return out
+}
+
+func (wc *websocketController) dumpSession(user int, summaries []sessionSummary) (*sessionDump, bool) {
+	var dump *sessionDump
+	for _, s := range summaries {
+		if s.User == user {
+			dump = &sessionDump{sessionSummary: s, Store: make(map[string]interface{})}
+		}
+	}
+	if dump == nil {
+		return nil, false
+	}
+	wc.userSessions.RLock()
+	store, ok := wc.userSessions.stores[user]
+	wc.userSessions.RUnlock()
+	if !ok {
+		// stateless mode, the store of a connection is the state cookie
+		wc.liveConns.Lock()
+		for _, c := range wc.liveConns.conns {
+			if c.info.User == user {
+				store = c.info.Store
+			}
+		}
+		wc.liveConns.Unlock()
+	}
+	if s, ok := store.(interface {
+		dump() map[string]json.RawMessage
+	}); ok {
+		for k, v := range s.dump() {
+			dump.Store[k] = v
+		}
+	}
+	dump.Store = wc.redaction.Redact(dump.Store).(map[string]interface{})
+	return dump, true
+}
@@ -0,0 +1,32 @@
+package controller
+
+import "time"
+
+// StartInterval makes the clients send event every interval e.g. to poll the progress of a job or refresh a
+// dashboard. Starting an interval with the same id replaces it. Intervals are stopped when the page is closed.
+func (d *dom) StartInterval(id string, every time.Duration, event Event) {
+	if every <= 0 {
+		d.StopInterval(id)
+		return
+	}
+	m := &Operation{
+		Op: StartInterval,
+		Value: M{
+			"id":      id,
+			"everyMs": every.Milliseconds(),
+			"event":   event,
+		},
+	}
+	d.send(m)
+}
+
+// StopInterval stops the interval started by StartInterval.
+func (d *dom) StopInterval(id string) {
+	m := &Operation{
+		Op: StopInterval,
+		Value: M{
+			"id": id,
+		},
+	}
+	d.send(m)
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"errors"
+)
+
+// ErrConnectFailed is the error of a remote connection rejected by the OnConnect hook of the view, see
+// RemoteView.Connect.
+var ErrConnectFailed = errors.New("connect hook failed")
+
+// Connector is implemented by views which are notified when a live connection joins, e.g. to add the user to a
+// presence list. OnConnect is called once the connection is subscribed to its topic, before its first event; an
+// error closes the connection.
+type Connector interface {
+	OnConnect(ctx Context) error
+}
+
+// Reconnector is implemented by views which resume the session of a client reconnecting with its state token, see
+// EnableSessionResume. OnReconnect is called after OnConnect, with the Store restored to its state when the client
+// went away, so that the view re-renders only what changed in the meantime. The operations of ctx.DOM() are only
+// sent to the reconnected connection. An error closes the connection.
+type Reconnector interface {
+	OnReconnect(ctx Context) error
+}
+
+// Disconnector is implemented by views which are notified when a live connection leaves, e.g. to release the
+// resources of the connection. OnDisconnect is called once the connection is unsubscribed from its topic, so the
+// operations of ctx.DOM() reach the remaining connections.
+type Disconnector interface {
+	OnDisconnect(ctx Context)
+}
+
+// connect calls the OnConnect hook of the view. It returns false if the connection must be closed.
+func (v *viewHandler) connect(sessCtx *sessionContext) bool {
+	c, ok := unwrapFragment(v.view).(Connector)
+	if !ok {
+		return true
+	}
+	sessCtx.serial.Lock()
+	defer sessCtx.serial.Unlock()
+	sessCtx.event = Event{ID: "onConnect"}
+	if err := c.OnConnect(*sessCtx); err != nil {
+		v.wc.logger.Warn("OnConnect failed, closing conn", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+		return false
+	}
+	return true
+}
+
+// reconnect calls the OnReconnect hook of the view if the session is resumed. It returns false if the connection
+// must be closed.
+func (v *viewHandler) reconnect(sessCtx *sessionContext, resumed bool) bool {
+	rc, ok := unwrapFragment(v.view).(Reconnector)
+	if !resumed || !ok {
+		return true
+	}
+	sessCtx.serial.Lock()
+	defer sessCtx.serial.Unlock()
+	ctx := *sessCtx
+	ctx.dom = sessCtx.dom.targeted(toSelf)
+	ctx.event = Event{ID: "onReconnect"}
+	if err := rc.OnReconnect(ctx); err != nil {
+		v.wc.logger.Warn("OnReconnect failed, closing conn", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+		return false
+	}
+	return true
+}
+
+// disconnect calls the OnDisconnect hook of the view.
+func (v *viewHandler) disconnect(sessCtx *sessionContext) {
+	d, ok := unwrapFragment(v.view).(Disconnector)
+	if !ok {
+		return
+	}
+	sessCtx.serial.Lock()
+	defer sessCtx.serial.Unlock()
+	sessCtx.event = Event{ID: "onDisconnect"}
+	d.OnDisconnect(*sessCtx)
+}
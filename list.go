@@ -0,0 +1,67 @@
+package controller
+
+// Append renders the template with data and appends it to the children of the element matched by selector e.g. a
+// new row of a list, instead of morphing the whole list.
+func (d *dom) Append(selector, template string, data M) {
+	d.insert(Append, selector, template, data)
+}
+
+// Prepend renders the template with data and inserts it before the first child of the element matched by selector.
+func (d *dom) Prepend(selector, template string, data M) {
+	d.insert(Prepend, selector, template, data)
+}
+
+// InsertBefore renders the template with data and inserts it before the element matched by selector.
+func (d *dom) InsertBefore(selector, template string, data M) {
+	d.insert(InsertBefore, selector, template, data)
+}
+
+// InsertAfter renders the template with data and inserts it after the element matched by selector.
+func (d *dom) InsertAfter(selector, template string, data M) {
+	d.insert(InsertAfter, selector, template, data)
+}
+
+// Remove removes the elements matched by selector.
+func (d *dom) Remove(selector string) {
+	m := &Operation{
+		Op:       RemoveElement,
+		Selector: d.scoped(selector),
+	}
+	d.send(m)
+}
+
+// insert sends the rendered template with op. Unlike Morph, the data isn't saved in the store: it's the data of
+// an item, not of the view.
+func (d *dom) insert(op Op, selector, template string, data M) {
+	m, ok := d.morphOperation(selector, template, data, nil)
+	if !ok {
+		return
+	}
+	m.Op = op
+	d.send(m)
+}
+
+// NewAppend returns an operation appending html to the children of the element matched by selector.
+func NewAppend(selector, html string) (*Operation, error) {
+	return newOperation(Append, selector, html, nil)
+}
+
+// NewPrepend returns an operation inserting html before the first child of the element matched by selector.
+func NewPrepend(selector, html string) (*Operation, error) {
+	return newOperation(Prepend, selector, html, nil)
+}
+
+// NewInsertBefore returns an operation inserting html before the element matched by selector.
+func NewInsertBefore(selector, html string) (*Operation, error) {
+	return newOperation(InsertBefore, selector, html, nil)
+}
+
+// NewInsertAfter returns an operation inserting html after the element matched by selector.
+func NewInsertAfter(selector, html string) (*Operation, error) {
+	return newOperation(InsertAfter, selector, html, nil)
+}
+
+// NewRemove returns an operation removing the elements matched by selector.
+func NewRemove(selector string) (*Operation, error) {
+	return newOperation(RemoveElement, selector, nil, nil)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when the client doesn't send an Accept-Language header.
+var DefaultLocale = "en"
+
+// GeoLookup returns the ISO 3166-1 alpha-2 country code of the client e.g. using a GeoIP database or a CDN header.
+type GeoLookup func(r *http.Request) string
+
+// WithGeoLookup configures the lookup used by Context.Country.
+func WithGeoLookup(lookup GeoLookup) Option {
+	return func(o *controlOpt) {
+		o.geoLookup = lookup
+	}
+}
+
+type languageTag struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage returns the language tag with the highest quality value e.g. "fr-CH, fr;q=0.9, en;q=0.8" => "fr-CH"
+func parseAcceptLanguage(header string) string {
+	var tags []languageTag
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t := languageTag{tag: part, q: 1}
+		if i := strings.Index(part, ";"); i >= 0 {
+			t.tag = strings.TrimSpace(part[:i])
+			params := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(params, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+				if err != nil {
+					continue
+				}
+				t.q = q
+			}
+		}
+		if t.tag == "*" || t.q <= 0 {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	return tags[0].tag
+}
+
+func (wc *websocketController) localeHints(r *http.Request) (string, string) {
+	locale := parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	var country string
+	if wc.geoLookup != nil {
+		country = strings.ToUpper(wc.geoLookup(r))
+	}
+	return locale, country
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrLocked = errors.New("lock is held by another connection")
+
+// Locker hands out named leases to connections. A distributed implementation backed by
+// a shared store can be configured using WithLocker.
+type Locker interface {
+	// Acquire acquires or renews the lease name for owner for ttl. It returns false if another owner holds it.
+	Acquire(name, owner string, ttl time.Duration) (bool, error)
+	// Release releases the lease name if it's held by owner.
+	Release(name, owner string) error
+	// ReleaseAll releases all the leases held by owner.
+	ReleaseAll(owner string) error
+	// Released returns a channel which is closed when the lease name is released.
+	Released(name string) <-chan struct{}
+}
+
+type lease struct {
+	owner    string
+	expires  time.Time
+	released chan struct{}
+}
+
+type inmemLocker struct {
+	leases map[string]*lease
+	sync.Mutex
+}
+
+func newInmemLocker() *inmemLocker {
+	return &inmemLocker{leases: make(map[string]*lease)}
+}
+
+func (l *inmemLocker) Acquire(name, owner string, ttl time.Duration) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.leases[name]
+	if ok && ls.owner != owner && time.Now().Before(ls.expires) {
+		return false, nil
+	}
+	if ok && ls.owner != owner {
+		// expired
+		l.release(name)
+		ok = false
+	}
+	if !ok {
+		ls = &lease{owner: owner, released: make(chan struct{})}
+		l.leases[name] = ls
+	}
+	ls.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *inmemLocker) Release(name, owner string) error {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.leases[name]
+	if !ok || ls.owner != owner {
+		return nil
+	}
+	l.release(name)
+	return nil
+}
+
+func (l *inmemLocker) ReleaseAll(owner string) error {
+	l.Lock()
+	defer l.Unlock()
+	for name, ls := range l.leases {
+		if ls.owner == owner {
+			l.release(name)
+		}
+	}
+	return nil
+}
+
+func (l *inmemLocker) Released(name string) <-chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.leases[name]
+	if !ok {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return ls.released
+}
+
+func (l *inmemLocker) release(name string) {
+	close(l.leases[name].released)
+	delete(l.leases, name)
+}
+
+// waitLock blocks until owner acquires the lease or timeout elapses.
+func waitLock(locker Locker, name, owner string, ttl, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		ok, err := locker.Acquire(name, owner, ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-locker.Released(name):
+		case <-time.After(100 * time.Millisecond):
+			// leases can also expire
+		case <-deadline.C:
+			return ErrLocked
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the logs of the controller. The message is followed by key-value pairs, e.g.
+// Warn("slow event", "event", "todos/add", "duration", d). The keys are among topic, conn, user, event, view,
+// template, duration and err. A *slog.Logger implements Logger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// WithLogger sends the logs of the controller to logger instead of the standard log package. The debug logs are
+// only emitted with EnableDebugLog or in development mode.
+func WithLogger(logger Logger) Option {
+	return func(o *controlOpt) {
+		o.logger = logger
+	}
+}
+
+// contextLogger returns the Logger of the controller of ctx.
+func contextLogger(ctx Context) Logger {
+	if s, ok := ctx.(sessionContext); ok && s.dom != nil && s.dom.wc != nil {
+		return s.dom.wc.logger
+	}
+	return stdLogger{}
+}
+
+// stdLogger is the default Logger, writing the logs to the standard log package e.g.
+// warn: slow event event=todos/add duration=1.2s.
+type stdLogger struct{}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log("debug", msg, keyvals)
+}
+
+func (l stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log("info", msg, keyvals)
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log("warn", msg, keyvals)
+}
+
+func (l stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log("err", msg, keyvals)
+}
+
+func (stdLogger) log(level, msg string, keyvals []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		s := fmt.Sprint(value)
+		if strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], s)
+	}
+	log.Print(b.String())
+}
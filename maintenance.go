@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"net/http"
+	"sync"
+)
+
+type maintenance struct {
+	on      bool
+	message string
+	sync.RWMutex
+}
+
+func (m *maintenance) get() (bool, string) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.on, m.message
+}
+
+// DrainOnMaintenance closes the live connections when maintenance mode is switched on
+// and rejects new ones while it lasts.
+func DrainOnMaintenance() Option {
+	return func(o *controlOpt) {
+		o.drainOnMaintenance = true
+	}
+}
+
+// SetMaintenance switches maintenance mode. While on, new mounts are rejected with a 503 maintenance page rendered by
+// the error view and the connected clients receive a banner operation with the message.
+func (wc *websocketController) SetMaintenance(on bool, message string) {
+	wc.maintenance.Lock()
+	wc.maintenance.on = on
+	wc.maintenance.message = message
+	wc.maintenance.Unlock()
+
+	m := &Operation{
+		Op: Maintenance,
+		Value: M{
+			"enabled": on,
+			"message": message,
+		},
+	}
+	wc.messageAll(m.encode(wc.logger))
+	if on && wc.drainOnMaintenance {
+		wc.closeAll()
+	}
+}
+
+func (wc *websocketController) maintenanceStatus() (Status, bool) {
+	on, message := wc.maintenance.get()
+	if !on {
+		return Status{}, false
+	}
+	if message == "" {
+		message = http.StatusText(http.StatusServiceUnavailable)
+	}
+	return Status{Code: http.StatusServiceUnavailable, Message: message}, true
+}
+
+// closeAll closes all the live connections. The read loops clean up the connections.
+func (wc *websocketController) closeAll() {
+	wc.Lock()
+	defer wc.Unlock()
+	for _, cm := range wc.topicConnections {
+		for _, conn := range cm {
+			conn.Close()
+		}
+	}
+}
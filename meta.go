@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MetaTags are the title, description and OpenGraph tags of a page. They are set using Context.SetMeta.
+type MetaTags struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Canonical   string `json:"canonical,omitempty"`
+	Image       string `json:"image,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Type        string `json:"type,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+	TwitterCard string `json:"twitterCard,omitempty"`
+	// Extra are additional meta tags keyed by name, or by property for the og: prefixed ones.
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// HTML renders the tags. Each tag is marked with the data-glv-meta attribute so that the client can replace them.
+func (m MetaTags) HTML() template.HTML {
+	var b strings.Builder
+	if m.Title != "" {
+		fmt.Fprintf(&b, `<title data-glv-meta>%s</title>`, template.HTMLEscapeString(m.Title))
+	}
+	if m.Canonical != "" {
+		fmt.Fprintf(&b, `<link data-glv-meta rel="canonical" href="%s">`, template.HTMLEscapeString(m.Canonical))
+	}
+	meta := func(key, content string) {
+		if content == "" {
+			return
+		}
+		attr := "name"
+		if strings.HasPrefix(key, "og:") {
+			attr = "property"
+		}
+		fmt.Fprintf(&b, `<meta data-glv-meta %s="%s" content="%s">`, attr,
+			template.HTMLEscapeString(key), template.HTMLEscapeString(content))
+	}
+	meta("description", m.Description)
+	meta("og:title", m.Title)
+	meta("og:description", m.Description)
+	meta("og:image", m.Image)
+	meta("og:url", m.URL)
+	meta("og:type", m.Type)
+	meta("og:site_name", m.SiteName)
+	meta("twitter:card", m.TwitterCard)
+	keys := make([]string, 0, len(m.Extra))
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		meta(k, m.Extra[k])
+	}
+	return template.HTML(b.String())
+}
+
+// pageMeta holds the tags set during OnMount.
+type pageMeta struct {
+	tags *MetaTags
+}
+
+var titleRe = regexp.MustCompile(`(?is)<title\b[^>]*>.*?</title\s*>`)
+
+// injectMeta adds the tags to the head of the page unless the layout renders them using {{.meta}}.
+// The title of the layout is replaced.
+func injectMeta(html []byte, m MetaTags) []byte {
+	if bytes.Contains(html, []byte("data-glv-meta")) {
+		return html
+	}
+	if m.Title != "" {
+		html = titleRe.ReplaceAll(html, nil)
+	}
+	return injectHead(html, []byte(m.HTML()))
+}
+
+// SetMeta sets the meta tags of the page. In OnMount they are rendered into the head, later they are sent
+// to the client as an operation.
+func (s sessionContext) SetMeta(m MetaTags) {
+	if s.meta != nil {
+		s.meta.tags = &m
+		return
+	}
+	s.dom.send(&Operation{
+		Op:    SetMeta,
+		Value: m,
+	})
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"time"
+)
+
+// The reasons of Metrics.MessageDropped.
+const (
+	// DropWriteFailed is a message which couldn't be written to a connection, which is then closed.
+	DropWriteFailed = "write_failed"
+	// DropNoPayloadKey is a sensitive operation for a connection without a payload key, see EnableEncryptedOps.
+	DropNoPayloadKey = "no_payload_key"
+	// DropQueueFull is a message sent to a connection whose write queue is full, see WithWriteQueue.
+	DropQueueFull = "queue_full"
+	// DropNoConnection is an operation targeted to the connection of an event which has none, e.g. an http mount.
+	DropNoConnection = "no_connection"
+)
+
+// OtherEventID is the event passed to Metrics.EventHandled for the event ids which aren't registered.
+const OtherEventID = "other"
+
+// Metrics receives the measurements of the controller, e.g. the Collector of the prometheus package. The methods
+// are called synchronously on the hot paths, concurrently, so they must be cheap and safe for concurrent use.
+type Metrics interface {
+	// ConnOpened and ConnClosed are called when a live connection of this instance is opened and closed.
+	ConnOpened()
+	ConnClosed()
+	// EventHandled is called once the handler of the event of view returns, with the time since the event was
+	// received and the error of the handler. The ids sent by the clients are arbitrary: event is OtherEventID unless
+	// the id is registered with RegisterEvent or with the EventHandlers of the view.
+	EventHandled(view, event string, d time.Duration, err error)
+	// Broadcast is called when a message of topic is written to conns local connections.
+	Broadcast(topic string, conns int)
+	// MessageDropped is called when a message isn't written to a connection, with one of the Drop reasons.
+	MessageDropped(topic, reason string)
+}
+
+// WithMetrics feeds metrics with the connections, the handled events, the broadcasts and the dropped messages.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *controlOpt) {
+		o.metrics = metrics
+	}
+}
+
+// metricsEventID returns the id of the event for Metrics.EventHandled, bounding the ids to the registered ones.
+func (v *viewHandler) metricsEventID(eventID string) string {
+	if eventID == NavigateEventID {
+		return eventID
+	}
+	if _, ok := v.wc.eventDecoders[eventID]; ok {
+		return eventID
+	}
+	if hv, ok := unwrapFragment(v.view).(EventHandlers); ok {
+		if _, ok := hv.EventHandlers()[eventID]; ok {
+			return eventID
+		}
+	}
+	return OtherEventID
+}
+
+// noMetrics is the default Metrics, discarding the measurements.
+type noMetrics struct{}
+
+func (noMetrics) ConnOpened()                                                 {}
+func (noMetrics) ConnClosed()                                                 {}
+func (noMetrics) EventHandled(view, event string, d time.Duration, err error) {}
+func (noMetrics) Broadcast(topic string, conns int)                           {}
+func (noMetrics) MessageDropped(topic, reason string)                         {}
+
+// registered reports whether the connection connID is subscribed to a topic. The caller holds the lock.
+func (wc *websocketController) registered(connID string) bool {
+	for _, conns := range wc.topicConnections {
+		if _, ok := conns[connID]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+)
+
+// Middleware wraps the handlers returned by the controller. It sees both the page mount and the
+// websocket upgrade requests, which can be told apart using IsUpgrade. A middleware can veto the
+// upgrade by responding without calling the next handler, and values it adds to the request context
+// are available to the view through Context.Context.
+type Middleware func(next http.Handler) http.Handler
+
+// WithMiddleware wraps the view handlers with middleware. The first one is the outermost.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(o *controlOpt) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// IsUpgrade reports whether r is a websocket upgrade request.
+func IsUpgrade(r *http.Request) bool {
+	return r.Header.Get("Connection") == "Upgrade" &&
+		r.Header.Get("Upgrade") == "websocket"
+}
+
+// DefaultSecurityHeaders are safe defaults for the SecurityHeaders middleware.
+var DefaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "SAMEORIGIN",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+}
+
+// SecurityHeaders returns a Middleware which sets headers on the page mount responses.
+func SecurityHeaders(headers map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsUpgrade(r) {
+				for k, v := range headers {
+					w.Header().Set(k, v)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type routeParamsKey struct{}
+
+// WithRouteParams returns a copy of r carrying the path parameters matched by a router. It's used by
+// the framework adapters so that the view can read them using RouteParam.
+func WithRouteParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeParamsKey{}, params))
+}
+
+// RouteParam returns the path parameter name of the request, e.g. RouteParam(ctx.Context(), "id").
+func RouteParam(ctx context.Context, name string) string {
+	params, _ := ctx.Value(routeParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func (wc *websocketController) wrap(h http.HandlerFunc) http.HandlerFunc {
+	var handler http.Handler = h
+	for i := len(wc.middleware) - 1; i >= 0; i-- {
+		handler = wc.middleware[i](handler)
+	}
+	return handler.ServeHTTP
+}
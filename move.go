@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"fmt"
+)
+
+// MoveConnections subscribes the connections of oldTopic to newTopic e.g. to move the players from a lobby to a
+// game instance without reconnecting. The moved sessions use the TopicStore of newTopic from their next event.
+func (wc *websocketController) MoveConnections(oldTopic, newTopic string) {
+	if oldTopic == newTopic {
+		return
+	}
+	wc.Lock()
+	conns := wc.topicConnections[oldTopic]
+	delete(wc.topicConnections, oldTopic)
+	if len(conns) > 0 {
+		if _, ok := wc.topicConnections[newTopic]; !ok {
+			wc.topicConnections[newTopic] = make(map[string]Conn)
+		}
+		for connID, conn := range conns {
+			wc.topicConnections[newTopic][connID] = conn
+		}
+	}
+	wc.Unlock()
+	wc.syncSubscription(oldTopic)
+	wc.syncSubscription(newTopic)
+
+	for connID := range conns {
+		wc.setTopic(connID, newTopic)
+	}
+	wc.logger.Info("connections moved", "from", oldTopic, "topic", newTopic, "conns", len(conns))
+}
+
+// moveConnection subscribes the connection connID of oldTopic to newTopic.
+func (wc *websocketController) moveConnection(connID, oldTopic, newTopic string) error {
+	if oldTopic == newTopic {
+		return nil
+	}
+	wc.Lock()
+	conn, ok := wc.topicConnections[oldTopic][connID]
+	if !ok {
+		wc.Unlock()
+		return fmt.Errorf("connection %s not found in topic %s", connID, oldTopic)
+	}
+	delete(wc.topicConnections[oldTopic], connID)
+	if len(wc.topicConnections[oldTopic]) == 0 {
+		delete(wc.topicConnections, oldTopic)
+	}
+	if _, ok := wc.topicConnections[newTopic]; !ok {
+		wc.topicConnections[newTopic] = make(map[string]Conn)
+	}
+	wc.topicConnections[newTopic][connID] = conn
+	wc.Unlock()
+	wc.syncSubscription(oldTopic)
+	wc.syncSubscription(newTopic)
+
+	wc.setTopic(connID, newTopic)
+	return nil
+}
+
+// setTopic moves the session of the connection to topic. The session is changed on the event path of the
+// connection since its events use it, so the move applies from the next event.
+func (wc *websocketController) setTopic(connID, topic string) {
+	store := wc.topicStores.getOrCreate(topic)
+	wc.liveConns.Lock()
+	c, ok := wc.liveConns.conns[connID]
+	if ok {
+		c.info.Topic = topic
+	}
+	wc.liveConns.Unlock()
+	if !ok {
+		return
+	}
+	wc.post(c.session, func(sessCtx *sessionContext) {
+		sessCtx.dom.topic = topic
+		sessCtx.topicStore = store
+	})
+}
+
+// Resubscribe moves the connection to topic: the DOM calls of the next events are broadcast to topic.
+func (s sessionContext) Resubscribe(topic string) error {
+	if s.connID == "" {
+		return fmt.Errorf("resubscribe %s: no live connection", topic)
+	}
+	return s.dom.wc.moveConnection(s.connID, s.dom.topic, topic)
+}
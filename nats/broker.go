@@ -0,0 +1,49 @@
+// Package nats relays the operations of the controller instances over NATS.
+package nats
+
+import (
+	"strings"
+
+	natsgo "github.com/nats-io/nats.go"
+
+	"github.com/goliveview/controller"
+)
+
+type broker struct {
+	conn   *natsgo.Conn
+	prefix string
+}
+
+// NewBroker returns a controller.Broker which relays the operations between the instances over the NATS
+// connection conn.
+func NewBroker(conn *natsgo.Conn) controller.Broker {
+	return &broker{conn: conn, prefix: "glv.topic."}
+}
+
+func (b *broker) Publish(topic string, message []byte) error {
+	return b.conn.Publish(b.subject(topic), message)
+}
+
+func (b *broker) Subscribe(topic string, handler func(message []byte)) (func(), error) {
+	sub, err := b.conn.Subscribe(b.subject(topic), func(msg *natsgo.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// make sure the server registered the subscription before the messages are published
+	if err := b.conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+	return func() {
+		sub.Unsubscribe()
+	}, nil
+}
+
+// subject maps topic to a NATS subject. The tokens separator and the wildcards can't be used in a subject token.
+func (b *broker) subject(topic string) string {
+	return b.prefix + subjectReplacer.Replace(topic)
+}
+
+var subjectReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_")
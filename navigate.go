@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidURL is returned when the url of a navigation operation could navigate the browser to a script or, for
+// PushState, to another origin.
+var ErrInvalidURL = errors.New("invalid navigation url")
+
+// Redirect navigates the browser to u e.g. after a successful form submit or a sign out. u is either relative or
+// an absolute http(s) url.
+func (d *dom) Redirect(u string) {
+	if err := validateRedirectURL(u); err != nil {
+		d.wc.logger.Warn("redirect", "topic", d.topic, "event", d.eventID, "url", u, "err", err)
+		return
+	}
+	m := &Operation{
+		Op:    Redirect,
+		Value: u,
+	}
+	d.send(m)
+}
+
+// PushState changes the url of the page to u without reloading it, adding an entry to the browser history or
+// replacing the current one. u must be on the origin of the page, so it can't have a scheme or a host.
+func (d *dom) PushState(u string, replace bool) {
+	if err := validatePushStateURL(u); err != nil {
+		d.wc.logger.Warn("push state", "topic", d.topic, "event", d.eventID, "url", u, "err", err)
+		return
+	}
+	m := &Operation{
+		Op: PushState,
+		Value: M{
+			"url":     u,
+			"replace": replace,
+		},
+	}
+	d.send(m)
+}
+
+func validateRedirectURL(u string) error {
+	u = strings.TrimSpace(u)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	switch parsed.Scheme {
+	case "":
+		// a relative url, but the browser reads //host as another host and \ as /
+		if strings.HasPrefix(u, "//") || strings.HasPrefix(u, `/\`) {
+			return fmt.Errorf("%w: protocol relative url", ErrInvalidURL)
+		}
+	case "http", "https":
+	default:
+		return fmt.Errorf("%w: scheme %s", ErrInvalidURL, parsed.Scheme)
+	}
+	return nil
+}
+
+func validatePushStateURL(u string) error {
+	if err := validateRedirectURL(u); err != nil {
+		return err
+	}
+	if parsed, _ := url.Parse(strings.TrimSpace(u)); parsed.Scheme != "" || parsed.Host != "" {
+		return fmt.Errorf("%w: %s is not on the origin of the page", ErrInvalidURL, u)
+	}
+	return nil
+}
+
+// validateNavigation checks the url of a redirect or pushState operation.
+func validateNavigation(m *Operation) error {
+	if m.Op == Redirect {
+		u, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("%w: expected url string, got %T", ErrInvalidValue, m.Value)
+		}
+		return validateRedirectURL(u)
+	}
+	var u interface{}
+	switch v := m.Value.(type) {
+	case M:
+		u = v["url"]
+	case map[string]interface{}:
+		u = v["url"]
+	}
+	s, ok := u.(string)
+	if !ok {
+		return fmt.Errorf("%w: expected url string, got %T", ErrInvalidValue, u)
+	}
+	return validatePushStateURL(s)
+}
+
+// NewRedirect returns an operation navigating the browser to u.
+func NewRedirect(u string) (*Operation, error) {
+	return newOperation(Redirect, "", u, nil)
+}
+
+// NewPushState returns an operation changing the url of the page to u without reloading it.
+func NewPushState(u string, replace bool) (*Operation, error) {
+	return newOperation(PushState, "", M{"url": u, "replace": replace}, nil)
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrReadOnly = errors.New("connection is read-only")
+
+// WithObservers makes the connections for which observe returns true read-only: they subscribe to their topics and
+// receive the operations, but their events are dropped except the allowedEvents e.g. a wall display dashboard or
+// a view embedded for anonymous visitors.
+func WithObservers(observe func(r *http.Request) bool, allowedEvents ...string) Option {
+	return func(o *controlOpt) {
+		o.observe = observe
+		o.observerEvents = allowedEvents
+	}
+}
+
+// ObserveParam is an observe func for WithObservers which makes the connections opened with the query parameter
+// param set, e.g. ?display=1, read-only.
+func ObserveParam(param string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return r.URL.Query().Get(param) != ""
+	}
+}
+
+func (wc *websocketController) isObserver(r *http.Request) bool {
+	return wc.observe != nil && wc.observe(r)
+}
+
+// observerAllowed reports whether an observer connection can send the event eventID.
+func (wc *websocketController) observerAllowed(eventID string) bool {
+	return contains(wc.observerEvents, eventID)
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrInvalidSelector is returned by the Operation constructors when the selector can't be parsed by the client.
+	ErrInvalidSelector = errors.New("invalid selector")
+	// ErrInvalidValue is returned by the Operation constructors when the value doesn't fit the op.
+	ErrInvalidValue = errors.New("invalid operation value")
+)
+
+// ValidateSelector does a syntax check of a css selector: it must be non-empty, its brackets and quotes must be
+// balanced and it must not start or end with a combinator. It doesn't check that the selector matches anything.
+func ValidateSelector(selector string) error {
+	s := strings.TrimSpace(selector)
+	if s == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidSelector)
+	}
+	var stack []rune
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			stack = append(stack, c)
+		case c == ']' || c == ')':
+			open := '['
+			if c == ')' {
+				open = '('
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Errorf("%w: unbalanced %q in %q", ErrInvalidSelector, c, selector)
+			}
+			stack = stack[:len(stack)-1]
+		case c == '{' || c == '}' || c == ';':
+			return fmt.Errorf("%w: unexpected %q in %q", ErrInvalidSelector, c, selector)
+		}
+	}
+	if quote != 0 || escaped || len(stack) != 0 {
+		return fmt.Errorf("%w: unterminated %q", ErrInvalidSelector, selector)
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return fmt.Errorf("%w: empty selector in list %q", ErrInvalidSelector, selector)
+		}
+		if strings.ContainsAny(part[:1], ">+~") || strings.ContainsAny(part[len(part)-1:], ">+~") {
+			return fmt.Errorf("%w: dangling combinator in %q", ErrInvalidSelector, selector)
+		}
+	}
+	return nil
+}
+
+// Validate checks the selector and the type of the value of the operation.
+func (m *Operation) Validate() error {
+	switch m.Op {
+	case Reload, Eval, SetCookie, SetMeta, Maintenance, Retry, Generation, BindKey, UnbindKey,
+		StartInterval, StopInterval, Console, Idle, Encrypted,
+		ReconnectToken, StateToken, Presence, PresenceState, UploadProgress, Error:
+		return nil
+	case Redirect, PushState:
+		return validateNavigation(m)
+	}
+	if err := ValidateSelector(m.Selector); err != nil {
+		return fmt.Errorf("op %s: %w", m.Op, err)
+	}
+	var err error
+	switch m.Op {
+	case Morph, SetInnerHTML, Append, Prepend, InsertBefore, InsertAfter:
+		if _, ok := m.Value.(string); !ok {
+			err = fmt.Errorf("%w: expected html string, got %T", ErrInvalidValue, m.Value)
+		}
+	case SetAttributes, Dataset:
+		err = validateNames(m.Value)
+	case RemoveAttributes:
+		names, ok := m.Value.([]string)
+		if !ok {
+			err = fmt.Errorf("%w: expected []string, got %T", ErrInvalidValue, m.Value)
+			break
+		}
+		for _, name := range names {
+			if err = validateName(name); err != nil {
+				break
+			}
+		}
+	case ClassList:
+		err = validateClassList(m.Value)
+	case AddClass, RemoveClass:
+		class, ok := m.Value.(string)
+		if !ok {
+			err = fmt.Errorf("%w: expected class name, got %T", ErrInvalidValue, m.Value)
+			break
+		}
+		err = validateName(class)
+	case SetValue:
+		err = validateScalar(m.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("op %s: %w", m.Op, err)
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\"'>/=") {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidValue, name)
+	}
+	return nil
+}
+
+func validateNames(v interface{}) error {
+	m, ok := v.(M)
+	if !ok {
+		if mm, isMap := v.(map[string]interface{}); isMap {
+			m, ok = M(mm), true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%w: expected M, got %T", ErrInvalidValue, v)
+	}
+	for k := range m {
+		if err := validateName(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateClassList(v interface{}) error {
+	switch classList := v.(type) {
+	case map[string]bool:
+		for class := range classList {
+			if err := validateName(class); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for class, on := range classList {
+			if err := validateName(class); err != nil {
+				return err
+			}
+			if _, ok := on.(bool); !ok {
+				return fmt.Errorf("%w: class %s expected bool, got %T", ErrInvalidValue, class, on)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: expected map[string]bool, got %T", ErrInvalidValue, v)
+}
+
+func validateScalar(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return nil
+	}
+	return fmt.Errorf("%w: expected a string, number or bool, got %T", ErrInvalidValue, v)
+}
+
+func newOperation(op Op, selector string, value interface{}, hints []Hint) (*Operation, error) {
+	m := &Operation{
+		Op:       op,
+		Selector: selector,
+		Value:    value,
+		Hints:    newHints(hints),
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewMorph returns a morph operation of the element matched by selector to the rendered html.
+func NewMorph(selector, html string, hints ...Hint) (*Operation, error) {
+	return newOperation(Morph, selector, html, hints)
+}
+
+// NewSetInnerHTML returns an operation replacing the content of the element matched by selector with html.
+func NewSetInnerHTML(selector, html string, hints ...Hint) (*Operation, error) {
+	return newOperation(SetInnerHTML, selector, html, hints)
+}
+
+// NewSetAttributes returns an operation setting the attributes of the element matched by selector.
+func NewSetAttributes(selector string, attributes M) (*Operation, error) {
+	return newOperation(SetAttributes, selector, attributes, nil)
+}
+
+// NewRemoveAttributes returns an operation removing the attributes of the element matched by selector.
+func NewRemoveAttributes(selector string, attributes []string) (*Operation, error) {
+	return newOperation(RemoveAttributes, selector, attributes, nil)
+}
+
+// NewClassList returns an operation toggling the classes of the element matched by selector.
+func NewClassList(selector string, classList map[string]bool) (*Operation, error) {
+	return newOperation(ClassList, selector, classList, nil)
+}
+
+// NewAddClass returns an operation adding class to the element matched by selector.
+func NewAddClass(selector, class string) (*Operation, error) {
+	return newOperation(AddClass, selector, class, nil)
+}
+
+// NewRemoveClass returns an operation removing class from the element matched by selector.
+func NewRemoveClass(selector, class string) (*Operation, error) {
+	return newOperation(RemoveClass, selector, class, nil)
+}
+
+// NewSetValue returns an operation setting the value of the input matched by selector.
+func NewSetValue(selector string, value interface{}) (*Operation, error) {
+	return newOperation(SetValue, selector, value, nil)
+}
@@ -0,0 +1,58 @@
+// Package otel traces the mounts, the events and the template renders of the controller with OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/goliveview/controller"
+)
+
+// instrumentation is the name of the tracer of the controller.
+const instrumentation = "github.com/goliveview/controller"
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a controller.Tracer starting the spans with the tracers of provider, e.g.
+// otel.GetTracerProvider(). The attributes are prefixed with glv., e.g. glv.event.
+func NewTracer(provider trace.TracerProvider) controller.Tracer {
+	return &tracer{tracer: provider.Tracer(instrumentation)}
+}
+
+func (t *tracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attributes(attrs)...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// attributes converts the key-value pairs of the controller to span attributes.
+func attributes(attrs []interface{}) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs)/2)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key := attribute.Key("glv." + fmt.Sprint(attrs[i]))
+		switch v := attrs[i+1].(type) {
+		case string:
+			kvs = append(kvs, key.String(v))
+		case int:
+			kvs = append(kvs, key.Int(v))
+		case int64:
+			kvs = append(kvs, key.Int64(v))
+		case bool:
+			kvs = append(kvs, key.Bool(v))
+		default:
+			kvs = append(kvs, key.String(fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrParamTooLarge is returned by Event.Binary and Event.BinaryReader when the decoded param exceeds the size limit.
+var ErrParamTooLarge = errors.New("param too large")
+
+// formParams converts event params sent as a urlencoded string e.g. "name=x&tags=a&tags=b", which is what
+// serializing a form with URLSearchParams produces, to a JSON object. Keys with a single value map to a string,
+// keys with several values or ending in "[]" map to an array of strings. JSON params are returned as is.
+func formParams(raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '"' {
+		return raw, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(s, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing urlencoded params: %w", err)
+	}
+	m := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		if strings.HasSuffix(k, "[]") {
+			m[strings.TrimSuffix(k, "[]")] = vs
+			continue
+		}
+		if len(vs) == 1 {
+			m[k] = vs[0]
+			continue
+		}
+		m[k] = vs
+	}
+	return json.Marshal(m)
+}
+
+// Binary decodes the base64 encoded param key e.g. a pasted file, a canvas image or a recorded audio blob. The value
+// can be plain base64, standard or url-safe, or a data URL e.g. "data:image/png;base64,...". It returns
+// ErrParamTooLarge if the decoded value is bigger than maxSize bytes.
+func (e Event) Binary(key string, maxSize int) ([]byte, error) {
+	r, err := e.BinaryReader(key, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// BinaryReader is like Binary but decodes the param as it is read.
+func (e Event) BinaryReader(key string, maxSize int) (io.Reader, error) {
+	_, data, err := e.binaryParam(key)
+	if err != nil {
+		return nil, err
+	}
+	data = strings.TrimRight(data, "=")
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(data, "-_") {
+		enc = base64.RawURLEncoding
+	}
+	if enc.DecodedLen(len(data)) > maxSize {
+		return nil, fmt.Errorf("param %s: %w", key, ErrParamTooLarge)
+	}
+	return base64.NewDecoder(enc, strings.NewReader(data)), nil
+}
+
+// BinaryType returns the media type of the param key if it's a data URL, "application/octet-stream" otherwise.
+func (e Event) BinaryType(key string) string {
+	mediaType, _, err := e.binaryParam(key)
+	if err != nil || mediaType == "" {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+func (e Event) binaryParam(key string) (string, string, error) {
+	var params map[string]interface{}
+	if err := e.DecodeParams(&params); err != nil {
+		return "", "", err
+	}
+	s, ok := params[key].(string)
+	if !ok {
+		return "", "", fmt.Errorf("param %s: not a base64 string", key)
+	}
+	if !strings.HasPrefix(s, "data:") {
+		return "", s, nil
+	}
+	header, data, ok := strings.Cut(strings.TrimPrefix(s, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", "", fmt.Errorf("param %s: not a base64 data URL", key)
+	}
+	mediaType, _, _ := strings.Cut(strings.TrimSuffix(header, ";base64"), ";")
+	return mediaType, data, nil
+}
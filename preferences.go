@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Preferences are user interface settings persisted in a signed cookie so that they survive across sessions.
+type Preferences struct {
+	Theme     string            `json:"theme,omitempty"`
+	Density   string            `json:"density,omitempty"`
+	Collapsed map[string]bool   `json:"collapsed,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
+}
+
+const preferencesKey = "preferences"
+
+var preferencesMaxAge = 365 * 24 * time.Hour
+
+// WithPreferencesKey sets the key used to sign the preferences cookie. Without it a random key is generated
+// and the preferences don't survive a restart of the server.
+func WithPreferencesKey(hashKey []byte) Option {
+	return func(o *controlOpt) {
+		o.preferencesKey = hashKey
+	}
+}
+
+func newPreferencesCodec(hashKey []byte) *securecookie.SecureCookie {
+	if len(hashKey) == 0 {
+		hashKey = securecookie.GenerateRandomKey(32)
+	}
+	sc := securecookie.New(hashKey, nil)
+	sc.SetSerializer(securecookie.JSONEncoder{})
+	sc.MaxAge(int(preferencesMaxAge.Seconds()))
+	return sc
+}
+
+func (wc *websocketController) preferencesCookieName() string {
+	return fmt.Sprintf("_glv_prefs_%s", strings.TrimSpace(wc.name))
+}
+
+func (wc *websocketController) readPreferences(r *http.Request) Preferences {
+	var p Preferences
+	c, err := r.Cookie(wc.preferencesCookieName())
+	if err != nil {
+		return p
+	}
+	if err := wc.preferencesCodec.Decode(c.Name, c.Value, &p); err != nil {
+		wc.logger.Warn("invalid preferences cookie", "err", err)
+	}
+	return p
+}
+
+func (wc *websocketController) encodePreferences(p Preferences) (*http.Cookie, error) {
+	value, err := wc.preferencesCodec.Encode(wc.preferencesCookieName(), p)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     wc.preferencesCookieName(),
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(preferencesMaxAge.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
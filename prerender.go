@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultCrawlerUserAgents are matched, case insensitively, against the User-Agent by EnablePrerender.
+var DefaultCrawlerUserAgents = []string{
+	"googlebot", "bingbot", "yandex", "baiduspider", "duckduckbot", "slurp", "applebot",
+	"facebookexternalhit", "twitterbot", "linkedinbot", "slackbot", "discordbot", "whatsapp",
+}
+
+// EnablePrerender serves crawlers, detected by their User-Agent containing one of userAgents or
+// DefaultCrawlerUserAgents if none is given, a non-interactive version of the views: the scripts are
+// removed, except for structured data, and a canonical link is added to the head. The templates can
+// also check .prerender.
+func EnablePrerender(userAgents ...string) Option {
+	return func(o *controlOpt) {
+		if len(userAgents) == 0 {
+			userAgents = DefaultCrawlerUserAgents
+		}
+		o.crawlerUserAgents = make([]string, len(userAgents))
+		for i, ua := range userAgents {
+			o.crawlerUserAgents[i] = strings.ToLower(ua)
+		}
+	}
+}
+
+func (wc *websocketController) isCrawler(r *http.Request) bool {
+	if len(wc.crawlerUserAgents) == 0 {
+		return false
+	}
+	ua := strings.ToLower(r.UserAgent())
+	for _, crawler := range wc.crawlerUserAgents {
+		if strings.Contains(ua, crawler) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	scriptRe    = regexp.MustCompile(`(?is)<script\b[^>]*>.*?</script\s*>`)
+	ldJSONRe    = regexp.MustCompile(`(?is)^<script\b[^>]*type\s*=\s*["']?application/ld\+json`)
+	canonicalRe = regexp.MustCompile(`(?is)<link\b[^>]*rel\s*=\s*["']?canonical`)
+)
+
+// prerender removes the scripts of the page, keeping the structured data, and adds the canonical link.
+func prerender(html []byte, canonical string) []byte {
+	html = scriptRe.ReplaceAllFunc(html, func(script []byte) []byte {
+		if ldJSONRe.Match(script) {
+			return script
+		}
+		return nil
+	})
+	if canonicalRe.Match(html) {
+		return html
+	}
+	return injectHead(html, []byte(fmt.Sprintf(`<link rel="canonical" href="%s">`, template.HTMLEscapeString(canonical))))
+}
+
+// injectHead inserts tags at the end of the head of the page.
+func injectHead(html, tags []byte) []byte {
+	i := bytes.Index(bytes.ToLower(html), []byte("</head>"))
+	if i < 0 {
+		return html
+	}
+	out := make([]byte, 0, len(html)+len(tags))
+	out = append(out, html[:i]...)
+	out = append(out, tags...)
+	return append(out, html[i:]...)
+}
+
+// canonicalURL returns the url of the request without the query.
+func canonicalURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
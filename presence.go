@@ -0,0 +1,350 @@
+package controller
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HeartbeatEventID is sent by the client every heartbeat interval while its page is visible and active, e.g.
+// {"id":"glv:heartbeat"}. It is handled by the controller and keeps the user online when WithPresenceTimeout is set.
+// The pings of WithHeartbeat are answered by the browser even when the page is hidden, so they can't tell an
+// away user from an online one.
+const HeartbeatEventID = "glv:heartbeat"
+
+// HeartbeatIntervalKey is the key of the heartbeat interval in milliseconds in the mount data when
+// WithPresenceTimeout is set.
+const HeartbeatIntervalKey = "glv_heartbeat_interval"
+
+// PresenceEventID is the id of the event passed to the OnPresence hook of the views.
+const PresenceEventID = "glv:presence"
+
+// PresenceStatus is the presence of a user on a topic.
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// PresenceChange is passed to the presence hook and broadcast to the topic in a presence operation when the
+// presence of a user changes.
+type PresenceChange struct {
+	Topic  string         `json:"topic"`
+	User   int            `json:"user"`
+	Status PresenceStatus `json:"status"`
+	// Meta is the metadata of the user returned by the meta func of EnablePresence.
+	Meta M `json:"meta,omitempty"`
+}
+
+// PresenceUser is a user present on a topic.
+type PresenceUser struct {
+	User   int            `json:"user"`
+	Status PresenceStatus `json:"status"`
+	// Conns is the number of connections of the user to the topic. It's 0 during the grace period of
+	// WithPresenceTimeout.
+	Conns    int       `json:"conns"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Meta     M         `json:"meta,omitempty"`
+}
+
+// PresenceReceiver is implemented by views which are notified when a user joins or leaves their topic, e.g. to
+// render the online users. OnPresence is called for each live connection of the topic with a ctx whose DOM
+// operations are only sent to that connection, and whose event is a PresenceEventID with the change in its params.
+type PresenceReceiver interface {
+	OnPresence(ctx Context, change PresenceChange) error
+}
+
+// EnablePresence tracks the users connected to each topic, listed by Controller.Presence and Context.Presence.
+// meta, which can be nil, returns the metadata of a user from its first connection e.g. its name read from the
+// Store. Each change is broadcast to the topic in a presence operation and passed to the OnPresence hook of the
+// views; a new connection receives the users of its topic in a presenceState operation. A user is online while it
+// has a connection, see WithPresenceTimeout to tell away users and to delay the offline changes. The presence is
+// tracked per instance.
+func EnablePresence(meta func(c ConnInfo) M) Option {
+	return func(o *controlOpt) {
+		o.presenceTracking = true
+		o.presenceMeta = meta
+	}
+}
+
+// WithPresenceTimeout tracks the presence of the users on their topic. A user is online while one of its
+// connections sends a message or a HeartbeatEventID at least every heartbeat interval of WithHeartbeat, away once
+// all of them missed missedHeartbeats heartbeats, and offline when its last connection has been closed for grace
+// so that a reload or a network blip doesn't flap the presence. Each change is passed to hook, which can be nil,
+// and broadcast to the topic like with EnablePresence. It requires WithHeartbeat.
+func WithPresenceTimeout(missedHeartbeats int, grace time.Duration, hook func(change PresenceChange)) Option {
+	return func(o *controlOpt) {
+		o.missedHeartbeats = missedHeartbeats
+		o.offlineGrace = grace
+		o.presenceHook = hook
+	}
+}
+
+type presenceKey struct {
+	topic string
+	user  int
+}
+
+type userPresence struct {
+	conns    map[string]struct{}
+	status   PresenceStatus
+	offline  *time.Timer
+	joinedAt time.Time
+	meta     M
+}
+
+type presenceConn struct {
+	key      presenceKey
+	lastSeen time.Time
+}
+
+// presenceTracker keeps the presence of the users per topic.
+type presenceTracker struct {
+	users map[presenceKey]*userPresence
+	conns map[string]*presenceConn
+	sync.Mutex
+}
+
+func (wc *websocketController) presenceEnabled() bool {
+	return wc.presenceTracking || wc.awayEnabled()
+}
+
+// awayEnabled reports whether the heartbeats of the connections are tracked to tell the away users.
+func (wc *websocketController) awayEnabled() bool {
+	return wc.missedHeartbeats > 0 && wc.pingInterval > 0
+}
+
+// joinPresence marks the user online on topic when its connection is added.
+func (wc *websocketController) joinPresence(topic string, user int, connID string) {
+	if !wc.presenceEnabled() || topic == "" {
+		return
+	}
+	p := &wc.presence
+	key := presenceKey{topic: topic, user: user}
+	p.Lock()
+	if p.users == nil {
+		p.users = make(map[presenceKey]*userPresence)
+		p.conns = make(map[string]*presenceConn)
+	}
+	u, ok := p.users[key]
+	if !ok {
+		u = &userPresence{conns: make(map[string]struct{}), status: PresenceOffline, joinedAt: time.Now()}
+		u.meta = wc.userPresenceMeta(connID)
+		p.users[key] = u
+	}
+	if u.offline != nil {
+		u.offline.Stop()
+		u.offline = nil
+	}
+	u.conns[connID] = struct{}{}
+	p.conns[connID] = &presenceConn{key: key, lastSeen: time.Now()}
+	changed := u.status != PresenceOnline
+	u.status = PresenceOnline
+	meta := u.meta
+	p.Unlock()
+	if changed {
+		wc.presenceChanged(PresenceChange{Topic: topic, User: user, Status: PresenceOnline, Meta: meta})
+	}
+	wc.sendPresenceState(topic, connID)
+}
+
+// userPresenceMeta returns the metadata of the user of the connection.
+func (wc *websocketController) userPresenceMeta(connID string) M {
+	if wc.presenceMeta == nil {
+		return nil
+	}
+	c, ok := wc.liveConns.get(connID)
+	if !ok {
+		return nil
+	}
+	return wc.presenceMeta(c.info)
+}
+
+// sendPresenceState sends the users of topic to the connection which joined it.
+func (wc *websocketController) sendPresenceState(topic, connID string) {
+	c, ok := wc.liveConns.get(connID)
+	if !ok {
+		return
+	}
+	m := &Operation{Op: PresenceState, Value: wc.Presence(topic)}
+	wc.messageConn(c.session.conn, m.encode(wc.logger))
+}
+
+// Presence returns the users present on topic on this instance, in the order they joined, see EnablePresence.
+func (wc *websocketController) Presence(topic string) []PresenceUser {
+	p := &wc.presence
+	p.Lock()
+	users := []PresenceUser{}
+	for key, u := range p.users {
+		if key.topic != topic || u.status == PresenceOffline {
+			continue
+		}
+		users = append(users, PresenceUser{
+			User:     key.user,
+			Status:   u.status,
+			Conns:    len(u.conns),
+			JoinedAt: u.joinedAt,
+			Meta:     u.meta,
+		})
+	}
+	p.Unlock()
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].JoinedAt.Equal(users[j].JoinedAt) {
+			return users[i].JoinedAt.Before(users[j].JoinedAt)
+		}
+		return users[i].User < users[j].User
+	})
+	return users
+}
+
+// Presence returns the users present on the topic of the connection, see EnablePresence.
+func (s sessionContext) Presence() []PresenceUser {
+	return s.dom.wc.Presence(s.dom.topic)
+}
+
+// seenPresence records a heartbeat of the connection and marks its user back online if it was away.
+func (wc *websocketController) seenPresence(connID string) {
+	if !wc.presenceEnabled() {
+		return
+	}
+	p := &wc.presence
+	p.Lock()
+	c, ok := p.conns[connID]
+	if !ok {
+		p.Unlock()
+		return
+	}
+	c.lastSeen = time.Now()
+	u := p.users[c.key]
+	changed := u.status == PresenceAway
+	u.status = PresenceOnline
+	meta := u.meta
+	p.Unlock()
+	if changed {
+		wc.presenceChanged(PresenceChange{Topic: c.key.topic, User: c.key.user, Status: PresenceOnline, Meta: meta})
+	}
+}
+
+// leavePresence removes the connection and marks its user offline after the grace period if it was the last one.
+func (wc *websocketController) leavePresence(connID string) {
+	if !wc.presenceEnabled() {
+		return
+	}
+	p := &wc.presence
+	p.Lock()
+	defer p.Unlock()
+	c, ok := p.conns[connID]
+	if !ok {
+		return
+	}
+	delete(p.conns, connID)
+	u := p.users[c.key]
+	delete(u.conns, connID)
+	if len(u.conns) > 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(wc.offlineGrace, func() {
+		p.Lock()
+		// the user reconnected or the timer was replaced in the meantime
+		if u.offline != timer || len(u.conns) > 0 {
+			p.Unlock()
+			return
+		}
+		delete(p.users, c.key)
+		u.status = PresenceOffline
+		p.Unlock()
+		wc.presenceChanged(PresenceChange{Topic: c.key.topic, User: c.key.user, Status: PresenceOffline, Meta: u.meta})
+	})
+	u.offline = timer
+}
+
+// markAway marks away the connected users whose connections all missed the heartbeats.
+func (wc *websocketController) markAway() {
+	p := &wc.presence
+	awayAfter := time.Duration(wc.missedHeartbeats) * wc.pingInterval
+	now := time.Now()
+	p.Lock()
+	lastSeen := make(map[presenceKey]time.Time)
+	for _, c := range p.conns {
+		if c.lastSeen.After(lastSeen[c.key]) {
+			lastSeen[c.key] = c.lastSeen
+		}
+	}
+	var changes []PresenceChange
+	for key, seen := range lastSeen {
+		u := p.users[key]
+		if u.status == PresenceOnline && now.Sub(seen) > awayAfter {
+			u.status = PresenceAway
+			changes = append(changes, PresenceChange{Topic: key.topic, User: key.user, Status: PresenceAway, Meta: u.meta})
+		}
+	}
+	p.Unlock()
+	for _, change := range changes {
+		wc.presenceChanged(change)
+	}
+}
+
+// trackPresence checks the missed heartbeats every heartbeat interval until the controller is shut down.
+func (wc *websocketController) trackPresence() {
+	ticker := time.NewTicker(wc.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wc.markAway()
+		case <-wc.shutdown.done:
+			return
+		}
+	}
+}
+
+// presenceChanged calls the presence hook, broadcasts the change to its topic and passes it to the views of the
+// local connections of the topic. It's called without the presence tracker locked.
+func (wc *websocketController) presenceChanged(change PresenceChange) {
+	if wc.debugLog {
+		wc.logger.Debug("presence changed", "topic", change.Topic, "user", change.User, "status", change.Status)
+	}
+	if wc.presenceHook != nil {
+		wc.presenceHook(change)
+	}
+	m := &Operation{Op: Presence, Value: change}
+	wc.message(change.Topic, m.encode(wc.logger))
+	wc.notifyPresence(change)
+}
+
+// notifyPresence posts the change to the local connections of its topic, whose OnPresence hook is called on their
+// event path.
+func (wc *websocketController) notifyPresence(change PresenceChange) {
+	conns, ok := wc.topicConns(change.Topic, "")
+	if !ok {
+		return
+	}
+	params, err := json.Marshal(change)
+	if err != nil {
+		wc.logger.Error("marshaling presence change", "topic", change.Topic, "err", err)
+		return
+	}
+	for _, c := range conns {
+		lc, ok := wc.liveConns.get(c.id)
+		if !ok || lc.handler == nil {
+			continue
+		}
+		v := lc.handler
+		wc.post(lc.session, func(sessCtx *sessionContext) {
+			receiver, ok := unwrapFragment(v.view).(PresenceReceiver)
+			if !ok {
+				return
+			}
+			sessCtx = sessCtx.fork()
+			sessCtx.dom = sessCtx.dom.targeted(toSelf)
+			sessCtx.event = Event{ID: PresenceEventID, Params: params}
+			if err := receiver.OnPresence(*sessCtx, change); err != nil {
+				wc.logger.Error("OnPresence", "topic", change.Topic, "conn", sessCtx.connID, "user", change.User, "err", err)
+			}
+		})
+	}
+}
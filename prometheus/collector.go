@@ -0,0 +1,112 @@
+// Package prometheus exports the metrics of the controller to Prometheus.
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/goliveview/controller"
+)
+
+// Collector is a controller.Metrics which is also a Prometheus collector, e.g.
+//
+//	metrics := prometheus.NewCollector("glv")
+//	promclient.MustRegister(metrics)
+//	wc := controller.Websocket("app", controller.WithMetrics(metrics))
+//
+// It exports:
+//
+//	<namespace>_connections                 gauge of the open live connections
+//	<namespace>_events_total                counter of the handled events by view, event and status (ok or error)
+//	<namespace>_event_duration_seconds      histogram of the handling time of the events by view and event
+//	<namespace>_broadcast_connections       histogram of the local connections a broadcast is written to
+//	<namespace>_dropped_messages_total      counter of the dropped messages by reason
+//
+// The topics aren't labels since there can be one per user or per page, and the events which aren't registered
+// are labelled controller.OtherEventID.
+type Collector struct {
+	connections   prom.Gauge
+	events        *prom.CounterVec
+	eventDuration *prom.HistogramVec
+	broadcasts    prom.Histogram
+	dropped       *prom.CounterVec
+}
+
+var _ controller.Metrics = (*Collector)(nil)
+
+// NewCollector returns a Collector whose metrics are prefixed with namespace.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		connections: prom.NewGauge(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connections",
+			Help:      "Number of open live connections.",
+		}),
+		events: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_total",
+			Help:      "Number of handled events.",
+		}, []string{"view", "event", "status"}),
+		eventDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "event_duration_seconds",
+			Help:      "Time to handle an event, from its reception.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"view", "event"}),
+		broadcasts: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "broadcast_connections",
+			Help:      "Number of local connections a broadcast is written to.",
+			Buckets:   prom.ExponentialBuckets(1, 4, 8),
+		}),
+		dropped: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "dropped_messages_total",
+			Help:      "Number of messages which weren't written to a connection.",
+		}, []string{"reason"}),
+	}
+}
+
+func (c *Collector) ConnOpened() {
+	c.connections.Inc()
+}
+
+func (c *Collector) ConnClosed() {
+	c.connections.Dec()
+}
+
+func (c *Collector) EventHandled(view, event string, d time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	c.events.WithLabelValues(view, event, status).Inc()
+	c.eventDuration.WithLabelValues(view, event).Observe(d.Seconds())
+}
+
+func (c *Collector) Broadcast(topic string, conns int) {
+	c.broadcasts.Observe(float64(conns))
+}
+
+func (c *Collector) MessageDropped(topic, reason string) {
+	c.dropped.WithLabelValues(reason).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.connections.Describe(ch)
+	c.events.Describe(ch)
+	c.eventDuration.Describe(ch)
+	c.broadcasts.Describe(ch)
+	c.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.connections.Collect(ch)
+	c.events.Collect(ch)
+	c.eventDuration.Collect(ch)
+	c.broadcasts.Collect(ch)
+	c.dropped.Collect(ch)
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ConnInfo describes a live connection. It's passed to the data func of PublishRender.
+type ConnInfo struct {
+	ID       string
+	Topic    string
+	User     int
+	Locale   string
+	Country  string
+	Variants map[string]string
+	// Store is the store of the user. It can hold e.g. the user's permissions.
+	Store   Store
+	Request *http.Request
+}
+
+type liveConn struct {
+	info    ConnInfo
+	session *sessionContext
+	handler *viewHandler
+}
+
+// liveConns tracks the sessions of the live connections.
+type liveConns struct {
+	conns map[string]*liveConn
+	sync.Mutex
+}
+
+func (l *liveConns) add(s *sessionContext, v *viewHandler) {
+	l.Lock()
+	defer l.Unlock()
+	if l.conns == nil {
+		l.conns = make(map[string]*liveConn)
+	}
+	l.conns[s.connID] = &liveConn{
+		info: ConnInfo{
+			ID:       s.connID,
+			Topic:    s.dom.topic,
+			User:     s.user,
+			Locale:   s.locale,
+			Country:  s.country,
+			Variants: s.variants,
+			Store:    s.dom.store,
+			Request:  s.r,
+		},
+		session: s,
+		handler: v,
+	}
+}
+
+func (l *liveConns) remove(connID string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.conns, connID)
+}
+
+func (l *liveConns) get(connID string) (*liveConn, bool) {
+	l.Lock()
+	defer l.Unlock()
+	c, ok := l.conns[connID]
+	return c, ok
+}
+
+// PublishRender morphs the element matched by selector on each connection subscribed to topic with the template
+// rendered separately, using the connection's own view template and the data returned by dataFn for it.
+// It allows personalized views of a shared update e.g. hiding the admin controls from the other users. The morph of
+// a connection is rendered, and dataFn called, once the events being handled by the connection have returned.
+func (wc *websocketController) PublishRender(topic, selector, template string, dataFn func(c ConnInfo) M) {
+	wc.RLock()
+	connIDs := make([]string, 0, len(wc.topicConnections[topic]))
+	for connID := range wc.topicConnections[topic] {
+		connIDs = append(connIDs, connID)
+	}
+	wc.RUnlock()
+
+	for _, connID := range connIDs {
+		c, ok := wc.liveConns.get(connID)
+		if !ok {
+			continue
+		}
+		info := c.info
+		wc.post(c.session, func(sessCtx *sessionContext) {
+			// a dom of its own, the dom of the session holds the event being handled, rendering for the user of
+			// the connection
+			d := sessCtx.fork().dom
+			d.target = toSelf
+			data := dataFn(info)
+			m, ok := d.morphOperation(selector, template, data, nil)
+			if !ok {
+				return
+			}
+			wc.messageConn(d.conn, m.encode(wc.logger))
+			// setStore deletes the temporary keys from the map it saves
+			stored := make(M, len(data))
+			for k, v := range data {
+				stored[k] = v
+			}
+			d.setStore(stored)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+)
+
+// ErrTemplateNotFound is returned by MorphTopic when no view defines the template.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Publish sends op to the connections subscribed to topic on all the instances. Unlike the DOM of a Context, it
+// can be called from anywhere e.g. a background job, a cron task or another http handler.
+func (wc *websocketController) Publish(topic string, op Operation) error {
+	if err := op.Validate(); err != nil {
+		return err
+	}
+	wc.topicDOM(topic).send(&op)
+	return nil
+}
+
+// MorphTopic morphs the element matched by selector on the connections subscribed to topic with template rendered
+// with data, like DOM.Morph. The template is looked up in the views served by the controller. The templates are
+// rendered once for all the users, in the time zone of the server unless data has one.
+func (wc *websocketController) MorphTopic(topic, selector, template string, data M) error {
+	if err := ValidateSelector(selector); err != nil {
+		return err
+	}
+	d := wc.topicDOM(topic)
+	t, ok := wc.lookupTemplate(template)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, template)
+	}
+	d.rootTemplate = t
+	op, ok := d.morphOperation(selector, template, data, nil)
+	if !ok {
+		return fmt.Errorf("rendering template %s", template)
+	}
+	d.send(op)
+	return nil
+}
+
+// topicDOM returns a DOM sending the operations to topic. The selectors are scoped like the ones of the DOM of the
+// local connections of topic, if any, e.g. to the container of a fragment.
+func (wc *websocketController) topicDOM(topic string) *dom {
+	d := &dom{topic: topic, wc: wc}
+	conns, _ := wc.topicConns(topic, "")
+	for _, c := range conns {
+		if lc, ok := wc.liveConns.get(c.id); ok {
+			// set on creation of the session, they can be read while it handles an event
+			d.selectorPrefix = lc.session.dom.selectorPrefix
+			d.classScope = lc.session.dom.classScope
+			break
+		}
+	}
+	return d
+}
+
+// lookupTemplate returns the templates of the first view, other than the error view, defining name.
+func (wc *websocketController) lookupTemplate(name string) (*template.Template, bool) {
+	errorViewKey := viewKey(wc.errorView)
+	wc.compiledViews.Lock()
+	views := append([]*compiledView(nil), wc.compiledViews.views...)
+	wc.compiledViews.Unlock()
+	for _, cv := range views {
+		if viewKey(cv.view) == errorViewKey {
+			continue
+		}
+		t, err := cv.template(wc.checkTemplates())
+		if err != nil || t.Lookup(name) == nil {
+			continue
+		}
+		return t, true
+	}
+	return nil, false
+}
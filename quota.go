@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrStoreQuotaExceeded = errors.New("store quota exceeded")
+
+// EvictionPolicy decides what happens when a write would exceed the StoreQuota.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used keys to make room for the write.
+	EvictLRU EvictionPolicy = iota
+	// RejectWrites fails the write with ErrStoreQuotaExceeded.
+	RejectWrites
+)
+
+// StoreQuota limits the size of each user's store. A zero limit is unlimited.
+type StoreQuota struct {
+	// MaxBytes is the maximum total size of the keys and the json encoded values.
+	MaxBytes int
+	MaxKeys  int
+	Policy   EvictionPolicy
+}
+
+// WithStoreQuota limits the size of the user stores so that a buggy view can't grow them without bound.
+func WithStoreQuota(quota StoreQuota) Option {
+	return func(o *controlOpt) {
+		o.storeQuota = &quota
+	}
+}
+
+func (q *StoreQuota) exceeded(size, keys int) bool {
+	return (q.MaxBytes > 0 && size > q.MaxBytes) || (q.MaxKeys > 0 && keys > q.MaxKeys)
+}
+
+// makeRoom checks that the encoded entries fit in the quota, evicting the least recently used keys if allowed.
+// It's called with the store locked.
+func (s *inmemStore) makeRoom(entries map[string][]byte) error {
+	size, keys := s.size, len(s.data)
+	for k, v := range entries {
+		if old, ok := s.data[k]; ok {
+			size -= len(k) + len(old)
+		} else {
+			keys++
+		}
+		size += len(k) + len(v)
+	}
+	if !s.quota.exceeded(size, keys) {
+		return nil
+	}
+	if s.quota.Policy == RejectWrites {
+		return fmt.Errorf("put %d keys, size %d bytes, %d keys: %w", len(entries), size, keys, ErrStoreQuotaExceeded)
+	}
+
+	var candidates []string
+	for k := range s.data {
+		if _, ok := entries[k]; !ok {
+			candidates = append(candidates, k)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return s.used[candidates[i]] < s.used[candidates[j]]
+	})
+	var evict []string
+	for _, k := range candidates {
+		if !s.quota.exceeded(size, keys) {
+			break
+		}
+		size -= len(k) + len(s.data[k])
+		keys--
+		evict = append(evict, k)
+	}
+	if s.quota.exceeded(size, keys) {
+		return fmt.Errorf("put %d keys larger than the quota: %w", len(entries), ErrStoreQuotaExceeded)
+	}
+	for _, k := range evict {
+		s.size -= len(k) + len(s.data[k])
+		delete(s.data, k)
+		delete(s.used, k)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+var ErrRateLimited = errors.New("too many requests")
+
+// ErrorCodeRateLimited is the code of the Error operation answering an event over WithEventRateLimit or
+// WithRateLimiter.
+const ErrorCodeRateLimited = "rate_limited"
+
+// WithEventRateLimit limits the messages of each live connection to r per second, with bursts of up to burst
+// messages. All the messages count, including the heartbeats and the chunks of the uploads. The events over the
+// limit aren't handled: the connection is sent an Error operation with the code rate_limited, the id of the event
+// and the milliseconds to wait before sending it again in retryAfterMs; an upload whose chunk is over the limit is
+// aborted. Unlike WithRateLimiter, the limit is local to the instance and applies to the connections, not to the
+// sessions: the remote connections keep their limit between their messages, see Remote. burst must be at least 1.
+func WithEventRateLimit(r rate.Limit, burst int) Option {
+	if burst < 1 {
+		panic(fmt.Sprintf("event rate limit: burst %d must be at least 1", burst))
+	}
+	return func(o *controlOpt) {
+		o.eventRate = r
+		o.eventBurst = burst
+	}
+}
+
+// WithMaxMessageSize closes the live websocket connections which send a message larger than bytes, including the
+// binary messages of the uploads whose chunks must fit. The larger events posted to a server-sent events
+// connection are answered with 413. By default the websocket messages aren't limited.
+func WithMaxMessageSize(bytes int64) Option {
+	return func(o *controlOpt) {
+		o.maxMessageSize = bytes
+	}
+}
+
+// setReadLimit applies WithMaxMessageSize to c: reading a larger message fails, ending the read loop of c.
+func (wc *websocketController) setReadLimit(c *websocket.Conn) {
+	if wc.maxMessageSize > 0 {
+		c.SetReadLimit(wc.maxMessageSize)
+	}
+}
+
+// newEventLimiter returns the limiter of the events of a live connection, nil without WithEventRateLimit.
+func (wc *websocketController) newEventLimiter() *rate.Limiter {
+	if wc.eventRate <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(wc.eventRate, wc.eventBurst)
+}
+
+// allowEvent reports whether the event eventID of the connection of sessCtx is within its rate limit. Otherwise
+// the connection is sent the Error operation.
+func (v *viewHandler) allowEvent(sessCtx *sessionContext, eventID string) bool {
+	if sessCtx.limiter == nil {
+		return true
+	}
+	r := sessCtx.limiter.Reserve()
+	delay := r.Delay()
+	if r.OK() && delay == 0 {
+		return true
+	}
+	r.Cancel()
+	v.rateLimited(sessCtx, eventID, delay)
+	return false
+}
+
+// allowUser reports whether the session of the connection of sessCtx is allowed another event by the RateLimiter
+// of WithRateLimiter. Otherwise the connection is sent the Error operation like over WithEventRateLimit.
+func (v *viewHandler) allowUser(sessCtx *sessionContext, eventID string) bool {
+	if v.wc.rateLimiter == nil {
+		return true
+	}
+	// the session id is unique across the instances, unlike the user ids without WithUserIDs
+	allowed, err := v.wc.rateLimiter.Allow(fmt.Sprintf("%s:%s", v.wc.name, v.sessionID))
+	if err != nil {
+		v.wc.logger.Error("rate limiter", "user", v.user, "err", err)
+		return true
+	}
+	if !allowed {
+		v.rateLimited(sessCtx, eventID, 0)
+	}
+	return allowed
+}
+
+// rateLimited sends the Error operation answering the event eventID over a rate limit to the connection of sessCtx.
+// retryAfter is the wait before the event is allowed again, if known.
+func (v *viewHandler) rateLimited(sessCtx *sessionContext, eventID string, retryAfter time.Duration) {
+	v.wc.logger.Warn("event over the rate limit", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user,
+		"event", eventID)
+	value := M{
+		"code":    ErrorCodeRateLimited,
+		"message": ErrRateLimited.Error(),
+		"event":   eventID,
+	}
+	if retryAfter > 0 {
+		value["retryAfterMs"] = retryAfter.Milliseconds()
+	}
+	m := &Operation{Op: Error, Value: value}
+	v.wc.messageConn(sessCtx.conn, m.encode(v.wc.logger))
+}
+
+// RateLimiter decides whether the client identified by key is allowed to send another event.
+// Implementations backed by a shared store apply the limit across all the controller instances.
+type RateLimiter interface {
+	Allow(key string) (bool, error)
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+type inmemRateLimiter struct {
+	limit   int
+	period  time.Duration
+	windows map[string]*window
+	sync.Mutex
+}
+
+// NewRateLimiter returns an in-memory RateLimiter which allows limit events per period for each key.
+func NewRateLimiter(limit int, period time.Duration) RateLimiter {
+	return &inmemRateLimiter{
+		limit:   limit,
+		period:  period,
+		windows: make(map[string]*window),
+	}
+}
+
+func (r *inmemRateLimiter) Allow(key string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	w, ok := r.windows[key]
+	if !ok || now.Sub(w.start) >= r.period {
+		r.evict(now)
+		w = &window{start: now}
+		r.windows[key] = w
+	}
+	if w.count >= r.limit {
+		return false, nil
+	}
+	w.count++
+	return true, nil
+}
+
+// evict removes the expired windows.
+func (r *inmemRateLimiter) evict(now time.Time) {
+	for k, w := range r.windows {
+		if now.Sub(w.start) >= r.period {
+			delete(r.windows, k)
+		}
+	}
+}
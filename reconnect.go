@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReconnectTokenKey is the key of the reconnect token in the mount data when EnableReconnectTokens is set. The
+// client sends it in the reconnect query parameter of the live connection url and replaces it with the token of
+// the reconnectToken operation it receives once connected.
+const ReconnectTokenKey = "glv_reconnect_token"
+
+var ErrInvalidReconnectToken = errors.New("invalid reconnect token")
+
+// TokenStore keeps the reconnect tokens. A shared implementation e.g. redis.NewTokenStore lets the clients
+// reconnect to another instance.
+type TokenStore interface {
+	// Put saves token bound to binding for ttl.
+	Put(token, binding string, ttl time.Duration) error
+	// Take removes token and returns its binding. It returns false if the token doesn't exist or expired.
+	Take(token string) (string, bool, error)
+}
+
+// EnableReconnectTokens requires the live connections to present a single use token bound to the user and topic
+// of the page. A token is issued on mount and rotated each time it's used, so a leaked token can't be replayed
+// nor used for another user or topic. Tokens expire after ttl.
+func EnableReconnectTokens(ttl time.Duration) Option {
+	return func(o *controlOpt) {
+		o.reconnectTokenTTL = ttl
+	}
+}
+
+// WithTokenStore configures the TokenStore of the reconnect tokens. Defaults to an in-memory store.
+func WithTokenStore(store TokenStore) Option {
+	return func(o *controlOpt) {
+		o.tokenStore = store
+	}
+}
+
+func tokenBinding(user int, topic string) string {
+	return fmt.Sprintf("%d:%s", user, topic)
+}
+
+func newReconnectToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issueReconnectToken returns a new token for the user's connections to topic.
+func (wc *websocketController) issueReconnectToken(user int, topic string) (string, error) {
+	token, err := newReconnectToken()
+	if err != nil {
+		return "", err
+	}
+	if err := wc.tokenStore.Put(token, tokenBinding(user, topic), wc.reconnectTokenTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// verifyReconnect consumes the token of a new live connection of the user to topic. It answers 403 and returns
+// false if the token is missing, expired, already used or bound to another user or topic.
+func (wc *websocketController) verifyReconnect(w http.ResponseWriter, token string, user int, topic string) bool {
+	if wc.reconnectTokenTTL <= 0 {
+		return true
+	}
+	err := ErrInvalidReconnectToken
+	if token != "" {
+		binding, ok, takeErr := wc.tokenStore.Take(token)
+		switch {
+		case takeErr != nil:
+			err = takeErr
+		case ok && binding == tokenBinding(user, topic):
+			return true
+		}
+	}
+	wc.logger.Warn("rejecting live connection", "topic", topic, "user", user, "err", err)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// rotateReconnectToken sends the token of the next reconnection to conn.
+func (wc *websocketController) rotateReconnectToken(conn Conn, user int, topic string) {
+	if wc.reconnectTokenTTL <= 0 {
+		return
+	}
+	token, err := wc.issueReconnectToken(user, topic)
+	if err != nil {
+		wc.logger.Error("issuing reconnect token", "topic", topic, "user", user, "err", err)
+		return
+	}
+	m := &Operation{Op: ReconnectToken, Value: token}
+	wc.messageConn(conn, m.encode(wc.logger))
+}
+
+type issuedToken struct {
+	binding string
+	expires time.Time
+}
+
+type inmemTokenStore struct {
+	tokens    map[string]issuedToken
+	lastPrune time.Time
+	sync.Mutex
+}
+
+func newInmemTokenStore() *inmemTokenStore {
+	return &inmemTokenStore{tokens: make(map[string]issuedToken)}
+}
+
+func (s *inmemTokenStore) Put(token, binding string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for t, issued := range s.tokens {
+			if now.After(issued.expires) {
+				delete(s.tokens, t)
+			}
+		}
+		s.lastPrune = now
+	}
+	s.tokens[token] = issuedToken{binding: binding, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *inmemTokenStore) Take(token string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	issued, ok := s.tokens[token]
+	if !ok {
+		return "", false, nil
+	}
+	delete(s.tokens, token)
+	if time.Now().After(issued.expires) {
+		return "", false, nil
+	}
+	return issued.binding, true, nil
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Redacted replaces the values removed by a RedactionPolicy.
+const Redacted = "[REDACTED]"
+
+// RedactionPolicy removes sensitive values from the event params and the store data before they are logged.
+// Struct fields tagged with `glv:"redact"` are always redacted. Custom audit sinks can apply the same policy
+// using Redact.
+type RedactionPolicy struct {
+	// Keys are matched case insensitively against the map keys and the json names of the struct fields
+	// which contain them e.g. password matches new_password.
+	Keys []string
+}
+
+// DefaultRedactionPolicy is used unless a policy is set with WithRedactionPolicy.
+var DefaultRedactionPolicy = RedactionPolicy{
+	Keys: []string{"password", "passwd", "secret", "token", "authorization", "cookie", "api_key", "apikey",
+		"credit_card", "card_number", "cvv", "ssn"},
+}
+
+// WithRedactionPolicy sets the policy applied to the logged data.
+func WithRedactionPolicy(policy RedactionPolicy) Option {
+	return func(o *controlOpt) {
+		o.redaction = policy
+	}
+}
+
+// secretKeys are the keys of the secrets of the controller, redacted whatever the policy.
+var secretKeys = []string{PayloadKey, CSRFTokenKey, ReconnectTokenKey}
+
+func (p RedactionPolicy) sensitive(key string) bool {
+	if contains(secretKeys, key) {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, k := range p.Keys {
+		if strings.Contains(key, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of v, with the maps and structs converted to map[string]interface{}, in which the
+// values of the sensitive keys are replaced by Redacted.
+func (p RedactionPolicy) Redact(v interface{}) interface{} {
+	return p.redact(reflect.ValueOf(v))
+}
+
+func (p RedactionPolicy) redact(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if m, ok := v.Interface().(json.Marshaler); ok {
+		if _, raw := m.(json.RawMessage); !raw {
+			return m
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return p.redact(v.Elem())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if p.sensitive(key) {
+				out[key] = Redacted
+				continue
+			}
+			out[key] = p.redact(iter.Value())
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			if f.Tag.Get("glv") == "redact" || p.sensitive(name) {
+				out[name] = Redacted
+				continue
+			}
+			out[name] = p.redact(v.Field(i))
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if raw, ok := v.Interface().(json.RawMessage); ok {
+			return p.redactJSON(raw)
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = p.redact(v.Index(i))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+func (p RedactionPolicy) redactJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return Redacted
+	}
+	return p.Redact(v)
+}
+
+// event returns a copy of the event with the params and meta redacted.
+func (p RedactionPolicy) event(e Event) Event {
+	if len(e.Params) > 0 {
+		if b, err := json.Marshal(p.redactJSON(e.Params)); err == nil {
+			e.Params = b
+		}
+	}
+	if len(e.Meta) > 0 {
+		meta := make(map[string]string, len(e.Meta))
+		for k, v := range e.Meta {
+			if p.sensitive(k) {
+				v = Redacted
+			}
+			meta[k] = v
+		}
+		e.Meta = meta
+	}
+	return e
+}
+
+// redactedEvent returns the event of ctx redacted using the policy of the controller.
+func redactedEvent(ctx Context) Event {
+	if s, ok := ctx.(sessionContext); ok && s.dom != nil && s.dom.wc != nil {
+		return s.dom.wc.redaction.event(ctx.Event())
+	}
+	return DefaultRedactionPolicy.event(ctx.Event())
+}
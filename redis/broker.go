@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+// subscribeTimeout bounds the wait for Redis to confirm a subscription.
+const subscribeTimeout = 10 * time.Second
+
+type broker struct {
+	client redis.UniversalClient
+	prefix string
+	// pubsub multiplexes the subscriptions of all the topics over a single connection.
+	pubsub   *redis.PubSub
+	handlers map[string]func(message []byte)
+	// confirmations are signalled once Redis confirms the subscription of their channel.
+	confirmations map[string][]chan struct{}
+	sync.Mutex
+}
+
+// NewBroker returns a controller.Broker which relays the operations between the instances over Redis pub/sub.
+func NewBroker(client redis.UniversalClient) controller.Broker {
+	return &broker{
+		client:        client,
+		prefix:        "glv:topic:",
+		handlers:      make(map[string]func(message []byte)),
+		confirmations: make(map[string][]chan struct{}),
+	}
+}
+
+func (b *broker) Publish(topic string, message []byte) error {
+	return b.client.Publish(context.Background(), b.prefix+topic, message).Err()
+}
+
+func (b *broker) Subscribe(topic string, handler func(message []byte)) (func(), error) {
+	ctx := context.Background()
+	channel := b.prefix + topic
+	confirmed := make(chan struct{})
+	b.Lock()
+	if b.pubsub == nil {
+		b.pubsub = b.client.Subscribe(ctx)
+		go b.receive(b.pubsub.ChannelWithSubscriptions(ctx, 100))
+	}
+	pubsub := b.pubsub
+	b.handlers[channel] = handler
+	b.confirmations[channel] = append(b.confirmations[channel], confirmed)
+	b.Unlock()
+
+	err := pubsub.Subscribe(ctx, channel)
+	if err == nil {
+		// wait for the confirmation so that the messages published after Subscribe returns are received
+		select {
+		case <-confirmed:
+			return func() {
+				b.Lock()
+				delete(b.handlers, channel)
+				b.Unlock()
+				pubsub.Unsubscribe(ctx, channel)
+			}, nil
+		case <-time.After(subscribeTimeout):
+			err = fmt.Errorf("subscribing to %s: not confirmed after %s", channel, subscribeTimeout)
+		}
+	}
+	b.Lock()
+	delete(b.handlers, channel)
+	b.removeConfirmation(channel, confirmed)
+	b.Unlock()
+	pubsub.Unsubscribe(ctx, channel)
+	return nil, err
+}
+
+// receive dispatches the messages of the subscribed channels to their handler.
+func (b *broker) receive(messages <-chan interface{}) {
+	for msg := range messages {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			b.Lock()
+			for _, confirmed := range b.confirmations[msg.Channel] {
+				close(confirmed)
+			}
+			delete(b.confirmations, msg.Channel)
+			b.Unlock()
+		case *redis.Message:
+			b.Lock()
+			handler, ok := b.handlers[msg.Channel]
+			b.Unlock()
+			if ok {
+				handler([]byte(msg.Payload))
+			}
+		}
+	}
+}
+
+func (b *broker) removeConfirmation(channel string, confirmed chan struct{}) {
+	pending := b.confirmations[channel]
+	for i, c := range pending {
+		if c == confirmed {
+			b.confirmations[channel] = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(b.confirmations[channel]) == 0 {
+		delete(b.confirmations, channel)
+	}
+}
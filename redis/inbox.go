@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+type inbox struct {
+	client redis.UniversalClient
+	key    string
+}
+
+// NewInbox returns a controller.InboxBackend which keeps the queued events in the Redis list name so that
+// they survive a restart.
+func NewInbox(client redis.UniversalClient, name string) controller.InboxBackend {
+	return &inbox{client: client, key: fmt.Sprintf("glv:inbox:%s", name)}
+}
+
+func (i *inbox) Push(e controller.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return i.client.RPush(context.Background(), i.key, data).Err()
+}
+
+func (i *inbox) Pop() (controller.Event, bool, error) {
+	var e controller.Event
+	data, err := i.client.LPop(context.Background(), i.key).Bytes()
+	if err == redis.Nil {
+		return e, false, nil
+	}
+	if err != nil {
+		return e, false, err
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, false, fmt.Errorf("decoding event: %w", err)
+	}
+	return e, true, nil
+}
+
+func (i *inbox) Len() (int, error) {
+	n, err := i.client.LLen(context.Background(), i.key).Result()
+	return int(n), err
+}
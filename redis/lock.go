@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+type locker struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewLocker returns a controller.Locker which keeps the leases in Redis so that a lease is held across all the
+// instances, e.g. for controller.WithLocker.
+func NewLocker(client redis.UniversalClient) controller.Locker {
+	return &locker{client: client, prefix: "glv:lock:"}
+}
+
+// acquireScript sets the lease to the owner, or renews it if the owner holds it.
+// KEYS: lease. ARGV: owner, ttl in ms.
+var acquireScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// releaseScript deletes the lease if it's held by the owner.
+// KEYS: lease. ARGV: owner.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (l *locker) Acquire(name, owner string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	acquired, err := acquireScript.Run(ctx, l.client, []string{l.lease(name)}, owner, ttl.Milliseconds()).Int()
+	if err != nil || acquired == 0 {
+		return false, err
+	}
+	// the leases of the owner, for ReleaseAll. The connections are closed before ttl is over in the usual case.
+	pipe := l.client.TxPipeline()
+	pipe.SAdd(ctx, l.owned(owner), name)
+	pipe.PExpire(ctx, l.owned(owner), ttl)
+	_, err = pipe.Exec(ctx)
+	return true, err
+}
+
+func (l *locker) Release(name, owner string) error {
+	ctx := context.Background()
+	released, err := releaseScript.Run(ctx, l.client, []string{l.lease(name)}, owner).Int()
+	if err != nil {
+		return err
+	}
+	l.client.SRem(ctx, l.owned(owner), name)
+	if released == 1 {
+		return l.client.Publish(ctx, l.released(name), owner).Err()
+	}
+	return nil
+}
+
+func (l *locker) ReleaseAll(owner string) error {
+	names, err := l.client.SMembers(context.Background(), l.owned(owner)).Result()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := l.Release(name, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Released subscribes to the release of the lease name until it's released or expires.
+func (l *locker) Released(name string) <-chan struct{} {
+	ch := make(chan struct{})
+	ctx := context.Background()
+	pubsub := l.client.Subscribe(ctx, l.released(name))
+	// subscribed before checking the lease so that a release in between isn't missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		close(ch)
+		return ch
+	}
+	ttl, err := l.client.PTTL(ctx, l.lease(name)).Result()
+	if err != nil || ttl <= 0 {
+		pubsub.Close()
+		close(ch)
+		return ch
+	}
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+		select {
+		case <-pubsub.Channel():
+		case <-time.After(ttl):
+		}
+	}()
+	return ch
+}
+
+func (l *locker) lease(name string) string {
+	return l.prefix + name
+}
+
+func (l *locker) owned(owner string) string {
+	return l.prefix + "owner:" + owner
+}
+
+func (l *locker) released(name string) string {
+	return l.prefix + "released:" + name
+}
@@ -0,0 +1,44 @@
+// Package redis provides Redis backed implementations of the controller extension points
+// so that several controller instances can share state.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+type rateLimiter struct {
+	client redis.UniversalClient
+	prefix string
+	limit  int
+	period time.Duration
+}
+
+// NewRateLimiter returns a controller.RateLimiter which allows limit events per period for each key
+// across all the instances sharing the Redis client.
+func NewRateLimiter(client redis.UniversalClient, limit int, period time.Duration) controller.RateLimiter {
+	return &rateLimiter{
+		client: client,
+		prefix: "glv:ratelimit",
+		limit:  limit,
+		period: period,
+	}
+}
+
+func (r *rateLimiter) Allow(key string) (bool, error) {
+	ctx := context.Background()
+	windowKey := fmt.Sprintf("%s:%s:%d", r.prefix, key, time.Now().UnixNano()/int64(r.period))
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, windowKey)
+	pipe.PExpire(ctx, windowKey, r.period)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	return incr.Val() <= int64(r.limit), nil
+}
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller/apigateway"
+)
+
+type registry struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+type connection struct {
+	Topic  string      `json:"topic"`
+	Header http.Header `json:"header"`
+}
+
+// NewRegistry returns an apigateway.Registry which keeps the connections in Redis.
+func NewRegistry(client redis.UniversalClient) apigateway.Registry {
+	return &registry{client: client, prefix: "glv:conns"}
+}
+
+func (r *registry) connKey(connID string) string {
+	return fmt.Sprintf("%s:conn:%s", r.prefix, connID)
+}
+
+func (r *registry) topicKey(topic string) string {
+	return fmt.Sprintf("%s:topic:%s", r.prefix, topic)
+}
+
+func (r *registry) Add(ctx context.Context, connID, topic string, header http.Header) error {
+	data, err := json.Marshal(connection{Topic: topic, Header: header})
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, r.connKey(connID), data, 0)
+	pipe.SAdd(ctx, r.topicKey(topic), connID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *registry) get(ctx context.Context, connID string) (*connection, error) {
+	data, err := r.client.Get(ctx, r.connKey(connID)).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("conn %s: %w", connID, err)
+	}
+	c := new(connection)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *registry) Get(ctx context.Context, connID string) (string, http.Header, error) {
+	c, err := r.get(ctx, connID)
+	if err != nil {
+		return "", nil, err
+	}
+	return c.Topic, c.Header, nil
+}
+
+func (r *registry) Remove(ctx context.Context, connID string) error {
+	c, err := r.get(ctx, connID)
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, r.connKey(connID))
+	pipe.SRem(ctx, r.topicKey(c.Topic), connID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *registry) Connections(ctx context.Context, topic string) ([]string, error) {
+	return r.client.SMembers(ctx, r.topicKey(topic)).Result()
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+// StoreOptions configures the stores created by NewStoreFactory.
+type StoreOptions struct {
+	// Quota limits the size of each user's store like controller.WithStoreQuota does for the in-memory stores.
+	Quota *controller.StoreQuota
+	// TTL expires the store of a user after a period without writes. Zero keeps the stores forever.
+	TTL time.Duration
+}
+
+type store struct {
+	client redis.UniversalClient
+	key    string
+	used   string
+	opts   StoreOptions
+}
+
+// NewStoreFactory returns a factory for controller.WithStoreFactory which keeps each user's store in a Redis hash.
+func NewStoreFactory(client redis.UniversalClient, opts StoreOptions) func(user int) controller.Store {
+	return func(user int) controller.Store {
+		// the hash tag keeps both keys of a user in the same slot of a Redis Cluster for the scripts
+		key := fmt.Sprintf("glv:store:{%d}", user)
+		return &store{client: client, key: key, used: key + ":used", opts: opts}
+	}
+}
+
+// putScript writes the entries, making room for them within the quota. Each key is scored with its last access
+// time in the used sorted set so that the least recently used keys are evicted first.
+// KEYS: hash, used. ARGV: maxBytes, maxKeys, reject, now, ttl in ms, then key value pairs.
+var putScript = redis.NewScript(`
+local size, n = 0, 0
+for _, k in ipairs(redis.call('HKEYS', KEYS[1])) do
+	size = size + #k + redis.call('HSTRLEN', KEYS[1], k)
+	n = n + 1
+end
+local writing = {}
+for i = 6, #ARGV, 2 do
+	local k, v = ARGV[i], ARGV[i+1]
+	writing[k] = true
+	if redis.call('HEXISTS', KEYS[1], k) == 1 then
+		size = size - #k - redis.call('HSTRLEN', KEYS[1], k)
+	else
+		n = n + 1
+	end
+	size = size + #k + #v
+end
+local maxBytes, maxKeys = tonumber(ARGV[1]), tonumber(ARGV[2])
+local function exceeded()
+	return (maxBytes > 0 and size > maxBytes) or (maxKeys > 0 and n > maxKeys)
+end
+if exceeded() then
+	if ARGV[3] == '1' then
+		return redis.error_reply('store quota exceeded')
+	end
+	for _, k in ipairs(redis.call('ZRANGE', KEYS[2], 0, -1)) do
+		if not exceeded() then
+			break
+		end
+		if not writing[k] and redis.call('HEXISTS', KEYS[1], k) == 1 then
+			size = size - #k - redis.call('HSTRLEN', KEYS[1], k)
+			n = n - 1
+			redis.call('HDEL', KEYS[1], k)
+			redis.call('ZREM', KEYS[2], k)
+		end
+	end
+	if exceeded() then
+		return redis.error_reply('store quota exceeded')
+	end
+end
+for i = 6, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i+1])
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[i])
+end
+if tonumber(ARGV[5]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+	redis.call('PEXPIRE', KEYS[2], ARGV[5])
+end
+return 1
+`)
+
+func (s *store) Put(m controller.M) error {
+	if len(m) == 0 {
+		return nil
+	}
+	var maxBytes, maxKeys int
+	reject := "0"
+	if q := s.opts.Quota; q != nil {
+		maxBytes, maxKeys = q.MaxBytes, q.MaxKeys
+		if q.Policy == controller.RejectWrites {
+			reject = "1"
+		}
+	}
+	args := []interface{}{maxBytes, maxKeys, reject, time.Now().UnixMicro(), s.opts.TTL.Milliseconds()}
+	for k, v := range m {
+		data, err := json.Marshal(&v)
+		if err != nil {
+			return err
+		}
+		args = append(args, k, data)
+	}
+	err := putScript.Run(context.Background(), s.client, []string{s.key, s.used}, args...).Err()
+	if err != nil && strings.Contains(err.Error(), "store quota exceeded") {
+		return fmt.Errorf("put %d keys: %w", len(m), controller.ErrStoreQuotaExceeded)
+	}
+	return err
+}
+
+func (s *store) Get(key string, v interface{}) error {
+	ctx := context.Background()
+	data, err := s.client.HGet(ctx, s.key, key).Bytes()
+	if err == redis.Nil {
+		return fmt.Errorf("key not found")
+	}
+	if err != nil {
+		return err
+	}
+	if s.opts.Quota != nil {
+		s.client.ZAddXX(ctx, s.used, &redis.Z{Score: float64(time.Now().UnixMicro()), Member: key})
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Size implements controller.Sizer.
+func (s *store) Size() (int, int) {
+	entries, err := s.client.HGetAll(context.Background(), s.key).Result()
+	if err != nil {
+		return 0, 0
+	}
+	size := 0
+	for k, v := range entries {
+		size += len(k) + len(v)
+	}
+	return size, len(entries)
+}
+
+// NewUserIDs returns a generator for controller.WithUserIDs which allocates the user ids from a Redis counter
+// shared by the instances.
+func NewUserIDs(client redis.UniversalClient) func() (int, error) {
+	return func() (int, error) {
+		id, err := client.Incr(context.Background(), "glv:users").Result()
+		return int(id), err
+	}
+}
@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/goliveview/controller"
+)
+
+type tokenStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewTokenStore returns a controller.TokenStore which keeps the reconnect tokens in Redis so that the clients can
+// reconnect to any instance.
+func NewTokenStore(client redis.UniversalClient) controller.TokenStore {
+	return &tokenStore{client: client, prefix: "glv:token:"}
+}
+
+func (s *tokenStore) Put(token, binding string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), s.prefix+token, binding, ttl).Err()
+}
+
+// Take uses GETDEL so that a token can't be taken twice by concurrent reconnections.
+func (s *tokenStore) Take(token string) (string, bool, error) {
+	binding, err := s.client.GetDel(context.Background(), s.prefix+token).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return binding, true, nil
+}
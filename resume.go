@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrInvalidStateToken = errors.New("invalid state token")
+
+// EnableSessionResume lets a client which reconnects, e.g. after a network blip or a Reload, resume its session
+// rather than start over from the mount data. Each live connection receives a stateToken operation with a single
+// use token and, once the connection is closed, the keys of the user's Store written by the connection are saved
+// under the token for ttl. The client sends its last token in the resume query parameter of the live connection
+// url: the keys which weren't changed since, by another tab of the user for instance, are restored into the Store
+// and the OnReconnect hook of the view is called, see Reconnector. The snapshots are kept in the TokenStore, see
+// WithTokenStore. The sessions aren't saved with WithStoreFactory: the Store is shared by the instances and
+// outlives the connections already.
+func EnableSessionResume(ttl time.Duration) Option {
+	return func(o *controlOpt) {
+		o.resumeTTL = ttl
+	}
+}
+
+// savedState is the snapshot of the keys of a store written by a connection, saved under a state token.
+type savedState struct {
+	Binding string                     `json:"binding"`
+	Data    map[string]json.RawMessage `json:"data"`
+}
+
+// ownedKeys are the keys of the user's store written by a connection.
+type ownedKeys struct {
+	keys map[string]struct{}
+	sync.Mutex
+}
+
+func (o *ownedKeys) add(m M) {
+	if o == nil {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	for k := range m {
+		o.keys[k] = struct{}{}
+	}
+}
+
+func (o *ownedKeys) list() []string {
+	o.Lock()
+	defer o.Unlock()
+	keys := make([]string, 0, len(o.keys))
+	for k := range o.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ownedStore is the Store of the Context of a connection whose session can be resumed: it records the keys the
+// handlers write.
+type ownedStore struct {
+	Store
+	owned *ownedKeys
+}
+
+func (s ownedStore) Put(m M) error {
+	if err := s.Store.Put(m); err != nil {
+		return err
+	}
+	s.owned.add(m)
+	return nil
+}
+
+// resumable reports whether the sessions are saved for EnableSessionResume.
+func (wc *websocketController) resumable() bool {
+	return wc.resumeTTL > 0 && wc.storeFactory == nil
+}
+
+// issueStateToken sends to the connection of sessCtx the token its session can be resumed with once closed.
+func (v *viewHandler) issueStateToken(sessCtx *sessionContext) {
+	if !v.wc.resumable() {
+		return
+	}
+	token, err := newReconnectToken()
+	if err != nil {
+		v.wc.logger.Error("issuing state token", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "err", err)
+		return
+	}
+	sessCtx.stateToken = token
+	m := &Operation{Op: StateToken, Value: token}
+	v.wc.messageConn(sessCtx.conn, m.encode(v.wc.logger))
+}
+
+// saveState saves the keys written by the connection of sessCtx under its state token once it's closed.
+func (v *viewHandler) saveState(sessCtx *sessionContext) {
+	if sessCtx.stateToken == "" || sessCtx.dom.owned == nil {
+		return
+	}
+	data := make(map[string]json.RawMessage)
+	for _, k := range sessCtx.dom.owned.list() {
+		var value json.RawMessage
+		if err := sessCtx.dom.store.Get(k, &value); err == nil {
+			data[k] = value
+		}
+	}
+	if len(data) == 0 {
+		return
+	}
+	state, err := json.Marshal(savedState{Binding: tokenBinding(v.user, sessCtx.dom.topic), Data: data})
+	if err != nil {
+		v.wc.logger.Error("saving state", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+		return
+	}
+	if err := v.wc.tokenStore.Put(sessCtx.stateToken, string(state), v.wc.resumeTTL); err != nil {
+		v.wc.logger.Error("saving state", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+	}
+}
+
+// restoreState restores into the store of sessCtx the keys saved under token, the state token the client resumes
+// its session with. A key is only restored if it's missing or still holds the mount data the connection was opened
+// with: a value written since the client went away wins. It reports whether the session is resumed. A missing,
+// expired or already used token, or one saved for another user or topic, starts the session over.
+func (v *viewHandler) restoreState(sessCtx *sessionContext, token string) bool {
+	if !v.wc.resumable() || token == "" {
+		return false
+	}
+	data, ok, err := v.wc.tokenStore.Take(token)
+	if err != nil {
+		v.wc.logger.Error("restoring state", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+		return false
+	}
+	var state savedState
+	if !ok || json.Unmarshal([]byte(data), &state) != nil || state.Binding != tokenBinding(v.user, sessCtx.dom.topic) {
+		v.wc.logger.Warn("not resuming session", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user,
+			"err", ErrInvalidStateToken)
+		return false
+	}
+	m := make(M, len(state.Data))
+	for k, value := range state.Data {
+		if v.stale(sessCtx.dom.store, k) {
+			m[k] = value
+		}
+	}
+	if err := sessCtx.dom.store.Put(m); err != nil {
+		v.wc.logger.Error("restoring state", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "err", err)
+		return false
+	}
+	sessCtx.dom.owned.add(m)
+	return true
+}
+
+// stale reports whether the key of store is missing or holds the mount data of the view.
+func (v *viewHandler) stale(store Store, key string) bool {
+	var current json.RawMessage
+	if err := store.Get(key, &current); err != nil {
+		return true
+	}
+	mounted, ok := v.mountData[key]
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(&mounted)
+	return err == nil && bytes.Equal(current, data)
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// NavigateEventID is sent by the client to navigate to another route without reloading the page, e.g.
+// {"id":"glv:navigate","params":{"url":"/todos/2"}}. The client sets popstate to true when it navigates back or
+// forward in the history so that the url isn't pushed again.
+const NavigateEventID = "glv:navigate"
+
+// OutletID is the id of the element of the layout wrapping the content of the routed views. The content of the
+// view of a route navigated to is morphed into it.
+const OutletID = "glv-outlet"
+
+// route is a view registered with Route.
+type route struct {
+	pattern           string
+	segments          []string
+	view              View
+	handler           http.HandlerFunc
+	compiledView      *compiledView
+	compiledErrorView *compiledView
+}
+
+// match returns the path params of path if it matches the route.
+func (rt *route) match(path string) (map[string]string, bool) {
+	segments := splitPath(path)
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, s := range rt.segments {
+		if name, ok := pathParam(s); ok {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[name] = value
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type routes struct {
+	list []*route
+	sync.RWMutex
+}
+
+func (r *routes) add(rt *route) {
+	r.Lock()
+	defer r.Unlock()
+	r.list = append(r.list, rt)
+}
+
+// match returns the first route matching path and its path params.
+func (r *routes) match(path string) (*route, map[string]string) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, rt := range r.list {
+		if params, ok := rt.match(path); ok {
+			return rt, params
+		}
+	}
+	return nil, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func pathParam(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// Route registers view for the paths matching pattern, served by the Router handler. The segments of pattern in
+// braces, e.g. /todos/{id}, match any non-empty path segment and are available to the view with
+// Context.PathParams. The routes are matched in the order they are registered.
+//
+// The routes sharing a layout can be navigated without reloading the page: on a NavigateEventID the connection
+// switches to the view of the new route, which is mounted again and whose content is morphed into the OutletID
+// element of the layout. The client is redirected instead if the layout differs or one of the views has a
+// LiveEventReceiver.
+func (wc *websocketController) Route(pattern string, view View) {
+	segments := splitPath(pattern)
+	names := make(map[string]bool)
+	for _, s := range segments {
+		name, ok := pathParam(s)
+		if !ok {
+			if strings.ContainsAny(s, "{}") {
+				panic(fmt.Sprintf("route %s: invalid segment %s", pattern, s))
+			}
+			continue
+		}
+		if names[name] {
+			panic(fmt.Sprintf("route %s: duplicate path param %s", pattern, name))
+		}
+		names[name] = true
+	}
+	compiledView, compiledErrorView := wc.compiledTemplates(view)
+	wc.routes.add(&route{
+		pattern:           pattern,
+		segments:          segments,
+		view:              view,
+		handler:           wc.Handler(view),
+		compiledView:      compiledView,
+		compiledErrorView: compiledErrorView,
+	})
+}
+
+// Router returns the handler of the views registered with Route. It answers 404 if no route matches.
+func (wc *websocketController) Router() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rt, _ := wc.routes.match(r.URL.Path)
+		if rt == nil {
+			http.NotFound(w, r)
+			return
+		}
+		rt.handler(w, r)
+	}
+}
+
+func (s sessionContext) PathParams() map[string]string {
+	page := s.pageURL()
+	if page == nil {
+		return nil
+	}
+	_, params := s.dom.wc.routes.match(page.Path)
+	return params
+}
+
+// pageURL returns the url of the page of the session. The request of a live connection served by Socket or
+// Remote isn't the one of its page.
+func (s sessionContext) pageURL() *url.URL {
+	if s.page != nil {
+		return s.page
+	}
+	if s.r != nil {
+		return s.r.URL
+	}
+	return nil
+}
+
+// navigate switches the connection to the view of the route of the url of the NavigateEventID.
+func (v *viewHandler) navigate(sessCtx *sessionContext) error {
+	var params struct {
+		URL      string `json:"url"`
+		PopState bool   `json:"popstate"`
+	}
+	if err := sessCtx.event.DecodeParams(&params); err != nil {
+		return err
+	}
+	u, err := url.Parse(params.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return fmt.Errorf("navigate: %w: %s is not on the origin of the page", ErrInvalidURL, params.URL)
+	}
+	r := sessCtx.r.Clone(sessCtx.r.Context())
+	r.URL = sessCtx.pageURL().ResolveReference(u)
+	r.RequestURI = r.URL.RequestURI()
+
+	// the navigation concerns the connection of the event only, not the other connections of its topic
+	rt, _ := v.wc.routes.match(r.URL.Path)
+	if rt == nil || v.fragmentID != "" || rt.view.Layout() != v.view.Layout() ||
+		v.view.LiveEventReceiver() != nil || rt.view.LiveEventReceiver() != nil {
+		sessCtx.dom.Self().Redirect(r.URL.RequestURI())
+		return nil
+	}
+	if !v.authorizeNavigation(sessCtx, rt.view, r) {
+		return nil
+	}
+
+	v.view = rt.view
+	v.compiledView, v.compiledErrorView = rt.compiledView, rt.compiledErrorView
+	v.reloadTemplates()
+	sessCtx.r, sessCtx.page = r, r.URL
+	sessCtx.dom.rootTemplate = v.viewTemplate
+	if topic, _ := v.topic(r); topic != nil && *topic != sessCtx.dom.topic {
+		if err := v.wc.moveConnection(sessCtx.connID, sessCtx.dom.topic, *topic); err != nil {
+			return err
+		}
+		v.wc.leavePresence(sessCtx.connID)
+		v.wc.joinPresence(*topic, v.user, sessCtx.connID)
+		sessCtx.dom.topic = *topic
+		sessCtx.topicStore = v.wc.topicStores.getOrCreate(*topic)
+	}
+	if v.wc.debugLog {
+		v.wc.logger.Debug("navigated", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "path", r.URL.Path)
+	}
+
+	self := sessCtx.dom.Self()
+	status, data := v.view.OnMount(*sessCtx)
+	if status.Redirect != "" {
+		self.Redirect(status.Redirect)
+		return nil
+	}
+	if status.Code > 299 {
+		v.navigationError(sessCtx, status)
+	} else {
+		if data == nil {
+			data = make(M)
+		}
+		data["url_path"] = r.URL.Path
+		v.mountData = data
+		self.Morph("#"+OutletID, v.view.LayoutContentName(), data)
+	}
+	if !params.PopState {
+		self.PushState(r.URL.RequestURI(), false)
+	}
+	return nil
+}
+
+// navigationError morphs the content of the error view into the outlet of the page for the status of the OnMount
+// of the view navigated to, like onMountError renders it on mount.
+func (v *viewHandler) navigationError(sessCtx *sessionContext, status Status) {
+	_, data := v.errorView.OnMount(*sessCtx)
+	if data == nil {
+		data = make(M)
+	}
+	data["statusCode"] = status.Code
+	data["statusMessage"] = status.Message
+	sessCtx.Temporary("statusCode", "statusMessage")
+	self := sessCtx.dom.targeted(toSelf)
+	self.rootTemplate = v.errorViewTemplate
+	self.Morph("#"+OutletID, v.errorView.LayoutContentName(), data)
+}
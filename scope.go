@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"strings"
+)
+
+// ScopeKey is the key of the class scope of a fragment in its template data when EnableScopedClasses is set. It's
+// read by the scoped template func.
+const ScopeKey = "glv_scope"
+
+// EnableScopedClasses namespaces the class names of the fragments so that the styles and the selectors of two
+// instances of a view on the same page don't collide. The class names are written with the scoped template func,
+// e.g. class="{{scoped . "item active"}}" and in a style element .{{scoped . "item"}} { ... }, which prefixes
+// them with the fragment id. The class selectors and the class names passed to the DOM of the fragment, e.g.
+// Morph(".item", ...) or AddClass("li", "active"), are prefixed the same way, so the handlers keep using the
+// unscoped names.
+func EnableScopedClasses() Option {
+	return func(o *controlOpt) {
+		o.scopedClasses = true
+	}
+}
+
+// classScope returns the prefix of the class names of the fragment, or an empty string.
+func (v *viewHandler) classScope() string {
+	if !v.wc.scopedClasses || v.fragmentID == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range v.fragmentID {
+		if isClassChar(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func isClassChar(r rune) bool {
+	return r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r > 0x7f
+}
+
+// scopeClass prefixes the class name with scope.
+func scopeClass(scope, class string) string {
+	if scope == "" || class == "" {
+		return class
+	}
+	return scope + "__" + class
+}
+
+// scopeClasses prefixes the space separated class names with scope.
+func scopeClasses(scope, classes string) string {
+	if scope == "" {
+		return classes
+	}
+	fields := strings.Fields(classes)
+	for i, class := range fields {
+		fields[i] = scopeClass(scope, class)
+	}
+	return strings.Join(fields, " ")
+}
+
+// scopeSelector prefixes the class names of the class selectors of selector with scope. The attribute selectors
+// and the quoted strings are left as is.
+func scopeSelector(scope, selector string) string {
+	if scope == "" || !strings.Contains(selector, ".") {
+		return selector
+	}
+	var b strings.Builder
+	var quote rune
+	brackets := 0
+	prev := rune(0)
+	for _, r := range selector {
+		switch {
+		case quote != 0:
+			if r == quote && prev != '\\' {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			brackets++
+		case r == ']' && brackets > 0:
+			brackets--
+		case r == '.' && brackets == 0 && prev != '\\':
+			b.WriteRune(r)
+			b.WriteString(scope + "__")
+			prev = r
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// scoped is the template func prefixing the class names with the scope of the fragment in data.
+func scoped(data interface{}, classes string) string {
+	m, ok := data.(M)
+	if !ok {
+		return classes
+	}
+	scope, _ := m[ScopeKey].(string)
+	return scopeClasses(scope, classes)
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// NonceKey is the key of the CSP nonce in the mount data, and in the data of the morphs sent to the connection of the
+// event, when AllowScriptOps is set. The client sends the nonce of its page in the nonce query parameter of the live
+// connection url. Use it in the Content-Security-Policy header and on the page scripts:
+// <script nonce="{{.glv_nonce}}">
+const NonceKey = "glv_nonce"
+
+// AllowScriptOps enables DOM.Eval. Every use is logged for auditing.
+func AllowScriptOps() Option {
+	return func(o *controlOpt) {
+		o.allowScriptOps = true
+	}
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// validNonce reports whether nonce was generated by newNonce.
+func validNonce(nonce string) bool {
+	b, err := base64.StdEncoding.DecodeString(nonce)
+	return err == nil && len(b) == 16
+}
+
+// Eval executes the script on the client of the event's connection, whatever the DOM targets: the script is run
+// with the CSP nonce of its page, which the pages of the other connections don't accept. It's an escape hatch e.g.
+// to call a third party SDK and is only available when AllowScriptOps is set.
+func (d *dom) Eval(script string) {
+	if !d.wc.allowScriptOps {
+		d.wc.logger.Error("eval is disabled, enable it with AllowScriptOps", "topic", d.topic, "event", d.eventID)
+		return
+	}
+	if d.nonce == "" {
+		d.wc.logger.Warn("eval without a nonce", "topic", d.topic, "event", d.eventID)
+	}
+	d.wc.logger.Info("eval", "topic", d.topic, "event", d.eventID, "script", script)
+	m := &Operation{
+		Op: Eval,
+		Value: M{
+			"script": script,
+			"nonce":  d.nonce,
+		},
+	}
+	d.targeted(toSelf).send(m)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// WithStoreFactory creates the user stores with factory instead of keeping them in memory e.g. with
+// redis.NewStoreFactory so that the state survives restarts and is shared by several instances. The stores
+// created by factory enforce their own quota, WithStoreQuota only applies to the in-memory stores.
+// The users must keep their id across restarts and instances, see WithSessionKeys and WithUserIDs.
+func WithStoreFactory(factory func(user int) Store) Option {
+	return func(o *controlOpt) {
+		o.storeFactory = factory
+	}
+}
+
+// WithSessionKeys sets the hash and, optionally, the block keys of the cookie holding the user id.
+// By default the keys are generated at startup so the users get a new id after a restart.
+// The keys must be the same across the instances, see securecookie.New.
+func WithSessionKeys(keyPairs ...[]byte) Option {
+	return func(o *controlOpt) {
+		o.sessionKeys = keyPairs
+	}
+}
+
+// WithUserIDs generates the ids of the new users with next instead of an in-memory counter
+// e.g. with redis.NewUserIDs so that the ids are unique across the instances.
+func WithUserIDs(next func() (int, error)) Option {
+	return func(o *controlOpt) {
+		o.nextUserID = next
+	}
+}
+
+func newCookieStore(keyPairs [][]byte) *sessions.CookieStore {
+	if len(keyPairs) == 0 {
+		keyPairs = [][]byte{securecookie.GenerateRandomKey(32)}
+	}
+	return sessions.NewCookieStore(keyPairs...)
+}
+
+func (wc *websocketController) newUserID() (int, error) {
+	if wc.nextUserID != nil {
+		return wc.nextUserID()
+	}
+	return wc.userCount.incr(), nil
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// WithLoadShedding rejects new mounts with 503 and asks connected clients to retry their events after
+// retryAfter when more than maxGoroutines goroutines are running or more than maxInFlight events are being handled.
+// A zero limit disables the corresponding check.
+func WithLoadShedding(maxGoroutines, maxInFlight int, retryAfter time.Duration) Option {
+	return func(o *controlOpt) {
+		o.maxGoroutines = maxGoroutines
+		o.maxInFlight = int64(maxInFlight)
+		o.retryAfter = retryAfter
+	}
+}
+
+type loadCounter struct {
+	inFlight int64
+}
+
+func (l *loadCounter) begin() {
+	atomic.AddInt64(&l.inFlight, 1)
+}
+
+func (l *loadCounter) end() {
+	atomic.AddInt64(&l.inFlight, -1)
+}
+
+func (wc *websocketController) overloaded() bool {
+	if wc.maxInFlight > 0 && atomic.LoadInt64(&wc.load.inFlight) >= wc.maxInFlight {
+		return true
+	}
+	if wc.maxGoroutines > 0 && runtime.NumGoroutine() >= wc.maxGoroutines {
+		return true
+	}
+	return false
+}
+
+// retry asks the client on conn to send the event again after the backoff.
+func (wc *websocketController) retry(conn Conn, event Event) {
+	m := &Operation{
+		Op: Retry,
+		Value: M{
+			"event":   event,
+			"afterMs": wc.retryAfter.Milliseconds(),
+		},
+	}
+	wc.messageConn(conn, m.encode(wc.logger))
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+)
+
+var modifierOrder = map[string]int{"ctrl": 0, "alt": 1, "shift": 2, "meta": 3}
+
+var modifierAliases = map[string]string{
+	"control": "ctrl",
+	"option":  "alt",
+	"cmd":     "meta",
+	"command": "meta",
+	"super":   "meta",
+}
+
+// normalizeShortcut lower cases the shortcut and orders its modifiers e.g. "K+Shift+Ctrl" => "ctrl+shift+k"
+// so that a binding can be replaced or removed regardless of how it was spelled.
+func normalizeShortcut(shortcut string) string {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(shortcut, " ", "")), "+")
+	var mods []string
+	var key string
+	for _, p := range parts {
+		if alias, ok := modifierAliases[p]; ok {
+			p = alias
+		}
+		if _, ok := modifierOrder[p]; ok {
+			mods = append(mods, p)
+			continue
+		}
+		key = p
+	}
+	sort.Slice(mods, func(i, j int) bool {
+		return modifierOrder[mods[i]] < modifierOrder[mods[j]]
+	})
+	if key != "" {
+		mods = append(mods, key)
+	}
+	return strings.Join(mods, "+")
+}
+
+// BindKey registers a global key binding on the clients which sends the event eventID with params when the
+// shortcut e.g. "ctrl+k" or "shift+/" is pressed. Binding the same shortcut again replaces the event.
+func (d *dom) BindKey(shortcut, eventID string, params M) {
+	m := &Operation{
+		Op: BindKey,
+		Value: M{
+			"shortcut": normalizeShortcut(shortcut),
+			"event":    eventID,
+			"params":   params,
+		},
+	}
+	d.send(m)
+}
+
+// UnbindKey removes the key binding registered by BindKey for shortcut.
+func (d *dom) UnbindKey(shortcut string) {
+	m := &Operation{
+		Op: UnbindKey,
+		Value: M{
+			"shortcut": normalizeShortcut(shortcut),
+		},
+	}
+	d.send(m)
+}
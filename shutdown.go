@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithShutdownNotice sends a maintenance operation with message e.g. "server restarting" to the connections before
+// they are closed by Controller.Shutdown.
+func WithShutdownNotice(message string) Option {
+	return func(o *controlOpt) {
+		o.shutdownNotice = message
+	}
+}
+
+type shutdown struct {
+	on   int32
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *shutdown) begin() {
+	atomic.StoreInt32(&s.on, 1)
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *shutdown) isOn() bool {
+	return atomic.LoadInt32(&s.on) == 1
+}
+
+// rejectShutdown answers 503 if the controller is shutting down. It returns true if the request was rejected.
+func (wc *websocketController) rejectShutdown(w http.ResponseWriter) bool {
+	if !wc.shutdown.isOn() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	return true
+}
+
+// Shutdown stops the controller: the new mounts and connections are rejected, the connections are sent the
+// shutdown notice and a close frame, the template watcher is stopped and the events being handled are waited for
+// until ctx is done.
+func (wc *websocketController) Shutdown(ctx context.Context) error {
+	wc.shutdown.begin()
+	if wc.shutdownNotice != "" {
+		m := &Operation{
+			Op: Maintenance,
+			Value: M{
+				"enabled": true,
+				"message": wc.shutdownNotice,
+			},
+		}
+		wc.deliverAll(m.encode(wc.logger))
+	}
+	wc.Lock()
+	for _, cm := range wc.topicConnections {
+		for _, conn := range cm {
+			closeGoingAway(conn)
+		}
+	}
+	wc.Unlock()
+	wc.streams.closeAll()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&wc.load.inFlight) > 0 {
+		select {
+		case <-ctx.Done():
+			wc.logger.Warn("shutdown with events in flight", "events", atomic.LoadInt64(&wc.load.inFlight))
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// closeGoingAway sends a close frame to a websocket connection before closing it.
+func closeGoingAway(conn Conn) {
+	c := conn
+	if vc, ok := c.(viewConn); ok {
+		c = vc.Conn
+	}
+	if ws, ok := c.(wsConn); ok {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		if ws.queue != nil {
+			// written after the queued messages e.g. the shutdown notice
+			ws.queue.close(message)
+			return
+		}
+		// the close frame may have been sent for another view of a shared connection
+		_ = ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	}
+	conn.Close()
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"time"
+)
+
+// Slow describes an event handler or a template render which exceeded the threshold configured with WithSlowThreshold.
+type Slow struct {
+	// Kind is either "event", "mount" or "render".
+	Kind     string
+	EventID  string
+	Template string
+	Duration time.Duration
+}
+
+// WithSlowThreshold logs a warning and calls the hooks when an event handler or a template render takes longer than threshold.
+func WithSlowThreshold(threshold time.Duration, hooks ...func(s Slow)) Option {
+	return func(o *controlOpt) {
+		o.slowThreshold = threshold
+		o.slowHooks = hooks
+	}
+}
+
+func (wc *websocketController) checkSlow(kind, eventID, template string, start time.Time) {
+	if wc.slowThreshold <= 0 {
+		return
+	}
+	d := time.Since(start)
+	if d < wc.slowThreshold {
+		return
+	}
+	wc.logger.Warn("slow "+kind, "event", eventID, "template", template, "duration", d, "threshold", wc.slowThreshold)
+	for _, hook := range wc.slowHooks {
+		hook(Slow{Kind: kind, EventID: eventID, Template: template, Duration: d})
+	}
+}
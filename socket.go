@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithSocketPath serves the websocket connections of all the views at path, e.g. /live/ws, using the handler
+// returned by Controller.Socket instead of upgrading on the page routes. The url of the socket of a page is
+// available to its templates as socket_url.
+func WithSocketPath(path string) Option {
+	return func(o *controlOpt) {
+		o.socketPath = path
+	}
+}
+
+type newViewHandlerFunc func(w http.ResponseWriter, r *http.Request) *viewHandler
+
+// socketViews maps the view ids to the constructors of their handlers. A view's id is derived from its name
+// and registration order so that it's the same on every instance.
+type socketViews struct {
+	handlers map[string]newViewHandlerFunc
+	sync.Mutex
+}
+
+func (s *socketViews) add(name string, h newViewHandlerFunc) string {
+	s.Lock()
+	defer s.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string]newViewHandlerFunc)
+	}
+	key := name
+	for i := 2; ; i++ {
+		if _, ok := s.handlers[key]; !ok {
+			break
+		}
+		key = fmt.Sprintf("%s~%d", name, i)
+	}
+	s.handlers[key] = h
+	return key
+}
+
+func (s *socketViews) get(key string) (newViewHandlerFunc, bool) {
+	s.Lock()
+	defer s.Unlock()
+	h, ok := s.handlers[key]
+	return h, ok
+}
+
+// Socket returns the handler of the websocket connections to be mounted at the path set by WithSocketPath.
+// The view is selected by the view query parameter and the request url is replaced by the url of the page
+// in the page parameter, so the subscribe topic func sees the same request as on mount.
+//
+// Several views of a page, e.g. fragments, can share one connection by listing their ids, available to
+// their templates as view_id, in the views query parameter. The events must then carry the view id in
+// their view field and the operations sent to the connection are tagged with it.
+//
+// A single view can also be served over server-sent events, see IsEventStream.
+func (wc *websocketController) Socket() http.HandlerFunc {
+	return wc.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if wc.rejectShutdown(w) {
+			return
+		}
+		if isStreamEvent(r) {
+			wc.serveStreamEvent(w, r)
+			return
+		}
+		if !IsUpgrade(r) && !IsEventStream(r) {
+			http.Error(w, "websocket upgrade or event stream required", http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		page, err := url.ParseRequestURI(query.Get("page"))
+		if err != nil {
+			http.Error(w, "invalid page url", http.StatusBadRequest)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL = page
+		r2.RequestURI = page.RequestURI()
+
+		if views := query.Get("views"); views != "" {
+			wc.serveMultiplexed(w, r2, query.Get(CSRFTokenKey), strings.Split(views, ","))
+			return
+		}
+		newViewHandler, ok := wc.socketViews.get(query.Get("view"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		wc.serveLive(w, r2, query.Get(CSRFTokenKey), newViewHandler)
+	})
+}
+
+// serveLive serves the live connection of a view. csrfToken is the CSRFTokenKey query parameter of the connection.
+func (wc *websocketController) serveLive(w http.ResponseWriter, r *http.Request, csrfToken string, newViewHandler newViewHandlerFunc) {
+	if status, ok := wc.maintenanceStatus(); ok && wc.drainOnMaintenance {
+		http.Error(w, status.Message, status.Code)
+		return
+	}
+	if !wc.verifyOrigin(w, r) {
+		return
+	}
+	v := newViewHandler(w, r)
+	if v == nil {
+		return
+	}
+	if !wc.verifyLiveCSRF(w, csrfToken, v.sessionID) {
+		return
+	}
+	if !v.authorizeLive(w, r) {
+		return
+	}
+	if IsEventStream(r) {
+		onStream(w, r, v)
+		return
+	}
+	onLiveEvent(w, r, v)
+}
+
+func (wc *websocketController) serveMultiplexed(w http.ResponseWriter, r *http.Request, csrfToken string, viewIDs []string) {
+	if status, ok := wc.maintenanceStatus(); ok && wc.drainOnMaintenance {
+		http.Error(w, status.Message, status.Code)
+		return
+	}
+	if !wc.verifyOrigin(w, r) {
+		return
+	}
+	if IsEventStream(r) {
+		http.Error(w, "several views can only share a websocket", http.StatusBadRequest)
+		return
+	}
+	// the views of a page share the user, resolved once for the socket
+	userReq, err := wc.withUser(w, r)
+	if err != nil {
+		wc.reject(w, r, err)
+		return
+	}
+	r = userReq
+	handlers := make(map[string]*viewHandler)
+	// the reconnect and state tokens are listed in the order of the views
+	tokens := strings.Split(r.URL.Query().Get("reconnect"), ",")
+	stateTokens := strings.Split(r.URL.Query().Get("resume"), ",")
+	resume := make(map[string]string)
+	for i, id := range viewIDs {
+		newViewHandler, ok := wc.socketViews.get(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("view %s not found", id), http.StatusNotFound)
+			return
+		}
+		v := newViewHandler(w, r)
+		if v == nil {
+			return
+		}
+		topic := ""
+		if t, _ := v.topic(r); t != nil {
+			topic = *t
+		}
+		token := ""
+		if i < len(tokens) {
+			token = tokens[i]
+		}
+		if !wc.verifyReconnect(w, token, v.user, topic) {
+			return
+		}
+		// the views of a page share the session, and so the token
+		if !wc.verifyLiveCSRF(w, csrfToken, v.sessionID) {
+			return
+		}
+		if !v.authorizeLive(w, r) {
+			return
+		}
+		handlers[id] = v
+		if i < len(stateTokens) {
+			resume[id] = stateTokens[i]
+		}
+	}
+	onMultiplexedLiveEvents(w, r, wc, handlers, resume)
+}
+
+// onMultiplexedLiveEvents is like onLiveEvent for several views sharing a connection. resume holds the state
+// tokens the sessions of the views are resumed with.
+func onMultiplexedLiveEvents(w http.ResponseWriter, r *http.Request, wc *websocketController, handlers map[string]*viewHandler,
+	resume map[string]string) {
+	c, err := wc.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	wc.setReadLimit(c)
+	ctx, cancel := wc.connContext(r)
+	defer cancel()
+	defer wc.startHeartbeat(c, cancel)()
+
+	ws := wc.newLiveConn(c, cancel)
+	defer ws.Close()
+	wc.checkGeneration(r, ws)
+	sessions := make(map[string]*sessionContext)
+	done := make(chan struct{})
+	defer close(done)
+	for id, v := range handlers {
+		v := v
+		v.reloadTemplates()
+		connID := wc.newID()
+		conn := viewConn{Conn: ws, viewID: id}
+		// registered first to run once the connection is unsubscribed
+		var connected *sessionContext
+		defer func() {
+			if connected != nil {
+				v.disconnect(connected)
+			}
+		}()
+		topic := ""
+		if t, subscriptions := v.topic(r); t != nil {
+			topic = *t
+			wc.addConnection(topic, connID, conn)
+			for _, s := range subscriptions {
+				wc.addConnection(s, connID, conn)
+			}
+			defer wc.removeConnection(topic, connID)
+		}
+		defer func() {
+			if err := wc.locker.ReleaseAll(connID); err != nil {
+				wc.logger.Error("releasing locks", "topic", topic, "conn", connID, "err", err)
+			}
+		}()
+		sessions[id] = v.newSession(ctx, cancel, w, r, topic, connID, conn)
+		wc.rotateReconnectToken(conn, v.user, topic)
+		wc.liveConns.add(sessions[id], v)
+		defer wc.liveConns.remove(connID)
+		wc.joinPresence(topic, v.user, connID)
+		defer wc.leavePresence(connID)
+		defer sessions[id].uploads.clear()
+		defer v.saveState(sessions[id])
+		defer sessions[id].handling.Wait()
+		resumed := v.restoreState(sessions[id], resume[id])
+		v.issueStateToken(sessions[id])
+		if !v.connect(sessions[id]) {
+			return
+		}
+		connected = sessions[id]
+		if !v.reconnect(sessions[id], resumed) {
+			return
+		}
+		if v.view.LiveEventReceiver() != nil {
+			go v.receive(sessions[id].fork(), done)
+		}
+	}
+
+	for {
+		messageType, message, err := c.ReadMessage()
+		if err != nil {
+			wc.logger.Info("connection closed", "err", err)
+			return
+		}
+		wc.extendReadDeadline(c)
+		if messageType == websocket.BinaryMessage {
+			// the chunks don't carry the view id: the upload ref is looked up in the uploads of the views
+			if ref, chunk, ok := uploadChunk(message); ok {
+				for id, s := range sessions {
+					if s.uploads.has(ref) {
+						handlers[id].handleUploadChunk(s, ref, chunk)
+						break
+					}
+				}
+			}
+			continue
+		}
+		var e struct {
+			View string `json:"view"`
+		}
+		if err := json.Unmarshal(message, &e); err != nil {
+			wc.logger.Error("parsing event", "bytes", len(message))
+			continue
+		}
+		v, ok := handlers[e.View]
+		if !ok {
+			wc.logger.Error("event for unknown view", "view", e.View)
+			continue
+		}
+		v.handleMessage(sessions[e.View], message)
+	}
+}
+
+// viewConn tags the operations written to a multiplexed connection with the id of the view they are for.
+type viewConn struct {
+	Conn
+	viewID string
+}
+
+func (c viewConn) Send(message []byte) error {
+	if len(message) == 0 || message[0] != '{' {
+		return c.Conn.Send(message)
+	}
+	id, err := json.Marshal(c.viewID)
+	if err != nil {
+		return err
+	}
+	tagged := make([]byte, 0, len(message)+len(id)+9)
+	tagged = append(tagged, `{"view":`...)
+	tagged = append(tagged, id...)
+	if len(message) > 2 {
+		tagged = append(tagged, ',')
+	}
+	tagged = append(tagged, message[1:]...)
+	return c.Conn.Send(tagged)
+}
+
+// socketURL returns the url the page's client connects to.
+func (wc *websocketController) socketURL(r *http.Request, key string) string {
+	if wc.socketPath == "" {
+		return r.URL.RequestURI()
+	}
+	return fmt.Sprintf("%s?view=%s&page=%s", wc.socketPath, url.QueryEscape(key), url.QueryEscape(r.URL.RequestURI()))
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// EnableStatelessMode keeps the user state in an encrypted cookie instead of the server side Store so that the
+// controller can run on platforms without sticky in-memory state. The state must be small enough to fit in a cookie.
+// The keys must be the same across the instances, see securecookie.New.
+func EnableStatelessMode(hashKey, blockKey []byte) Option {
+	return func(o *controlOpt) {
+		o.stateCodec = securecookie.New(hashKey, blockKey)
+	}
+}
+
+// cookieState is a Store which mirrors its content into the state cookie on every write.
+type cookieState struct {
+	*inmemStore
+	wc   *websocketController
+	sink func(cookie *http.Cookie)
+}
+
+func (c *cookieState) Put(m M) error {
+	err := c.inmemStore.Put(m)
+	if err != nil {
+		return err
+	}
+	if c.sink == nil {
+		return nil
+	}
+	cookie, err := c.cookie()
+	if err != nil {
+		return err
+	}
+	c.sink(cookie)
+	return nil
+}
+
+func (c *cookieState) cookie() (*http.Cookie, error) {
+	c.RLock()
+	defer c.RUnlock()
+	value, err := c.wc.stateCodec.Encode(c.wc.stateCookieName(), c.data)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     c.wc.stateCookieName(),
+		Value:    value,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+func (wc *websocketController) stateCookieName() string {
+	return fmt.Sprintf("_glv_state_%s", strings.TrimSpace(wc.name))
+}
+
+// loadState returns a store with the state decoded from the request cookie.
+func (wc *websocketController) loadState(r *http.Request) *cookieState {
+	data := make(map[string][]byte)
+	if c, err := r.Cookie(wc.stateCookieName()); err == nil {
+		if err := wc.stateCodec.Decode(c.Name, c.Value, &data); err != nil {
+			wc.logger.Warn("invalid state cookie", "err", err)
+			data = make(map[string][]byte)
+		}
+	}
+	return &cookieState{
+		inmemStore: newInmemStore(data, wc.storeQuota),
+		wc:         wc,
+	}
+}
+
+func setCookieOperation(cookie *http.Cookie) *Operation {
+	return &Operation{
+		Op: SetCookie,
+		Value: M{
+			"name":   cookie.Name,
+			"value":  cookie.Value,
+			"path":   cookie.Path,
+			"maxAge": cookie.MaxAge,
+		},
+	}
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Sizer is implemented by the stores which can report their size for Stats.
+type Sizer interface {
+	// Size returns the total size of the keys and the encoded values, and the number of keys.
+	Size() (bytes int, keys int)
+}
+
+// SessionStats is the resource usage of a user's store.
+type SessionStats struct {
+	User  int `json:"user"`
+	Bytes int `json:"bytes"`
+	Keys  int `json:"keys"`
+}
+
+// Stats is a snapshot of the resource usage of the controller.
+type Stats struct {
+	Sessions   int `json:"sessions"`
+	StoreBytes int `json:"storeBytes"`
+	// LargestSessions are the top sessions by size in bytes.
+	LargestSessions  []SessionStats `json:"largestSessions"`
+	Connections      int            `json:"connections"`
+	TopicConnections map[string]int `json:"topicConnections"`
+	InFlightEvents   int64          `json:"inFlightEvents"`
+}
+
+func (s *inmemStore) Size() (int, int) {
+	s.RLock()
+	defer s.RUnlock()
+	size := 0
+	for k, v := range s.data {
+		size += len(k) + len(v)
+	}
+	return size, len(s.data)
+}
+
+// Stats returns the resource usage of the controller with the topN largest sessions.
+// Only the stores implementing Sizer are accounted for in the sizes.
+func (wc *websocketController) Stats(topN int) Stats {
+	stats := Stats{TopicConnections: make(map[string]int)}
+
+	wc.userSessions.RLock()
+	sessions := make([]SessionStats, 0, len(wc.userSessions.stores))
+	for user, store := range wc.userSessions.stores {
+		ss := SessionStats{User: user}
+		if sizer, ok := store.(Sizer); ok {
+			ss.Bytes, ss.Keys = sizer.Size()
+		}
+		stats.StoreBytes += ss.Bytes
+		sessions = append(sessions, ss)
+	}
+	wc.userSessions.RUnlock()
+	stats.Sessions = len(sessions)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Bytes > sessions[j].Bytes
+	})
+	if topN < len(sessions) {
+		sessions = sessions[:topN]
+	}
+	stats.LargestSessions = sessions
+
+	wc.RLock()
+	for topic, conns := range wc.topicConnections {
+		stats.TopicConnections[topic] = len(conns)
+		stats.Connections += len(conns)
+	}
+	wc.RUnlock()
+	stats.InFlightEvents = atomic.LoadInt64(&wc.load.inFlight)
+	return stats
+}
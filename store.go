@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"html/template"
+	"reflect"
 	"sync"
 )
 
@@ -12,27 +15,73 @@ type Store interface {
 }
 
 type inmemStore struct {
-	data map[string][]byte
+	data  map[string][]byte
+	quota *StoreQuota
+	size  int
+	// used is the logical time of the last access to each key for the LRU eviction
+	used  map[string]uint64
+	clock uint64
 	sync.RWMutex
 }
 
+func newInmemStore(data map[string][]byte, quota *StoreQuota) *inmemStore {
+	if data == nil {
+		data = make(map[string][]byte)
+	}
+	s := &inmemStore{data: data, quota: quota}
+	if quota != nil {
+		s.used = make(map[string]uint64)
+		for k, v := range data {
+			s.size += len(k) + len(v)
+		}
+	}
+	return s
+}
+
 func (s *inmemStore) Put(m M) error {
-	s.Lock()
-	defer s.Unlock()
+	entries := make(map[string][]byte, len(m))
 	for k, v := range m {
 		data, err := json.Marshal(&v)
 		if err != nil {
 			return err
 		}
+		entries[k] = data
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.quota != nil {
+		if err := s.makeRoom(entries); err != nil {
+			return err
+		}
+	}
+	for k, data := range entries {
+		if s.quota != nil {
+			if old, ok := s.data[k]; ok {
+				s.size -= len(k) + len(old)
+			}
+			s.size += len(k) + len(data)
+			s.touch(k)
+		}
 		s.data[k] = data
 	}
 	return nil
 }
 
 func (s *inmemStore) Get(key string, v interface{}) error {
-	s.RLock()
-	defer s.RUnlock()
-	data, ok := s.data[key]
+	var data []byte
+	var ok bool
+	if s.quota != nil {
+		s.Lock()
+		data, ok = s.data[key]
+		if ok {
+			s.touch(key)
+		}
+		s.Unlock()
+	} else {
+		s.RLock()
+		data, ok = s.data[key]
+		s.RUnlock()
+	}
 	if !ok {
 		return fmt.Errorf("key not found")
 	}
@@ -42,3 +91,122 @@ func (s *inmemStore) Get(key string, v interface{}) error {
 	}
 	return nil
 }
+
+func (s *inmemStore) touch(key string) {
+	s.clock++
+	s.used[key] = s.clock
+}
+
+// renderOnlyKeys are injected into the morph data for rendering and aren't persisted.
+var renderOnlyKeys = []string{timezoneKey, NonceKey}
+
+// changedKeys returns the entries of data whose value differs from the one in store. Render only data,
+// e.g. pre-rendered html, isn't persisted.
+func changedKeys(store Store, data M) M {
+	changed := make(M)
+	for k, v := range data {
+		if contains(renderOnlyKeys, k) || renderOnly(v) {
+			continue
+		}
+		next, err := json.Marshal(&v)
+		if err != nil {
+			// let the store report it
+			changed[k] = v
+			continue
+		}
+		var current json.RawMessage
+		if err := store.Get(k, &current); err == nil && bytes.Equal(current, next) {
+			continue
+		}
+		changed[k] = v
+	}
+	return changed
+}
+
+func renderOnly(v interface{}) bool {
+	switch v.(type) {
+	case template.HTML, template.JS, template.CSS:
+		return true
+	}
+	if v == nil {
+		return false
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// SharedStore is a Store shared by all the connections subscribed to a topic.
+type SharedStore interface {
+	Store
+	// Update runs f with exclusive access to the store so that read-modify-write sequences are atomic.
+	Update(f func(s Store) error) error
+}
+
+type topicStore struct {
+	store Store
+	doc   *Doc
+	sync.Mutex
+}
+
+func (t *topicStore) Put(m M) error {
+	t.Lock()
+	defer t.Unlock()
+	return t.store.Put(m)
+}
+
+func (t *topicStore) Get(key string, data interface{}) error {
+	t.Lock()
+	defer t.Unlock()
+	return t.store.Get(key, data)
+}
+
+func (t *topicStore) Update(f func(s Store) error) error {
+	t.Lock()
+	defer t.Unlock()
+	return f(t.store)
+}
+
+type topicStores struct {
+	stores map[string]*topicStore
+	sync.Mutex
+}
+
+func newTopicStore() *topicStore {
+	return &topicStore{
+		store: newInmemStore(nil, nil),
+		doc:   NewDoc("server"),
+	}
+}
+
+// getOrCreate returns the store of topic for a connection subscribed to it.
+func (t *topicStores) getOrCreate(topic string) *topicStore {
+	t.Lock()
+	defer t.Unlock()
+	s, ok := t.stores[topic]
+	if ok {
+		return s
+	}
+	s = newTopicStore()
+	t.stores[topic] = s
+	return s
+}
+
+// get returns the store of topic outside of a live connection, e.g. on mount. A topic without connections has no
+// store: the returned one isn't kept.
+func (t *topicStores) get(topic string) *topicStore {
+	t.Lock()
+	defer t.Unlock()
+	if s, ok := t.stores[topic]; ok {
+		return s
+	}
+	return newTopicStore()
+}
+
+func (t *topicStores) remove(topic string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.stores, topic)
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// StreamConnHeader is the header of the POST requests carrying the events of a server-sent events connection. Its
+// value is the connection id sent to the client in the open event of the stream.
+const StreamConnHeader = "X-Glv-Conn"
+
+// maxStreamEventSize is the maximum size of an event posted to a server-sent events connection.
+const maxStreamEventSize = 1 << 20
+
+var errStreamClosed = errors.New("stream is closed")
+
+// IsEventStream reports whether r opens a server-sent events stream. It's the fallback transport of the clients
+// which can't open a websocket e.g. behind a proxy blocking the upgrade: the operations are streamed as server-sent
+// events and the events are posted to the page url with the StreamConnHeader.
+func IsEventStream(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func isStreamEvent(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.Header.Get(StreamConnHeader) != ""
+}
+
+// sseConn writes the operations as server-sent events.
+type sseConn struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  chan struct{}
+	once    sync.Once
+	sync.Mutex
+}
+
+func (c *sseConn) write(event string, message []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-c.closed:
+		return errStreamClosed
+	default:
+	}
+	var buf bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	if _, err := c.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// comment writes a comment line which the client ignores e.g. to keep the stream open through proxies.
+func (c *sseConn) comment(text string) error {
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-c.closed:
+		return errStreamClosed
+	default:
+	}
+	if _, err := fmt.Fprintf(c.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+func (c *sseConn) Send(message []byte) error {
+	return c.write("", message)
+}
+
+func (c *sseConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// stream is a live server-sent events connection.
+type stream struct {
+	v       *viewHandler
+	sessCtx *sessionContext
+	// events are handled one at a time like on a websocket
+	sync.Mutex
+}
+
+type streams struct {
+	conns map[string]*stream
+	sync.Mutex
+}
+
+func (s *streams) add(connID string, st *stream) {
+	s.Lock()
+	defer s.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[string]*stream)
+	}
+	s.conns[connID] = st
+}
+
+func (s *streams) get(connID string) (*stream, bool) {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.conns[connID]
+	return st, ok
+}
+
+func (s *streams) closeAll() {
+	s.Lock()
+	defer s.Unlock()
+	for _, st := range s.conns {
+		st.sessCtx.conn.Close()
+	}
+}
+
+func (s *streams) remove(connID string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.conns, connID)
+}
+
+// onStream is onLiveEvent over server-sent events. The events are received by serveStreamEvent.
+func onStream(w http.ResponseWriter, r *http.Request, v *viewHandler) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	v.reloadTemplates()
+	topic, subscriptions := v.topic(r)
+	topicVal := ""
+	if topic != nil {
+		topicVal = *topic
+	}
+	if !v.wc.verifyReconnect(w, r.URL.Query().Get("reconnect"), v.user, topicVal) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	connID := v.wc.newID()
+	conn := &sseConn{w: w, flusher: flusher, closed: make(chan struct{})}
+	open, _ := json.Marshal(M{"connID": connID})
+	if err := conn.write("open", open); err != nil {
+		return
+	}
+	if topic != nil {
+		v.wc.addConnection(*topic, connID, conn)
+		for _, t := range subscriptions {
+			v.wc.addConnection(t, connID, conn)
+		}
+	}
+
+	ctx, cancel := v.wc.connContext(r)
+	defer cancel()
+	sessCtx := v.newSession(ctx, cancel, w, r, topicVal, connID, conn)
+	v.wc.liveConns.add(sessCtx, v)
+	defer v.wc.liveConns.remove(connID)
+	v.wc.joinPresence(topicVal, v.user, connID)
+	defer v.wc.leavePresence(connID)
+	v.wc.streams.add(connID, &stream{v: v, sessCtx: sessCtx})
+	defer v.wc.streams.remove(connID)
+	v.wc.checkGeneration(r, conn)
+	v.wc.rotateReconnectToken(conn, v.user, topicVal)
+	resumed := v.restoreState(sessCtx, r.URL.Query().Get("resume"))
+	v.issueStateToken(sessCtx)
+	connected := v.connect(sessCtx)
+	if !connected || !v.reconnect(sessCtx, resumed) {
+		conn.Close()
+	}
+	done := make(chan struct{})
+	if v.view.LiveEventReceiver() != nil {
+		go v.receive(sessCtx.fork(), done)
+	}
+
+	stopKeepAlive := make(chan struct{})
+	go v.wc.keepAlive(conn, stopKeepAlive)
+	select {
+	case <-r.Context().Done():
+	case <-conn.closed:
+	}
+	close(stopKeepAlive)
+	conn.Close()
+	cancel()
+	sessCtx.handling.Wait()
+	v.saveState(sessCtx)
+	if v.view.LiveEventReceiver() != nil {
+		done <- struct{}{}
+	}
+	if err := v.wc.locker.ReleaseAll(connID); err != nil {
+		v.wc.logger.Error("releasing locks", "topic", topicVal, "conn", connID, "err", err)
+	}
+	if topic != nil {
+		v.wc.removeConnection(*topic, connID)
+	}
+	if connected {
+		v.disconnect(sessCtx)
+	}
+}
+
+// serveStreamEvent handles an event posted to a server-sent events connection.
+func (wc *websocketController) serveStreamEvent(w http.ResponseWriter, r *http.Request) {
+	connID := r.Header.Get(StreamConnHeader)
+	st, ok := wc.streams.get(connID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("connection %s not found", connID), http.StatusNotFound)
+		return
+	}
+	// the connection id alone doesn't authorize posting events on behalf of its user
+	if user, _ := wc.identity(r); user != st.v.user {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	limit := int64(maxStreamEventSize)
+	if wc.maxMessageSize > 0 {
+		limit = wc.maxMessageSize
+	}
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	st.Lock()
+	st.v.handleMessage(st.sessCtx, message)
+	st.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WithSubscriber subscribes each connection to the topics returned by f. The DOM operations of the connection are
+// broadcast to the first topic, the other ones only add the broadcasts of their topic e.g. a "news" topic shared
+// by the views. It replaces WithSubscribeTopic and WithTopicStrategy.
+func WithSubscriber(f func(r *http.Request) []string) Option {
+	return func(o *controlOpt) {
+		o.subscriberFunc = f
+	}
+}
+
+// unsubscribe removes the connection from topic without closing it.
+func (wc *websocketController) unsubscribe(topic, connID string) error {
+	wc.Lock()
+	cm, ok := wc.topicConnections[topic]
+	if !ok {
+		wc.Unlock()
+		return fmt.Errorf("connection %s not subscribed to topic %s", connID, topic)
+	}
+	if _, ok := cm[connID]; !ok {
+		wc.Unlock()
+		return fmt.Errorf("connection %s not subscribed to topic %s", connID, topic)
+	}
+	delete(cm, connID)
+	if len(cm) == 0 {
+		delete(wc.topicConnections, topic)
+	}
+	wc.Unlock()
+	wc.syncSubscription(topic)
+	return nil
+}
+
+// Subscribe adds topic to the subscriptions of the connection. The DOM calls are still broadcast to the
+// connection's own topic.
+func (s sessionContext) Subscribe(topic string) error {
+	if s.connID == "" {
+		return fmt.Errorf("subscribe %s: no live connection", topic)
+	}
+	if topic == s.dom.topic {
+		return nil
+	}
+	s.dom.wc.addConnection(topic, s.connID, s.conn)
+	return nil
+}
+
+// Unsubscribe removes topic from the subscriptions of the connection. The connection's own topic can't be
+// removed, use Resubscribe to change it.
+func (s sessionContext) Unsubscribe(topic string) error {
+	if s.connID == "" {
+		return fmt.Errorf("unsubscribe %s: no live connection", topic)
+	}
+	if topic == s.dom.topic {
+		return fmt.Errorf("unsubscribe %s: it's the topic of the connection", topic)
+	}
+	return s.dom.wc.unsubscribe(topic, s.connID)
+}
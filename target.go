@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"bytes"
+)
+
+// target selects the connections the operations of a DOM are sent to.
+type target int
+
+const (
+	toTopic target = iota
+	toSelf
+	toOthers
+)
+
+// exceptPrefix frames a broker message which isn't for the connection id following it, up to a newline.
+var exceptPrefix = []byte("\x00except:")
+
+// Self returns a DOM whose operations are only sent to the connection of the event e.g. validation errors or
+// personal data. The operations are dropped outside of a live connection.
+func (d *dom) Self() DOM {
+	return d.targeted(toSelf)
+}
+
+// Others returns a DOM whose operations are sent to the other connections of the topic, on all the instances.
+func (d *dom) Others() DOM {
+	return d.targeted(toOthers)
+}
+
+// Broadcast returns a DOM whose operations are sent to all the connections of the topic. It's the default.
+func (d *dom) Broadcast() DOM {
+	return d.targeted(toTopic)
+}
+
+func (d *dom) targeted(t target) *dom {
+	if d.target == t {
+		return d
+	}
+	if origin := d.event(); origin.target == t {
+		return origin
+	}
+	return &dom{
+		rootTemplate:   d.rootTemplate,
+		store:          d.store,
+		temporaryKeys:  d.temporaryKeys,
+		persistentKeys: d.persistentKeys,
+		topic:          d.topic,
+		wc:             d.wc,
+		selectorPrefix: d.selectorPrefix,
+		classScope:     d.classScope,
+		receivedAt:     d.receivedAt,
+		eventID:        d.eventID,
+		connID:         d.connID,
+		conn:           d.conn,
+		target:         t,
+		ctx:            d.ctx,
+		owned:          d.owned,
+		nonce:          d.nonce,
+		variants:       d.variants,
+		flags:          d.flags,
+		origin:         d.event(),
+	}
+}
+
+// event returns the dom of the event d is derived from, which holds its busy state and batch.
+func (d *dom) event() *dom {
+	if d.origin != nil {
+		return d.origin
+	}
+	return d
+}
+
+// sendTargeted sends the operation of a Self or Others DOM, after the operations batched or coalesced for the
+// topic so far.
+func (d *dom) sendTargeted(m *Operation) {
+	d.event().Flush()
+	if d.wc.coalescer != nil {
+		d.wc.coalescer.flush(d.topic)
+	}
+	if d.target == toOthers {
+		var buf bytes.Buffer
+		buf.Write(exceptPrefix)
+		buf.WriteString(d.connID)
+		buf.WriteByte('\n')
+		buf.Write(m.encode(d.wc.logger))
+		d.wc.publish(d.topic, buf.Bytes())
+		return
+	}
+	if d.conn == nil {
+		d.wc.logger.Warn("no live connection, dropping operation", "topic", d.topic, "event", d.eventID, "op", m.Op)
+		d.wc.metrics.MessageDropped(d.topic, DropNoConnection)
+		return
+	}
+	d.wc.messageConn(d.conn, m.encode(d.wc.logger))
+}
+
+// splitExcept returns the connection a broker message isn't for, if any, and the message.
+func splitExcept(message []byte) (string, []byte) {
+	if !bytes.HasPrefix(message, exceptPrefix) {
+		return "", message
+	}
+	rest := message[len(exceptPrefix):]
+	i := bytes.IndexByte(rest, '\n')
+	if i < 0 {
+		return "", message
+	}
+	return string(rest[:i]), rest[i+1:]
+}
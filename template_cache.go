@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// compiledView caches the compiled template of a view along with the files it was compiled from,
+// so that it is recompiled only when one of them changes.
+type compiledView struct {
+	tfs   templateFS
+	view  View
+	funcs template.FuncMap
+	tpl   *template.Template
+	deps  map[string]time.Time
+	dirty bool
+	sync.Mutex
+}
+
+func newCompiledView(tfs templateFS, view View, funcs template.FuncMap) (*compiledView, error) {
+	c := &compiledView{tfs: tfs, view: view, funcs: funcs}
+	if err := c.compile(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *compiledView) compile() error {
+	t, err := parseTemplate(c.tfs, c.view, c.funcs)
+	if err != nil {
+		return err
+	}
+	deps := make(map[string]time.Time)
+	for _, f := range templateFiles(c.tfs, c.view) {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		deps[f] = fi.ModTime()
+	}
+	c.tpl = t
+	c.deps = deps
+	c.dirty = false
+	return nil
+}
+
+// stale reports whether a file of the view was modified, added or removed since it was compiled.
+// Embedded templates never change.
+func (c *compiledView) stale() bool {
+	if c.tfs.embedded() {
+		return false
+	}
+	files := templateFiles(c.tfs, c.view)
+	if len(files) != len(c.deps) {
+		return true
+	}
+	for _, f := range files {
+		modTime, ok := c.deps[f]
+		if !ok {
+			return true
+		}
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// template returns the compiled template. It is recompiled if it was invalidated or,
+// when check is true, if one of its files changed.
+func (c *compiledView) template(check bool) (*template.Template, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.dirty || (check && c.stale()) {
+		if err := c.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return c.tpl, nil
+}
+
+// invalidate marks the view for recompilation if it depends on path. Created or removed files may
+// be picked up by a directory of the view, so any change invalidates the view. It reports whether the view
+// was invalidated.
+func (c *compiledView) invalidate(path string, created bool) bool {
+	c.Lock()
+	defer c.Unlock()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if _, ok := c.deps[abs]; ok || created {
+		c.dirty = true
+		return true
+	}
+	return false
+}
+
+// layoutFile reports whether path is a file of the layout of the view.
+func (c *compiledView) layoutFile(path string) bool {
+	if c.tfs.embedded() || c.view.Layout() == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, f := range c.tfs.find(c.tfs.join(c.view.Layout()), c.view.Extensions()) {
+		if fabs, err := filepath.Abs(f); err == nil && fabs == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFiles returns the absolute paths of the layout, content and partial files of the view.
+func templateFiles(tfs templateFS, view View) []string {
+	if tfs.embedded() {
+		return nil
+	}
+	var files []string
+	if view.Layout() != "" {
+		files = append(files, tfs.find(tfs.join(view.Layout()), view.Extensions())...)
+	}
+	if view.Content() != "" {
+		files = append(files, tfs.find(tfs.join(view.Content()), view.Extensions())...)
+	}
+	for _, p := range view.Partials() {
+		files = append(files, tfs.find(tfs.join(p), view.Extensions())...)
+	}
+	for i, f := range files {
+		if abs, err := filepath.Abs(f); err == nil {
+			files[i] = abs
+		}
+	}
+	return files
+}
+
+type compiledViews struct {
+	views  []*compiledView
+	byView map[string]*compiledView
+	sync.Mutex
+}
+
+// viewKey identifies the templates of a view. Views aren't necessarily comparable so they can't be map keys.
+func viewKey(view View) string {
+	return fmt.Sprintf("%T|%s|%s|%s|%s|%q|%q", view, viewName(view), view.Layout(), view.Content(),
+		view.LayoutContentName(), view.Partials(), view.Extensions())
+}
+
+// getOrCompile returns the compiled templates of view, compiling them the first time.
+func (c *compiledViews) getOrCompile(tfs templateFS, view View, funcs template.FuncMap) (*compiledView, error) {
+	key := viewKey(view)
+	c.Lock()
+	defer c.Unlock()
+	if cv, ok := c.byView[key]; ok {
+		return cv, nil
+	}
+	cv, err := newCompiledView(tfs, view, funcs)
+	if err != nil {
+		return nil, err
+	}
+	if c.byView == nil {
+		c.byView = make(map[string]*compiledView)
+	}
+	c.byView[key] = cv
+	c.views = append(c.views, cv)
+	return cv, nil
+}
+
+// rewarm recompiles the invalidated views so that the next request doesn't wait for the compilation.
+func (c *compiledViews) rewarm(logger Logger) {
+	c.Lock()
+	views := append([]*compiledView(nil), c.views...)
+	c.Unlock()
+	for _, v := range views {
+		if _, err := v.template(false); err != nil {
+			logger.Error("recompiling templates", "view", viewName(v.view), "err", err)
+		}
+	}
+}
+
+// Preload compiles and caches the templates of views and of the error view so that the first requests don't pay
+// for the compilation. Handler, Fragment and Remote reuse the preloaded templates.
+func (wc *websocketController) Preload(views ...View) error {
+	if _, err := wc.compiledViews.getOrCompile(wc.templates(wc.errorView), wc.errorView, wc.funcs); err != nil {
+		return fmt.Errorf("preloading error view: %w", err)
+	}
+	for _, view := range views {
+		if _, err := wc.compiledViews.getOrCompile(wc.templates(view), view, wc.funcs); err != nil {
+			return fmt.Errorf("preloading %s: %w", viewName(view), err)
+		}
+	}
+	return nil
+}
+
+// compiledTemplates returns the compiled templates of view and of the error view.
+func (wc *websocketController) compiledTemplates(view View) (*compiledView, *compiledView) {
+	viewTemplate, err := wc.compiledViews.getOrCompile(wc.templates(view), view, wc.funcs)
+	if err != nil {
+		panic(err)
+	}
+	errorViewTemplate, err := wc.compiledViews.getOrCompile(wc.templates(wc.errorView), wc.errorView, wc.funcs)
+	if err != nil {
+		panic(err)
+	}
+	return viewTemplate, errorViewTemplate
+}
+
+// invalidate marks the views which depend on path for recompilation and returns them.
+func (c *compiledViews) invalidate(path string, created bool) []*compiledView {
+	c.Lock()
+	defer c.Unlock()
+	var invalidated []*compiledView
+	for _, v := range c.views {
+		if v.invalidate(path, created) {
+			invalidated = append(invalidated, v)
+		}
+	}
+	return invalidated
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ViewFS is implemented by the views whose layout, content and partials are loaded from a filesystem
+// e.g. an embed.FS instead of the project root directory. It takes precedence over WithTemplateFS.
+type ViewFS interface {
+	FS() fs.FS
+}
+
+// WithTemplateFS loads the layout, content and partials of the views from fsys e.g. an embed.FS so that the
+// templates can be shipped inside the binary. The paths of the views are relative to the root of fsys.
+// Templates loaded from a filesystem aren't watched nor checked for changes.
+func WithTemplateFS(fsys fs.FS) Option {
+	return func(o *controlOpt) {
+		o.templateFS = fsys
+	}
+}
+
+// templateFS resolves the template paths of a view either on disk, relative to the project root, or in fsys.
+type templateFS struct {
+	fsys fs.FS
+	root string
+}
+
+func osTemplates(projectRoot string) templateFS {
+	return templateFS{root: projectRoot}
+}
+
+// templates returns where the templates of view are loaded from.
+func (wc *websocketController) templates(view View) templateFS {
+	if f, ok := view.(fragmentView); ok {
+		view = f.View
+	}
+	if v, ok := view.(ViewFS); ok && v.FS() != nil {
+		return templateFS{fsys: v.FS(), root: "."}
+	}
+	if wc.templateFS != nil {
+		return templateFS{fsys: wc.templateFS, root: "."}
+	}
+	return osTemplates(wc.projectRoot)
+}
+
+// embedded reports whether the templates are loaded from a filesystem which isn't watched.
+func (t templateFS) embedded() bool {
+	return t.fsys != nil
+}
+
+func (t templateFS) join(p string) string {
+	if t.fsys == nil {
+		return filepath.Join(t.root, p)
+	}
+	return path.Join(t.root, filepath.ToSlash(p))
+}
+
+func (t templateFS) stat(p string) (fs.FileInfo, error) {
+	if t.fsys == nil {
+		return os.Stat(p)
+	}
+	return fs.Stat(t.fsys, p)
+}
+
+// missing reports whether p isn't a file or directory, i.e. it's inline html.
+func (t templateFS) missing(p string) bool {
+	_, err := t.stat(p)
+	// an html string isn't a valid fs path
+	return errors.Is(err, fs.ErrNotExist) || (t.fsys != nil && errors.Is(err, fs.ErrInvalid))
+}
+
+func (t templateFS) readFile(p string) ([]byte, error) {
+	if t.fsys == nil {
+		return os.ReadFile(p)
+	}
+	return fs.ReadFile(t.fsys, p)
+}
+
+func (t templateFS) isDirectory(p string) (bool, error) {
+	fileInfo, err := t.stat(p)
+	if err != nil {
+		return false, err
+	}
+	return fileInfo.IsDir(), err
+}
+
+// find returns the files with one of the extensions in p, or p itself if it's such a file.
+func (t templateFS) find(p string, extensions []string) []string {
+	var files []string
+
+	fi, err := t.stat(p)
+	if err != nil {
+		return files
+	}
+	if !fi.IsDir() {
+		if !contains(extensions, filepath.Ext(p)) {
+			return files
+		}
+		files = append(files, p)
+		return files
+	}
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if contains(extensions, filepath.Ext(d.Name())) {
+			files = append(files, path)
+		}
+		return nil
+	}
+	if t.fsys == nil {
+		err = filepath.WalkDir(p, walk)
+	} else {
+		err = fs.WalkDir(t.fsys, p, walk)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return files
+}
+
+// parseFiles is template.ParseFiles reading the files from t.
+func (t templateFS) parseFiles(tpl *template.Template, files ...string) (*template.Template, error) {
+	if t.fsys == nil {
+		return tpl.ParseFiles(files...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("html/template: no files named in call to ParseFiles")
+	}
+	for _, file := range files {
+		b, err := t.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(file)
+		tmpl := tpl
+		if name != tpl.Name() {
+			tmpl = tpl.New(name)
+		}
+		if _, err := tmpl.Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return tpl, nil
+}
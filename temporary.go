@@ -0,0 +1,47 @@
+package controller
+
+// DefaultTemporaryKeys are the data keys which aren't saved to the Store after a DOM call unless
+// Context.Persistent is used.
+var DefaultTemporaryKeys = []string{"selector", "template"}
+
+// WithTemporaryKeys replaces DefaultTemporaryKeys.
+func WithTemporaryKeys(keys ...string) Option {
+	return func(o *controlOpt) {
+		o.temporaryKeys = keys
+	}
+}
+
+// Temporary marks keys as not to be saved to the Store for the rest of the current event.
+func (s sessionContext) Temporary(keys ...string) {
+	s.dom.temporaryKeys = append(s.dom.temporaryKeys, keys...)
+}
+
+// Persistent saves keys to the Store for the rest of the current event even if they are temporary by default.
+func (s sessionContext) Persistent(keys ...string) {
+	s.dom.persistentKeys = append(s.dom.persistentKeys, keys...)
+}
+
+// resetKeys scopes Temporary and Persistent to a single event.
+func (d *dom) resetKeys() {
+	d.temporaryKeys = nil
+	d.persistentKeys = nil
+}
+
+func (d *dom) isTemporary(key string) bool {
+	for _, k := range d.persistentKeys {
+		if k == key {
+			return false
+		}
+	}
+	for _, k := range d.temporaryKeys {
+		if k == key {
+			return true
+		}
+	}
+	for _, k := range d.wc.temporaryKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
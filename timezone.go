@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// TimezoneEventID is sent by the client with its IANA timezone from Intl.DateTimeFormat().resolvedOptions().timeZone
+// e.g. {"id":"glv:timezone","params":{"timezone":"Europe/Berlin"}}. It is handled by the controller and persisted in the Store.
+const TimezoneEventID = "glv:timezone"
+
+const timezoneKey = "timezone"
+
+func setTimezone(store Store, event Event) error {
+	var params struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := event.DecodeParams(&params); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(params.Timezone); err != nil {
+		return err
+	}
+	return store.Put(M{timezoneKey: params.Timezone})
+}
+
+func storedTimezone(store Store) string {
+	var tz string
+	if err := store.Get(timezoneKey, &tz); err != nil {
+		return ""
+	}
+	return tz
+}
+
+func toTime(t interface{}) (time.Time, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case int64:
+		return time.Unix(v, 0), nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported time value %T", t)
+}
+
+func dataLocation(data interface{}) *time.Location {
+	m, ok := data.(M)
+	if !ok {
+		return time.UTC
+	}
+	tz, ok := m[timezoneKey].(string)
+	if !ok {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// localtime is the template func which formats t in the timezone of the session: {{ localtime . .CreatedAt "Jan 2 15:04" }}
+func localtime(data interface{}, t interface{}, layout ...string) (string, error) {
+	tt, err := toTime(t)
+	if err != nil {
+		return "", err
+	}
+	l := "2006-01-02 15:04"
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	return tt.In(dataLocation(data)).Format(l), nil
+}
+
+// reltime is the template func which formats t relative to now e.g. "5 minutes ago", falling back to
+// the date in the timezone of the session for times more than a week away: {{ reltime . .CreatedAt }}
+func reltime(data interface{}, t interface{}) (string, error) {
+	tt, err := toTime(t)
+	if err != nil {
+		return "", err
+	}
+	d := time.Since(tt)
+	suffix := "ago"
+	if d < 0 {
+		d = -d
+		suffix = "from now"
+	}
+	unit := func(n float64, name string) string {
+		v := int(math.Floor(n))
+		if v != 1 {
+			name += "s"
+		}
+		return fmt.Sprintf("%d %s %s", v, name, suffix)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now", nil
+	case d < time.Hour:
+		return unit(d.Minutes(), "minute"), nil
+	case d < 24*time.Hour:
+		return unit(d.Hours(), "hour"), nil
+	case d < 7*24*time.Hour:
+		return unit(d.Hours()/24, "day"), nil
+	}
+	return tt.In(dataLocation(data)).Format("Jan 2, 2006"), nil
+}
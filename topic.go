@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TopicStrategy returns the topic a connection subscribes to. The connections of the same topic receive the
+// operations of each other's events. A nil topic doesn't subscribe the connection to any topic.
+type TopicStrategy func(r *http.Request, user int, sessionID string) *string
+
+// TopicPerPath subscribes the connections of the same url path to the same topic. It's the default.
+func TopicPerPath(r *http.Request, _ int, _ string) *string {
+	topic := "root"
+	if r.URL.Path != "/" {
+		topic = strings.Replace(r.URL.Path, "/", "_", -1)
+	}
+	return &topic
+}
+
+// TopicPerUser subscribes all the connections of a user, e.g. several tabs, to the same topic.
+func TopicPerUser(_ *http.Request, user int, _ string) *string {
+	topic := fmt.Sprintf("user_%d", user)
+	return &topic
+}
+
+// TopicPerSession subscribes the connections of a browser session to the same topic.
+func TopicPerSession(_ *http.Request, _ int, sessionID string) *string {
+	topic := "session_" + sessionID
+	return &topic
+}
+
+// TopicPerQueryParam subscribes the connections with the same value of the query parameter param e.g. a room id
+// to the same topic. The connections without the parameter aren't subscribed to a topic.
+func TopicPerQueryParam(param string) TopicStrategy {
+	return func(r *http.Request, _ int, _ string) *string {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			return nil
+		}
+		topic := fmt.Sprintf("%s_%s", param, value)
+		return &topic
+	}
+}
+
+// WithTopicStrategy selects how the connections are grouped into topics e.g. WithTopicStrategy(TopicPerUser).
+// It replaces WithSubscribeTopic.
+func WithTopicStrategy(strategy TopicStrategy) Option {
+	return func(o *controlOpt) {
+		o.topicStrategy = strategy
+		o.subscribeTopicFunc = nil
+		o.subscriberFunc = nil
+	}
+}
+
+// subscribeTopics returns the topics of the connection opened with r by the user. The first one is the topic the
+// DOM operations of the connection are broadcast to.
+func (wc *websocketController) subscribeTopics(r *http.Request, user int, sessionID string) []string {
+	var topics []string
+	if wc.subscriberFunc != nil {
+		topics = wc.subscriberFunc(r)
+	} else if topic := wc.subscribeTopic(r, user, sessionID); topic != nil {
+		topics = []string{*topic}
+	}
+	if wc.debugLog {
+		wc.logger.Debug("client subscribed to topics", "user", user, "topics", topics)
+	}
+	return topics
+}
+
+// subscribeTopic returns the topic of the connection opened with r by the user.
+func (wc *websocketController) subscribeTopic(r *http.Request, user int, sessionID string) *string {
+	if wc.topicStrategy != nil {
+		return wc.topicStrategy(r, user, sessionID)
+	}
+	if wc.subscribeTopicFunc != nil {
+		return wc.subscribeTopicFunc(r)
+	}
+	return nil
+}
+
+// identity returns the user and session id stored in the cookie of r without creating them.
+func (wc *websocketController) identity(r *http.Request) (int, string) {
+	cookieSession, _ := wc.cookieStore.Get(r, fmt.Sprintf("_glv_key_%s", strings.TrimSpace(wc.name)))
+	user, _ := cookieSession.Values["user"].(int)
+	if authUser, authenticated, _ := wc.authenticate(r); authenticated {
+		user = authUser
+	}
+	sessionID, _ := cookieSession.Values["session"].(string)
+	return user, sessionID
+}
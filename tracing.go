@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"context"
+)
+
+// Tracer starts the spans of the mounts, the events and the template renders, e.g. the Tracer of the otel package.
+// The spans are named glv.mount, glv.event and glv.render, with view, event, topic, user, conn and template
+// attributes. The span of a mount or an event is carried by ctx.Context() so that the handlers continue the trace
+// into their calls, e.g. to a database.
+type Tracer interface {
+	// Start starts the span name as a child of the span of ctx, if any, with the attributes in key-value pairs.
+	// It returns the context carrying the span and the func ending it with the error of the operation.
+	Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, func(err error))
+}
+
+// WithTracer traces the mounts, the events and the template renders with tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(o *controlOpt) {
+		o.tracer = tracer
+	}
+}
+
+// noTracer is the default Tracer, starting no span.
+type noTracer struct{}
+
+func (noTracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+// traceEvent starts the span of the event of sessCtx, carried by its Context until the returned func ends it.
+func (v *viewHandler) traceEvent(sessCtx *sessionContext) func(err error) {
+	ctx, end := v.wc.tracer.Start(sessCtx.baseContext(), "glv.event", "view", viewName(v.view), "event", sessCtx.event.ID,
+		"topic", sessCtx.dom.topic, "user", v.user, "conn", sessCtx.connID)
+	sessCtx.ctx, sessCtx.dom.ctx = ctx, ctx
+	return func(err error) {
+		end(err)
+		sessCtx.ctx, sessCtx.dom.ctx = nil, nil
+	}
+}
+
+// context returns the context of the mount or the event the operations of the dom are sent for.
+func (d *dom) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a client connection the operations are written to. Send is called concurrently by the broadcasts to
+// the topics of the connection, so it must serialize the writes.
+type Conn interface {
+	Send(message []byte) error
+	Close() error
+}
+
+// wsConn is a websocket connection. gorilla/websocket supports one concurrent writer: the copies of a wsConn
+// share the write lock of the connection. The messages of a live connection are queued, see WithWriteQueue.
+type wsConn struct {
+	*websocket.Conn
+	writeMu *sync.Mutex
+	queue   *writeQueue
+}
+
+func newWSConn(c *websocket.Conn) wsConn {
+	return wsConn{Conn: c, writeMu: &sync.Mutex{}}
+}
+
+func (w wsConn) Send(message []byte) error {
+	if w.queue != nil {
+		return w.queue.push(queuedMessage{data: message})
+	}
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return w.WriteMessage(websocket.TextMessage, message)
+}
+
+func (w wsConn) sendPrepared(preparedMessage *websocket.PreparedMessage) error {
+	if w.queue != nil {
+		return w.queue.push(queuedMessage{prepared: preparedMessage})
+	}
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return w.WritePreparedMessage(preparedMessage)
+}
+
+// Close closes the connection. A queued connection is closed once the messages queued so far are written.
+func (w wsConn) Close() error {
+	if w.queue != nil {
+		w.queue.close(nil)
+		return nil
+	}
+	return w.Conn.Close()
+}
+
+// writePrepared writes a message prepared once for all the websocket connections of a broadcast.
+func writePrepared(conn Conn, preparedMessage *websocket.PreparedMessage, message []byte) error {
+	if ws, ok := conn.(wsConn); ok {
+		return ws.sendPrepared(preparedMessage)
+	}
+	return conn.Send(message)
+}
+
+// RemoteView runs a view over connections which are managed outside the controller, e.g. by a serverless
+// websocket gateway. The connections are only known to the controller while they are added, so the gateway
+// adapter keeps them in a shared registry and adds the ones of the topic before handling a message.
+// The view's LiveEventReceiver isn't supported and the TopicStore is only kept while connections of its topic are
+// added.
+type RemoteView interface {
+	// Connect checks the connection connID opened with r like the live connections of the view's handler: its
+	// Origin, its CSRF token, see EnableCSRF, and the OnAuthorize hook of the view. It then calls the OnConnect
+	// hook of the view, see Connector. An error rejects the connection.
+	Connect(r *http.Request, connID string, conn Conn) error
+	// Topic returns the topic subscribed to by the connection opened with r. It's empty if there is none.
+	Topic(r *http.Request) string
+	// AddConnection makes conn receive the operations broadcast to topic.
+	AddConnection(topic, connID string, conn Conn)
+	// RemoveConnection closes conn and removes it from topic.
+	RemoveConnection(topic, connID string)
+	// HandleMessage handles a message sent by the connection connID. r is the request which opened the connection.
+	HandleMessage(r *http.Request, connID string, conn Conn, message []byte)
+	// Disconnect calls the OnDisconnect hook of the view, see Disconnector, and releases the resources held by the
+	// connection connID. r is the request which opened the connection, nil if it's unknown.
+	Disconnect(r *http.Request, connID string)
+	// Logger returns the Logger of the controller, see WithLogger, for the logs of the gateway adapter.
+	Logger() Logger
+}
+
+// remoteSessionTTL is the time after which the session of a remote connection without messages is dropped: the
+// disconnection of a serverless connection can be handled by another instance.
+const remoteSessionTTL = 30 * time.Minute
+
+type remoteView struct {
+	view              View
+	compiledView      *compiledView
+	compiledErrorView *compiledView
+	wc                *websocketController
+	sessions          map[string]*remoteSession
+	lastPrune         time.Time
+	sync.Mutex
+}
+
+// remoteSession is the handler and the session of a remote connection, kept between its messages.
+type remoteSession struct {
+	v        *viewHandler
+	sessCtx  *sessionContext
+	lastUsed time.Time
+	sync.Mutex
+}
+
+// Remote returns a RemoteView for view. The connections can be served by several instances, so the users must
+// keep their id across instances: WithSessionKeys or WithAuth is required.
+func (wc *websocketController) Remote(view View) RemoteView {
+	if len(wc.sessionKeys) == 0 && wc.auth == nil {
+		panic("remote view requires WithSessionKeys or WithAuth")
+	}
+	viewTemplate, errorViewTemplate := wc.compiledTemplates(view)
+	return &remoteView{
+		view:              view,
+		compiledView:      viewTemplate,
+		compiledErrorView: errorViewTemplate,
+		wc:                wc,
+		sessions:          make(map[string]*remoteSession),
+	}
+}
+
+func (rv *remoteView) viewHandler(w http.ResponseWriter, r *http.Request) (*viewHandler, error) {
+	user, sessionID, variants, err := rv.wc.getUser(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return &viewHandler{
+		view:              rv.view,
+		errorView:         rv.wc.errorView,
+		compiledView:      rv.compiledView,
+		compiledErrorView: rv.compiledErrorView,
+		mountData:         make(M),
+		wc:                rv.wc,
+		user:              user,
+		variants:          variants,
+		sessionID:         sessionID,
+	}, nil
+}
+
+func (rv *remoteView) Connect(r *http.Request, connID string, conn Conn) error {
+	if !rv.wc.checkOrigin(r) {
+		return ErrOriginNotAllowed
+	}
+	w := &discardResponseWriter{header: make(http.Header)}
+	v, err := rv.viewHandler(w, r)
+	if err != nil {
+		return err
+	}
+	if rv.wc.csrf && !rv.wc.validCSRFToken(r.URL.Query().Get(CSRFTokenKey), v.sessionID) {
+		return ErrInvalidCSRFToken
+	}
+	if err := v.authorizeConn(w, r); err != nil {
+		return err
+	}
+	s, err := rv.session(r, connID, conn)
+	if err != nil {
+		return err
+	}
+	defer s.Unlock()
+	ctx, cancel := rv.wc.connContext(r)
+	defer cancel()
+	s.sessCtx.base, s.sessCtx.cancel = ctx, cancel
+	if !s.v.connect(s.sessCtx) {
+		rv.Lock()
+		delete(rv.sessions, connID)
+		rv.Unlock()
+		return ErrConnectFailed
+	}
+	return nil
+}
+
+func (rv *remoteView) Topic(r *http.Request) string {
+	user, sessionID := rv.wc.identity(r)
+	topics := rv.wc.subscribeTopics(r, user, sessionID)
+	if len(topics) == 0 {
+		return ""
+	}
+	return topics[0]
+}
+
+func (rv *remoteView) Logger() Logger {
+	return rv.wc.logger
+}
+
+func (rv *remoteView) AddConnection(topic, connID string, conn Conn) {
+	rv.wc.addConnection(topic, connID, conn)
+}
+
+func (rv *remoteView) RemoveConnection(topic, connID string) {
+	rv.wc.removeConnection(topic, connID)
+}
+
+func (rv *remoteView) HandleMessage(r *http.Request, connID string, conn Conn, message []byte) {
+	s, err := rv.session(r, connID, conn)
+	if err != nil {
+		rv.wc.logger.Error("remote conn", "conn", connID, "err", err)
+		return
+	}
+	defer s.Unlock()
+	ctx, cancel := rv.wc.connContext(r)
+	defer cancel()
+	s.sessCtx.base, s.sessCtx.cancel = ctx, cancel
+	s.v.reloadTemplates()
+	s.v.handleMessage(s.sessCtx, message)
+	s.sessCtx.handling.Wait()
+}
+
+// session returns the session of the connection connID, created on its first message handled by this instance,
+// locked. The request and the connection of the message replace the ones of the previous message: on a serverless
+// gateway, they only live as long as the invocation handling the message.
+func (rv *remoteView) session(r *http.Request, connID string, conn Conn) (*remoteSession, error) {
+	rv.Lock()
+	now := time.Now()
+	if now.Sub(rv.lastPrune) > time.Minute {
+		for id, s := range rv.sessions {
+			if now.Sub(s.lastUsed) > remoteSessionTTL {
+				delete(rv.sessions, id)
+			}
+		}
+		rv.lastPrune = now
+	}
+	s, ok := rv.sessions[connID]
+	if !ok {
+		w := &discardResponseWriter{header: make(http.Header)}
+		v, err := rv.viewHandler(w, r)
+		if err != nil {
+			rv.Unlock()
+			return nil, err
+		}
+		v.reloadTemplates()
+		s = &remoteSession{v: v, sessCtx: v.newSession(context.Background(), func() {}, w, r, rv.Topic(r), connID, conn)}
+		rv.sessions[connID] = s
+	}
+	s.lastUsed = now
+	rv.Unlock()
+
+	s.Lock()
+	s.sessCtx.r = r
+	s.sessCtx.conn, s.sessCtx.dom.conn = conn, conn
+	// the store of the topic is dropped when the adapter removes its last connection
+	s.sessCtx.topicStore = rv.wc.topicStores.getOrCreate(s.sessCtx.dom.topic)
+	return s, nil
+}
+
+func (rv *remoteView) Disconnect(r *http.Request, connID string) {
+	defer func() {
+		if err := rv.wc.locker.ReleaseAll(connID); err != nil {
+			rv.wc.logger.Error("releasing locks", "conn", connID, "err", err)
+		}
+	}()
+	rv.Lock()
+	s, ok := rv.sessions[connID]
+	delete(rv.sessions, connID)
+	rv.Unlock()
+	if ok {
+		s.Lock()
+	} else {
+		if r == nil {
+			return
+		}
+		// the connection was opened on another instance
+		var err error
+		if s, err = rv.session(r, connID, goneConn{}); err != nil {
+			rv.wc.logger.Error("remote conn", "conn", connID, "err", err)
+			return
+		}
+		rv.Lock()
+		delete(rv.sessions, connID)
+		rv.Unlock()
+	}
+	defer s.Unlock()
+	ctx, cancel := rv.wc.connContext(s.sessCtx.r)
+	defer cancel()
+	s.sessCtx.base, s.sessCtx.cancel = ctx, cancel
+	s.v.disconnect(s.sessCtx)
+}
+
+// goneConn is the connection of a remote session disconnected by another instance than the one it was opened on.
+type goneConn struct{}
+
+func (goneConn) Send(message []byte) error {
+	return errConnClosed
+}
+
+func (goneConn) Close() error {
+	return nil
+}
+
+// discardResponseWriter is the http.ResponseWriter of the contexts of remote connections, which
+// have no response to write to.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(int) {}
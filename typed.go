@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ToM converts a struct to M keyed by the json names of its exported fields so that typed data can be passed to
+// DOM.Morph and returned from OnMount. Field values are kept as is. It is used by the code generated by cmd/glvgen.
+func ToM(v interface{}) M {
+	m := make(M)
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return m
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Map {
+		for _, k := range rv.MapKeys() {
+			if k.Kind() == reflect.String {
+				m[k.String()] = rv.MapIndex(k).Interface()
+			}
+		}
+		return m
+	}
+	if rv.Kind() != reflect.Struct {
+		return m
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		m[name] = rv.Field(i).Interface()
+	}
+	return m
+}
@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// UploadEventID is sent by the client to start an upload, e.g.
+// {"id":"glv:upload","params":{"ref":"avatar-1","name":"me.png","type":"image/png","size":48213}}. The file is
+// then sent in binary messages made of the length of the ref on one byte, the ref and the next chunk of the file.
+// The client receives an uploadProgress operation after each chunk. The completed uploads are available to the
+// next event handler with Context.Uploads, e.g. on the submit of the form.
+const UploadEventID = "glv:upload"
+
+var (
+	// ErrUploadRejected is sent in the uploadProgress operation of an upload which isn't allowed by EnableUploads.
+	ErrUploadRejected = errors.New("upload rejected")
+	// ErrUploadTooLarge is sent in the uploadProgress operation of an upload bigger than its declared size or the
+	// maximum size of EnableUploads.
+	ErrUploadTooLarge = errors.New("upload too large")
+)
+
+// EnableUploads accepts the uploads of files of at most maxSize bytes over the websocket connections. If types
+// are set, the media type of the file, declared by the client and sniffed from its content, must be one of them;
+// a type can end with /* e.g. image/*. The uploads are written to temporary files, removed once the handler of
+// the event they are passed to returns or when the connection is closed.
+func EnableUploads(maxSize int64, types ...string) Option {
+	return func(o *controlOpt) {
+		o.uploadMaxSize = maxSize
+		o.uploadTypes = types
+	}
+}
+
+// maxUploads is the maximum number of pending and completed uploads of a connection.
+const maxUploads = 16
+
+// Upload is a file uploaded over the live connection.
+type Upload struct {
+	Ref  string
+	Name string
+	// Type is the media type sniffed from the content of the file.
+	Type string
+	Size int64
+	path string
+}
+
+// Open opens the uploaded file. It must be read before the event handler returns.
+func (u Upload) Open() (io.ReadCloser, error) {
+	return os.Open(u.path)
+}
+
+// uploadProgress is the value of an uploadProgress operation.
+type uploadProgress struct {
+	Ref      string `json:"ref"`
+	Received int64  `json:"received"`
+	Size     int64  `json:"size"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
+}
+
+type pendingUpload struct {
+	upload   Upload
+	file     *os.File
+	received int64
+}
+
+// uploads are the uploads of a connection.
+type uploads struct {
+	pending   map[string]*pendingUpload
+	completed []Upload
+	sync.Mutex
+}
+
+func (u *uploads) start(upload Upload) error {
+	u.Lock()
+	defer u.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[string]*pendingUpload)
+	}
+	if p, ok := u.pending[upload.Ref]; ok {
+		p.abort()
+		delete(u.pending, upload.Ref)
+	}
+	if len(u.pending)+len(u.completed) >= maxUploads {
+		return fmt.Errorf("%w: more than %d uploads", ErrUploadRejected, maxUploads)
+	}
+	f, err := os.CreateTemp("", "glv-upload-*")
+	if err != nil {
+		return err
+	}
+	upload.path = f.Name()
+	u.pending[upload.Ref] = &pendingUpload{upload: upload, file: f}
+	return nil
+}
+
+func (p *pendingUpload) abort() {
+	p.file.Close()
+	os.Remove(p.upload.path)
+}
+
+// has reports whether ref is a pending upload of the connection.
+func (u *uploads) has(ref string) bool {
+	u.Lock()
+	defer u.Unlock()
+	_, ok := u.pending[ref]
+	return ok
+}
+
+// write appends a chunk to the upload ref. The upload is completed once its declared size is received.
+func (u *uploads) write(ref string, chunk []byte, types []string) (uploadProgress, error) {
+	u.Lock()
+	defer u.Unlock()
+	p, ok := u.pending[ref]
+	if !ok {
+		return uploadProgress{Ref: ref}, fmt.Errorf("upload %s not started", ref)
+	}
+	progress := uploadProgress{Ref: ref, Size: p.upload.Size}
+	fail := func(err error) (uploadProgress, error) {
+		p.abort()
+		delete(u.pending, ref)
+		progress.Error = err.Error()
+		return progress, err
+	}
+	if p.received+int64(len(chunk)) > p.upload.Size {
+		return fail(ErrUploadTooLarge)
+	}
+	if p.received == 0 {
+		p.upload.Type = http.DetectContentType(chunk)
+		if !allowedType(types, p.upload.Type) {
+			return fail(fmt.Errorf("%w: type %s", ErrUploadRejected, p.upload.Type))
+		}
+	}
+	if _, err := p.file.Write(chunk); err != nil {
+		return fail(err)
+	}
+	p.received += int64(len(chunk))
+	progress.Received = p.received
+	if p.received == p.upload.Size {
+		if err := p.file.Close(); err != nil {
+			return fail(err)
+		}
+		delete(u.pending, ref)
+		u.completed = append(u.completed, p.upload)
+		progress.Done = true
+	}
+	return progress, nil
+}
+
+// take returns the completed uploads, whose files are then removed by the event they are taken by.
+func (u *uploads) take() []Upload {
+	u.Lock()
+	defer u.Unlock()
+	completed := u.completed
+	u.completed = nil
+	return completed
+}
+
+// abort removes the pending upload ref because of err.
+func (u *uploads) abort(ref string, err error) uploadProgress {
+	u.Lock()
+	defer u.Unlock()
+	progress := uploadProgress{Ref: ref, Error: err.Error()}
+	if p, ok := u.pending[ref]; ok {
+		p.abort()
+		delete(u.pending, ref)
+		progress.Received, progress.Size = p.received, p.upload.Size
+	}
+	return progress
+}
+
+// clear removes the files of the pending and completed uploads.
+func (u *uploads) clear() {
+	u.Lock()
+	defer u.Unlock()
+	for ref, p := range u.pending {
+		p.abort()
+		delete(u.pending, ref)
+	}
+	removeUploads(u.completed)
+	u.completed = nil
+}
+
+func removeUploads(uploads []Upload) {
+	for _, upload := range uploads {
+		os.Remove(upload.path)
+	}
+}
+
+// allowedType reports whether the media type is in types. All the types are allowed if types is empty.
+func allowedType(types []string, mediaType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+		if prefix := strings.TrimSuffix(t, "*"); prefix != t && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// startUpload handles an UploadEventID.
+func (v *viewHandler) startUpload(sessCtx *sessionContext, event Event) {
+	var upload Upload
+	if err := event.DecodeParams(&upload); err != nil {
+		v.wc.logger.Error("upload event", "conn", sessCtx.connID, "err", err)
+		return
+	}
+	var err error
+	switch {
+	case v.wc.uploadMaxSize <= 0 || upload.Ref == "" || len(upload.Ref) > 255:
+		err = ErrUploadRejected
+	case upload.Size <= 0 || upload.Size > v.wc.uploadMaxSize:
+		err = ErrUploadTooLarge
+	case !allowedType(v.wc.uploadTypes, upload.Type):
+		err = fmt.Errorf("%w: type %s", ErrUploadRejected, upload.Type)
+	default:
+		err = sessCtx.uploads.start(upload)
+	}
+	progress := uploadProgress{Ref: upload.Ref, Size: upload.Size}
+	if err != nil {
+		v.wc.logger.Warn("upload", "conn", sessCtx.connID, "user", v.user, "ref", upload.Ref, "err", err)
+		progress.Error = err.Error()
+	}
+	v.wc.sendUploadProgress(sessCtx.conn, progress)
+}
+
+// uploadChunk returns the ref and the data of a binary message.
+func uploadChunk(message []byte) (string, []byte, bool) {
+	if len(message) == 0 || len(message) < 1+int(message[0]) {
+		return "", nil, false
+	}
+	n := int(message[0])
+	return string(message[1 : 1+n]), message[1+n:], true
+}
+
+// handleUploadChunk writes a binary message to its upload.
+func (v *viewHandler) handleUploadChunk(sessCtx *sessionContext, ref string, chunk []byte) {
+	// a chunk can't be skipped, the upload is aborted
+	if !v.allowEvent(sessCtx, UploadEventID) || !v.allowUser(sessCtx, UploadEventID) {
+		v.wc.sendUploadProgress(sessCtx.conn, sessCtx.uploads.abort(ref, ErrRateLimited))
+		return
+	}
+	progress, err := sessCtx.uploads.write(ref, chunk, v.wc.uploadTypes)
+	if err != nil {
+		v.wc.logger.Warn("upload", "conn", sessCtx.connID, "user", v.user, "ref", ref, "err", err)
+	}
+	v.wc.sendUploadProgress(sessCtx.conn, progress)
+}
+
+func (wc *websocketController) sendUploadProgress(conn Conn, progress uploadProgress) {
+	m := &Operation{Op: UploadProgress, Value: progress}
+	wc.messageConn(conn, m.encode(wc.logger))
+}
+
+// Uploads returns the uploads completed since the previous event. Their files are removed once the event handler
+// returns.
+func (s sessionContext) Uploads() []Upload {
+	if s.uploads == nil || s.taken == nil {
+		return nil
+	}
+	taken := s.uploads.take()
+	*s.taken = append(*s.taken, taken...)
+	return taken
+}
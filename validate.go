@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// Binding declares that a view morphs the element matched by Selector with the template named Template.
+type Binding struct {
+	Selector string `json:"selector"`
+	Template string `json:"template"`
+}
+
+// Binder is implemented by views which declare the bindings they pass to DOM.Morph so that they can be checked
+// at startup by Controller.Validate instead of failing at the first click.
+type Binder interface {
+	Bindings() []Binding
+}
+
+// ValidationError lists the problems found by Controller.Validate.
+type ValidationError struct {
+	Problems []string
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("%d template problem(s):\n  %s", len(v.Problems), strings.Join(v.Problems, "\n  "))
+}
+
+// Validate compiles the views and checks that the declared bindings and every {{template}} call refer to a defined
+// template. It returns a *ValidationError listing all the problems.
+func (wc *websocketController) Validate(views ...View) error {
+	var problems []string
+	for _, view := range append(views[:len(views):len(views)], wc.errorView) {
+		problems = append(problems, validateView(wc.templates(view), view, wc.funcs)...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func viewName(view View) string {
+	if f, ok := view.(fragmentView); ok {
+		return viewName(f.View)
+	}
+	if s, ok := view.(fmt.Stringer); ok {
+		return s.String()
+	}
+	t := reflect.TypeOf(view)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+func validateView(tfs templateFS, view View, funcs template.FuncMap) (problems []string) {
+	name := viewName(view)
+	defer func() {
+		// compileTemplate panics on parse errors
+		if r := recover(); r != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, r))
+		}
+	}()
+	t, err := parseTemplate(tfs, view, funcs)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", name, err)}
+	}
+	for _, u := range undefinedTemplates(t) {
+		problems = append(problems, fmt.Sprintf("%s: %s", name, u))
+	}
+	if b, ok := view.(Binder); ok {
+		for _, binding := range b.Bindings() {
+			if binding.Selector == "" {
+				problems = append(problems, fmt.Sprintf("%s: binding for template %q has no selector", name, binding.Template))
+			}
+			if t.Lookup(binding.Template) == nil {
+				problems = append(problems, fmt.Sprintf("%s: binding %q refers to undefined template %q",
+					name, binding.Selector, binding.Template))
+			}
+		}
+	}
+	return problems
+}
+
+// undefinedTemplates returns the {{template "name"}} calls which refer to a template which isn't defined.
+func undefinedTemplates(t *template.Template) []string {
+	var undefined []string
+	for _, tpl := range t.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		templateCalls(tpl.Tree.Root, func(called string) {
+			if t.Lookup(called) == nil {
+				undefined = append(undefined,
+					fmt.Sprintf("template %q calls undefined template %q", tpl.Name(), called))
+			}
+		})
+	}
+	sort.Strings(undefined)
+	return undefined
+}
+
+// templateCalls calls fn with the name of each {{template}} call under node.
+func templateCalls(node parse.Node, fn func(name string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			templateCalls(c, fn)
+		}
+	case *parse.TemplateNode:
+		fn(n.Name)
+	case *parse.IfNode:
+		templateCalls(n.List, fn)
+		templateCalls(n.ElseList, fn)
+	case *parse.RangeNode:
+		templateCalls(n.List, fn)
+		templateCalls(n.ElseList, fn)
+	case *parse.WithNode:
+		templateCalls(n.List, fn)
+		templateCalls(n.ElseList, fn)
+	}
+}
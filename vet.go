@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// Vet statically analyses the views of a project. On top of the checks done by Validate, it reports partials which
+// are never used, nested field accesses which fail at render time when an intermediate key is missing and bindings
+// whose selector doesn't match an element id in the view templates. funcs are the funcs added with WithFuncs, merged
+// with the FuncMap of each view like the controller does. It is used by cmd/glvvet.
+func Vet(projectRoot string, funcs template.FuncMap, views ...View) []string {
+	var problems []string
+	used := make(map[string]bool)
+	partials := make(map[string][]string)
+	for _, view := range views {
+		name := viewName(view)
+		problems = append(problems, validateView(osTemplates(projectRoot), view, funcs)...)
+		t, err := safeParseTemplate(projectRoot, view, funcs)
+		if err != nil {
+			continue
+		}
+		for _, tpl := range t.Templates() {
+			if tpl.Tree == nil {
+				continue
+			}
+			templateCalls(tpl.Tree.Root, func(called string) { used[called] = true })
+			renderCalls(tpl.Tree.Root, func(called string) { used[called] = true })
+			for _, risk := range missingKeyRisks(tpl.Tree.Root, nil) {
+				problems = append(problems, fmt.Sprintf("%s: template %q: %s", name, tpl.Name(), risk))
+			}
+		}
+		if b, ok := view.(Binder); ok {
+			source := viewSource(projectRoot, view)
+			for _, binding := range b.Bindings() {
+				used[binding.Template] = true
+				if id := strings.TrimPrefix(binding.Selector, "#"); id != binding.Selector && !hasID(source, id) {
+					problems = append(problems, fmt.Sprintf("%s: binding selector %q doesn't match an element id in the view templates",
+						name, binding.Selector))
+				}
+			}
+		}
+		for _, p := range view.Partials() {
+			dir := filepath.Join(projectRoot, p)
+			for _, file := range find(dir, view.Extensions()) {
+				partials[file] = partialNames(dir, file)
+			}
+		}
+	}
+
+	var unused []string
+	for file, names := range partials {
+		isUsed := false
+		for _, n := range names {
+			if used[n] {
+				isUsed = true
+				break
+			}
+		}
+		if !isUsed {
+			unused = append(unused, fmt.Sprintf("partial %s is never used", file))
+		}
+	}
+	sort.Strings(unused)
+	return append(problems, unused...)
+}
+
+func safeParseTemplate(projectRoot string, view View, funcs template.FuncMap) (t *template.Template, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return parseTemplate(osTemplates(projectRoot), view, funcs)
+}
+
+// partialNames returns the names a partial file is registered under and the templates it defines.
+func partialNames(dir, file string) []string {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == "." {
+		rel = filepath.Base(file)
+	}
+	names := []string{filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))), rel}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return names
+	}
+	t, err := template.New("").Funcs(DefaultFuncMap()).Parse(string(b))
+	if err != nil {
+		return names
+	}
+	for _, tpl := range t.Templates() {
+		if tpl.Name() != "" {
+			names = append(names, tpl.Name())
+		}
+	}
+	return names
+}
+
+// renderCalls calls fn with the name of each {{render "name" ...}} call under node.
+func renderCalls(node parse.Node, fn func(name string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			renderCalls(c, fn)
+		}
+	case *parse.ActionNode:
+		renderCalls(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			renderCalls(cmd, fn)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 2 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "render" {
+				if s, ok := n.Args[1].(*parse.StringNode); ok {
+					fn(s.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			renderCalls(arg, fn)
+		}
+	case *parse.IfNode:
+		renderCalls(n.Pipe, fn)
+		renderCalls(n.List, fn)
+		renderCalls(n.ElseList, fn)
+	case *parse.RangeNode:
+		renderCalls(n.Pipe, fn)
+		renderCalls(n.List, fn)
+		renderCalls(n.ElseList, fn)
+	case *parse.WithNode:
+		renderCalls(n.Pipe, fn)
+		renderCalls(n.List, fn)
+		renderCalls(n.ElseList, fn)
+	}
+}
+
+// missingKeyRisks reports nested field accesses e.g. {{.user.name}} which are not guarded by an {{if}} or {{with}}
+// on their parent. With missingkey=zero a missing user renders nothing for {{.user}} but fails for {{.user.name}}.
+func missingKeyRisks(node parse.Node, guarded map[string]bool) []string {
+	var risks []string
+	fields := func(pipe *parse.PipeNode) []string {
+		var out []string
+		if pipe == nil {
+			return out
+		}
+		for _, cmd := range pipe.Cmds {
+			for _, arg := range cmd.Args {
+				if f, ok := arg.(*parse.FieldNode); ok {
+					out = append(out, strings.Join(f.Ident, "."))
+				}
+			}
+		}
+		return out
+	}
+	check := func(pipe *parse.PipeNode) {
+		for _, f := range fields(pipe) {
+			parts := strings.Split(f, ".")
+			if len(parts) < 2 || guarded[strings.Join(parts[:len(parts)-1], ".")] {
+				continue
+			}
+			risks = append(risks, fmt.Sprintf("unguarded nested field .%s", f))
+		}
+	}
+	branch := func(pipe *parse.PipeNode, list, elseList *parse.ListNode) {
+		check(pipe)
+		inner := make(map[string]bool, len(guarded))
+		for k := range guarded {
+			inner[k] = true
+		}
+		for _, f := range fields(pipe) {
+			inner[f] = true
+		}
+		risks = append(risks, missingKeyRisks(list, inner)...)
+		risks = append(risks, missingKeyRisks(elseList, guarded)...)
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			risks = append(risks, missingKeyRisks(c, guarded)...)
+		}
+	case *parse.ActionNode:
+		check(n.Pipe)
+	case *parse.IfNode:
+		branch(n.Pipe, n.List, n.ElseList)
+	case *parse.WithNode:
+		// the dot changes inside with, nested fields are relative to it
+		check(n.Pipe)
+		risks = append(risks, missingKeyRisks(n.ElseList, guarded)...)
+	case *parse.RangeNode:
+		check(n.Pipe)
+		risks = append(risks, missingKeyRisks(n.ElseList, guarded)...)
+	}
+	return risks
+}
+
+// viewSource returns the concatenated source of the layout and content files of the view, or the inline templates.
+func viewSource(projectRoot string, view View) string {
+	var b strings.Builder
+	for _, s := range []string{view.Layout(), view.Content()} {
+		if s == "" {
+			continue
+		}
+		files := find(filepath.Join(projectRoot, s), view.Extensions())
+		if len(files) == 0 {
+			b.WriteString(s)
+		}
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err == nil {
+				b.Write(data)
+			}
+		}
+	}
+	for _, p := range view.Partials() {
+		for _, f := range find(filepath.Join(projectRoot, p), view.Extensions()) {
+			data, err := os.ReadFile(f)
+			if err == nil {
+				b.Write(data)
+			}
+		}
+	}
+	return b.String()
+}
+
+func hasID(source, id string) bool {
+	return regexp.MustCompile(`id\s*=\s*["']` + regexp.QuoteMeta(id) + `["']`).MatchString(source)
+}
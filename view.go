@@ -2,17 +2,19 @@ package controller
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
-	"io/fs"
-	"log"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/lithammer/shortuuid"
+	"github.com/gorilla/websocket"
 )
 
 var DefaultViewExtensions = []string{".gohtml", ".gotmpl", ".html", ".tmpl"}
@@ -20,6 +22,34 @@ var DefaultViewExtensions = []string{".gohtml", ".gotmpl", ".html", ".tmpl"}
 type Status struct {
 	Code    int    `json:"statusCode"`
 	Message string `json:"statusMessage"`
+	// Header is added to the response headers e.g. Cache-Control.
+	Header http.Header `json:"-"`
+	// Cookies are set on the response e.g. an auth cookie.
+	Cookies []*http.Cookie `json:"-"`
+	// Redirect redirects the client to the URL instead of rendering the view. Code is used if it's a 3xx
+	// redirect code, http.StatusFound otherwise.
+	Redirect string `json:"redirect,omitempty"`
+}
+
+// apply writes the headers and cookies of the status to w. It returns true if the client was redirected.
+func (s Status) apply(w http.ResponseWriter, r *http.Request) bool {
+	for k, vs := range s.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	for _, c := range s.Cookies {
+		http.SetCookie(w, c)
+	}
+	if s.Redirect == "" {
+		return false
+	}
+	code := s.Code
+	if code < 300 || code > 399 {
+		code = http.StatusFound
+	}
+	http.Redirect(w, r, s.Redirect, code)
+	return true
 }
 
 type View interface {
@@ -108,13 +138,15 @@ func (d DefaultView) OnMount(ctx Context) (Status, M) {
 func (d DefaultView) OnLiveEvent(ctx Context) error {
 	switch ctx.Event().ID {
 	default:
-		log.Printf("[defaultView] warning:handler not found for event => \n %+v\n", ctx.Event())
+		contextLogger(ctx).Warn("handler not found", "view", "DefaultView", "event", ctx.Event().ID,
+			"params", redactedEvent(ctx).Params)
 	}
 	return nil
 }
 
 // LiveEventReceiver is used to configure a receive only channel for receiving events from concurrent goroutines.
 // e.g. a concurrent goroutine sends a tick event every second to the returned channel which is then handled in OnLiveEvent.
+// Use an Inbox to buffer the events when the handler can be slower than the producer.
 func (d DefaultView) LiveEventReceiver() <-chan Event {
 	return nil
 }
@@ -157,7 +189,8 @@ func (d DefaultErrorView) OnMount(ctx Context) (Status, M) {
 func (d DefaultErrorView) OnLiveEvent(ctx Context) error {
 	switch ctx.Event().ID {
 	default:
-		log.Printf("[DefaultErrorView] warning:handler not found for event => \n %+v\n", ctx.Event())
+		contextLogger(ctx).Warn("handler not found", "view", "DefaultErrorView", "event", ctx.Event().ID,
+			"params", redactedEvent(ctx).Params)
 	}
 	return nil
 }
@@ -171,73 +204,229 @@ type viewHandler struct {
 	errorView         View
 	viewTemplate      *template.Template
 	errorViewTemplate *template.Template
+	compiledView      *compiledView
+	compiledErrorView *compiledView
 	mountData         M
 	user              int
+	variants          map[string]string
+	sessionID         string
 	wc                *websocketController
+	fragmentID        string
+	socketKey         string
+}
+
+func (v *viewHandler) topic(r *http.Request) (*string, []string) {
+	topics := v.wc.subscribeTopics(r, v.user, v.sessionID)
+	if len(topics) == 0 {
+		return nil, nil
+	}
+	topic := topics[0]
+	if v.fragmentID != "" {
+		topic = fragmentTopic(v.fragmentID, topic)
+	}
+	return &topic, topics[1:]
+}
+
+// userStore returns the store of the user, which is loaded from the state cookie in stateless mode.
+func (v *viewHandler) userStore(r *http.Request) Store {
+	if v.wc.stateCodec != nil {
+		return v.wc.loadState(r)
+	}
+	store, existing := v.wc.userSessions.getOrCreate(v.user)
+	if existing && v.wc.debugLog {
+		v.wc.logger.Debug("existing user", "user", v.user)
+	}
+	return store
 }
 
+func (v *viewHandler) selectorPrefix() string {
+	if v.fragmentID == "" {
+		return ""
+	}
+	return "#" + v.fragmentID
+}
+
+// reloadTemplates picks up the latest compiled templates. With DisableTemplateCache, only the templates
+// whose files changed are recompiled.
 func (v *viewHandler) reloadTemplates() {
 	var err error
-	if v.wc.disableTemplateCache {
-
-		v.viewTemplate, err = parseTemplate(v.wc.projectRoot, v.view)
-		if err != nil {
-			panic(err)
-		}
+	v.viewTemplate, err = v.compiledView.template(v.wc.checkTemplates())
+	if err != nil {
+		panic(err)
+	}
 
-		v.errorViewTemplate, err = parseTemplate(v.wc.projectRoot, v.errorView)
-		if err != nil {
-			panic(err)
-		}
+	v.errorViewTemplate, err = v.compiledErrorView.template(v.wc.checkTemplates())
+	if err != nil {
+		panic(err)
 	}
 }
 
 func onMount(w http.ResponseWriter, r *http.Request, v *viewHandler) {
+	v.wc.sendEarlyHints(w, r, v.view)
 	v.reloadTemplates()
 
 	var err error
 	var status Status
 
-	var topic *string
-	if v.wc.subscribeTopicFunc != nil {
-		topic = v.wc.subscribeTopicFunc(r)
+	topic, _ := v.topic(r)
+	store := v.userStore(r)
+	locale, country := v.wc.localeHints(r)
+	preferences := v.wc.readPreferences(r)
+	err = store.Put(M{"locale": locale, "country": country, preferencesKey: preferences})
+	if err != nil {
+		v.wc.logger.Error("onMount: saving locale", "topic", *topic, "user", v.user, "err", err)
 	}
-	store := v.wc.userSessions.getOrCreate(v.user)
 	sessCtx := sessionContext{
 		dom: &dom{
-			topic:         *topic,
-			wc:            v.wc,
-			store:         store,
-			rootTemplate:  v.viewTemplate,
-			temporaryKeys: []string{"selector", "template"},
+			topic:          *topic,
+			wc:             v.wc,
+			store:          store,
+			rootTemplate:   v.viewTemplate,
+			selectorPrefix: v.selectorPrefix(),
+			classScope:     v.classScope(),
+			variants:       v.variants,
+			flags:          v.flags(r),
 		},
+		topicStore: v.wc.topicStores.get(*topic),
+		variants:   v.variants,
+		user:       v.user,
+		locale:     locale,
+		country:    country,
 		event: Event{
 			ID: "onMount",
 		},
-		w: w,
-		r: r,
+		meta: &pageMeta{},
+		base: v.wc.requestContext(r),
+		w:    w,
+		r:    r,
+	}
+
+	if status, ok := v.wc.maintenanceStatus(); ok {
+		w.WriteHeader(status.Code)
+		onMountError(sessCtx, w, v, &status)
+		return
+	}
+	if !v.authorize(sessCtx, w, r) {
+		return
 	}
 
+	start := time.Now()
+	ctx, end := v.wc.tracer.Start(sessCtx.base, "glv.mount", "view", viewName(v.view), "topic", sessCtx.dom.topic,
+		"user", v.user)
+	sessCtx.ctx, sessCtx.dom.ctx = ctx, ctx
+	defer func() {
+		end(err)
+	}()
 	status, v.mountData = v.view.OnMount(sessCtx)
+	v.wc.checkSlow("mount", sessCtx.event.ID, "", start)
+	defer func() {
+		v.wc.track(AnalyticsEvent{
+			Kind:    "mount",
+			View:    viewName(v.view),
+			User:    v.user,
+			Topic:   sessCtx.dom.topic,
+			EventID: sessCtx.event.ID,
+			Status:  status.Code,
+			Err:     err,
+		}, start)
+	}()
 	if v.mountData == nil {
 		v.mountData = make(M)
 	}
+	if v.wc.allowScriptOps {
+		v.mountData[NonceKey] = newNonce()
+	}
 	v.mountData["app_name"] = v.wc.name
 	v.mountData["url_path"] = r.URL.Path
+	v.mountData["socket_url"] = v.wc.socketURL(r, v.socketKey)
+	v.mountData["view_id"] = v.socketKey
+	v.mountData[GenerationKey] = v.wc.generation
+	if scope := v.classScope(); scope != "" {
+		v.mountData[ScopeKey] = scope
+	}
+	if v.wc.awayEnabled() {
+		v.mountData[HeartbeatIntervalKey] = v.wc.pingInterval.Milliseconds()
+	}
+	if v.wc.csrf {
+		v.mountData[CSRFTokenKey] = v.wc.csrfToken(v.sessionID)
+	}
+	if v.wc.reconnectTokenTTL > 0 {
+		if token, err := v.wc.issueReconnectToken(v.user, sessCtx.dom.topic); err != nil {
+			v.wc.logger.Error("onMount: reconnect token", "topic", sessCtx.dom.topic, "user", v.user, "err", err)
+		} else {
+			v.mountData[ReconnectTokenKey] = token
+		}
+	}
+	crawler := v.wc.isCrawler(r)
+	v.mountData["prerender"] = crawler
+	if sessCtx.meta.tags != nil {
+		v.mountData["meta"] = sessCtx.meta.tags.HTML()
+	}
+	v.mountData["variants"] = v.variants
+	v.mountData["locale"] = locale
+	if v.wc.encryptOps {
+		if key, err := userPayloadKey(store); err != nil {
+			v.wc.logger.Error("onMount: payload key", "topic", sessCtx.dom.topic, "user", v.user, "err", err)
+		} else {
+			v.mountData[PayloadKey] = base64.StdEncoding.EncodeToString(key)
+		}
+	}
+	v.mountData[preferencesKey] = preferences
+	v.mountData[timezoneKey] = storedTimezone(store)
+	v.mountData["country"] = country
+	if flags := sessCtx.dom.flags; flags != nil {
+		v.mountData["flags"] = flags
+	}
+	// once the mount has put its keys, e.g. the payload key, in the store
+	if cs, ok := store.(*cookieState); ok {
+		cookie, err := cs.cookie()
+		if err != nil {
+			v.wc.logger.Error("onMount: state cookie", "topic", sessCtx.dom.topic, "user", v.user, "err", err)
+		} else {
+			http.SetCookie(w, cookie)
+		}
+	}
+	if len(v.wc.crawlerUserAgents) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if status.apply(w, r) {
+		return
+	}
 	w.WriteHeader(status.Code)
+	if v.fragmentID != "" {
+		w.Write([]byte(fragmentOpen(v.fragmentID)))
+		defer w.Write([]byte(fragmentClose()))
+	}
 	if status.Code > 299 {
 		onMountError(sessCtx, w, v, &status)
 		return
 	}
 	v.viewTemplate.Option("missingkey=zero")
-	err = v.viewTemplate.Execute(w, v.mountData)
+	var buf bytes.Buffer
+	start = time.Now()
+	err = v.viewTemplate.Execute(&buf, v.mountData)
+	v.wc.checkSlow("render", sessCtx.event.ID, v.viewTemplate.Name(), start)
 	if err != nil {
-		log.Printf("onMount viewTemplate.Execute error:  %v", err)
+		v.wc.logger.Error("onMount: rendering view", "topic", sessCtx.dom.topic, "user", v.user, "view", viewName(v.view), "err", err)
 		onMountError(sessCtx, w, v, nil)
+		return
+	}
+	html := buf.Bytes()
+	if sessCtx.meta.tags != nil {
+		html = injectMeta(html, *sessCtx.meta.tags)
+	}
+	if crawler {
+		html = prerender(html, canonicalURL(r))
+	} else if v.wc.announceRegion {
+		html = injectAnnounceRegion(html)
+	}
+	_, err = w.Write(html)
+	if err != nil {
+		v.wc.logger.Error("onMount: writing response", "topic", sessCtx.dom.topic, "user", v.user, "err", err)
 	}
 	if v.wc.debugLog {
-		log.Printf("onMount render view %+v, with data => \n %+v\n",
-			v.view.Content(), getJSON(v.mountData))
+		v.wc.logger.Debug("onMount: rendered view", "topic", sessCtx.dom.topic, "user", v.user, "view", viewName(v.view),
+			"duration", time.Since(start), "data", getJSON(v.wc.redaction, v.mountData))
 	}
 
 }
@@ -255,7 +444,7 @@ func onMountError(ctx Context, w http.ResponseWriter, v *viewHandler, status *St
 	v.mountData["statusMessage"] = status.Message
 	err := v.errorViewTemplate.Execute(w, v.mountData)
 	if err != nil {
-		log.Printf("err rendering error template: %v\n", err)
+		v.wc.logger.Error("rendering error view", "err", err)
 		_, errWrite := w.Write([]byte("Something went wrong"))
 		if errWrite != nil {
 			panic(errWrite)
@@ -264,9 +453,15 @@ func onMountError(ctx Context, w http.ResponseWriter, v *viewHandler, status *St
 }
 
 func onLiveEvent(w http.ResponseWriter, r *http.Request, v *viewHandler) {
-	var topic *string
-	if v.wc.subscribeTopicFunc != nil {
-		topic = v.wc.subscribeTopicFunc(r)
+	v.reloadTemplates()
+	topic, subscriptions := v.topic(r)
+
+	topicVal := ""
+	if topic != nil {
+		topicVal = *topic
+	}
+	if !v.wc.verifyReconnect(w, r.URL.Query().Get("reconnect"), v.user, topicVal) {
+		return
 	}
 
 	c, err := v.wc.upgrader.Upgrade(w, r, nil)
@@ -274,98 +469,321 @@ func onLiveEvent(w http.ResponseWriter, r *http.Request, v *viewHandler) {
 		return
 	}
 	defer c.Close()
-
-	connID := shortuuid.New()
-	if topic != nil {
-		v.wc.addConnection(*topic, connID, c)
-	}
-
-	store := v.wc.userSessions.getOrCreate(v.user)
-	err = store.Put(v.mountData)
-	if err != nil {
-		log.Printf("onLiveEvent: store.Put(mountData) err %v\n", err)
-	}
-
-	topicVal := ""
+	v.wc.setReadLimit(c)
+	ctx, cancel := v.wc.connContext(r)
+	defer cancel()
+	stopHeartbeat := v.wc.startHeartbeat(c, cancel)
+	defer stopHeartbeat()
+
+	connID := v.wc.newID()
+	conn := v.wc.newLiveConn(c, cancel)
+	defer conn.Close()
 	if topic != nil {
-		topicVal = *topic
+		v.wc.addConnection(*topic, connID, conn)
+		for _, t := range subscriptions {
+			v.wc.addConnection(t, connID, conn)
+		}
 	}
 
-	sessCtx := sessionContext{
-		dom: &dom{
-			topic:         topicVal,
-			wc:            v.wc,
-			store:         store,
-			rootTemplate:  v.viewTemplate,
-			temporaryKeys: []string{"selector", "template"},
-		},
-		w: w,
-		r: r,
+	sessCtx := v.newSession(ctx, cancel, w, r, topicVal, connID, conn)
+	v.wc.liveConns.add(sessCtx, v)
+	defer v.wc.liveConns.remove(connID)
+	v.wc.joinPresence(topicVal, v.user, connID)
+	defer v.wc.leavePresence(connID)
+	defer sessCtx.uploads.clear()
+	v.wc.checkGeneration(r, conn)
+	v.wc.rotateReconnectToken(conn, v.user, topicVal)
+	resumed := v.restoreState(sessCtx, r.URL.Query().Get("resume"))
+	v.issueStateToken(sessCtx)
+	connected := v.connect(sessCtx)
+	if !connected || !v.reconnect(sessCtx, resumed) {
+		conn.Close()
 	}
 	done := make(chan struct{})
 	if v.view.LiveEventReceiver() != nil {
-		go func() {
-			for {
-				select {
-				case event := <-v.view.LiveEventReceiver():
-					sessCtx.event = event
-					err := v.view.OnLiveEvent(sessCtx)
-					if err != nil {
-						log.Printf("[error] \n event => %+v, \n err: %v\n", event, err)
-					}
-				case <-done:
-					return
-				}
-			}
-
-		}()
+		go v.receive(sessCtx.fork(), done)
 	}
 
 loop:
 	for {
-		_, message, err := c.ReadMessage()
+		messageType, message, err := c.ReadMessage()
 		if err != nil {
-			log.Println("c.readMessage error: ", err)
+			v.wc.logger.Info("connection closed", "topic", topicVal, "conn", connID, "user", v.user, "err", err)
 			break loop
 		}
-
-		event := new(Event)
-		err = json.NewDecoder(bytes.NewReader(message)).Decode(event)
-		if err != nil {
-			log.Printf("err: parsing event, msg %s \n", string(message))
+		v.wc.extendReadDeadline(c)
+		if messageType == websocket.BinaryMessage {
+			if ref, chunk, ok := uploadChunk(message); ok {
+				v.handleUploadChunk(sessCtx, ref, chunk)
+			}
 			continue
 		}
+		v.handleMessage(sessCtx, message)
+	}
+	sessCtx.handling.Wait()
+	v.saveState(sessCtx)
+	if v.view.LiveEventReceiver() != nil {
+		done <- struct{}{}
+	}
+	if err := v.wc.locker.ReleaseAll(connID); err != nil {
+		v.wc.logger.Error("releasing locks", "topic", topicVal, "conn", connID, "err", err)
+	}
+	if topic != nil {
+		v.wc.removeConnection(*topic, connID)
+	}
+	if connected {
+		v.disconnect(sessCtx)
+	}
+}
 
-		if event.ID == "" {
-			log.Printf("err: event %v, field event.id is required\n", event)
-			continue
+// receive calls the view's event handler with the events sent to its LiveEventReceiver until done. sessCtx is a
+// fork of the session of the connection since they are handled concurrently with the events read from it.
+func (v *viewHandler) receive(sessCtx *sessionContext, done <-chan struct{}) {
+	for {
+		select {
+		case event := <-v.view.LiveEventReceiver():
+			sessCtx.dom.receivedAt = time.Now()
+			sessCtx.dom.eventID = event.ID
+			sessCtx.dom.resetKeys()
+			sessCtx.event = event
+			sessCtx.taken = &[]Upload{}
+			sessCtx.dom.beginBatch()
+			endSpan := v.traceEvent(sessCtx)
+			err, slotErr := v.runHandler(func() error { return v.dispatch(*sessCtx) })
+			if slotErr != nil {
+				err = fmt.Errorf("event %s from user %d: %w", event.ID, v.user, slotErr)
+			}
+			removeUploads(*sessCtx.taken)
+			endSpan(err)
+			v.wc.checkSlow("event", event.ID, "", sessCtx.dom.receivedAt)
+			v.trackEvent(sessCtx, err)
+			if err != nil {
+				v.wc.logger.Error("event handler", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "event", event.ID,
+					"duration", time.Since(sessCtx.dom.receivedAt), "err", err, "params", v.wc.redaction.event(event).Params)
+			}
+			sessCtx.dom.settle(event.ID)
+			sessCtx.dom.endBatch()
+		case <-done:
+			return
 		}
+	}
+}
 
-		v.reloadTemplates()
-		sessCtx.event = *event
-		sessCtx.unsetError()
+// runHandler calls handle within a slot of the user's concurrency limit. It returns the error of handle, or the error
+// of acquiring the slot in which case handle isn't called.
+func (v *viewHandler) runHandler(handle func() error) (error, error) {
+	release, err := v.wc.acquireSlot(v.user)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	v.wc.load.begin()
+	defer v.wc.load.end()
+	return handle(), nil
+}
+
+func (v *viewHandler) trackEvent(sessCtx *sessionContext, err error) {
+	v.wc.metrics.EventHandled(viewName(v.view), v.metricsEventID(sessCtx.event.ID), time.Since(sessCtx.dom.receivedAt), err)
+	v.wc.track(AnalyticsEvent{
+		Kind:    "event",
+		View:    viewName(v.view),
+		User:    v.user,
+		Topic:   sessCtx.dom.topic,
+		EventID: sessCtx.event.ID,
+		Err:     err,
+	}, sessCtx.dom.receivedAt)
+}
+
+// newSession creates the context of a live connection and restores the mount data in the user store. ctx is the
+// context of the connection, cancelled with cancel once it's closed.
+func (v *viewHandler) newSession(ctx context.Context, cancel context.CancelFunc, w http.ResponseWriter, r *http.Request,
+	topic, connID string, conn Conn) *sessionContext {
+	store := v.userStore(r)
+	if cs, ok := store.(*cookieState); ok {
+		cs.sink = func(cookie *http.Cookie) {
+			v.wc.messageConn(conn, setCookieOperation(cookie).encode(v.wc.logger))
+		}
+	}
+	err := store.Put(v.mountData)
+	if err != nil {
+		v.wc.logger.Error("onLiveEvent: saving mount data", "topic", topic, "conn", connID, "user", v.user, "err", err)
+	}
 
-		var eventHandlerErr error
-		if v.wc.debugLog {
-			log.Printf("[controller] received event %+v \n", sessCtx.event)
+	v.wc.registerPayloadKey(connID, store)
+	locale, country := v.wc.localeHints(r)
+	var nonce string
+	if v.wc.allowScriptOps {
+		// the nonce of the page the connection is opened from, the other tabs of the user have their own
+		if nonce = r.URL.Query().Get("nonce"); !validNonce(nonce) {
+			v.wc.logger.Warn("onLiveEvent: no nonce", "topic", topic, "conn", connID, "user", v.user)
+			nonce = ""
 		}
-		eventHandlerErr = v.view.OnLiveEvent(sessCtx)
+	}
+	var owned *ownedKeys
+	if v.wc.resumable() {
+		owned = &ownedKeys{keys: make(map[string]struct{})}
+	}
+	return &sessionContext{
+		dom: &dom{
+			topic:          topic,
+			wc:             v.wc,
+			store:          store,
+			rootTemplate:   v.viewTemplate,
+			selectorPrefix: v.selectorPrefix(),
+			classScope:     v.classScope(),
+			connID:         connID,
+			conn:           conn,
+			owned:          owned,
+			nonce:          nonce,
+			variants:       v.variants,
+			flags:          v.flags(r),
+		},
+		topicStore: v.wc.topicStores.getOrCreate(topic),
+		connID:     connID,
+		conn:       conn,
+		variants:   v.variants,
+		user:       v.user,
+		locale:     locale,
+		country:    country,
+		observer:   v.wc.isObserver(r),
+		uploads:    &uploads{},
+		handling:   &sync.WaitGroup{},
+		serial:     &sync.Mutex{},
+		base:       ctx,
+		cancel:     cancel,
+		limiter:    v.wc.newEventLimiter(),
+		w:          w,
+		r:          r,
+		page:       r.URL,
+	}
+}
+
+// handleMessage decodes an event sent by the client and calls the view's event handler.
+func (v *viewHandler) handleMessage(sessCtx *sessionContext, message []byte) {
+	event := new(Event)
+	err := json.NewDecoder(bytes.NewReader(message)).Decode(event)
+	// every message counts, including the heartbeats and the ones which aren't events
+	if !v.allowEvent(sessCtx, event.ID) {
+		return
+	}
+	if err != nil {
+		v.wc.logger.Error("parsing event", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "bytes", len(message))
+		return
+	}
+	v.wc.seenPresence(sessCtx.connID)
+
+	if event.ID == "" {
+		v.wc.logger.Error("event without id", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "params", v.wc.redaction.event(*event).Params)
+		return
+	}
+
+	if event.Params, err = formParams(event.Params); err != nil {
+		v.wc.logger.Error("decoding params", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "event", event.ID, "err", err)
+		return
+	}
 
-		if eventHandlerErr != nil {
-			log.Printf("[error] \n event => %+v, \n err: %v\n", event, eventHandlerErr)
-			sessCtx.setError(UserError(eventHandlerErr), eventHandlerErr)
+	if event.ID == HeartbeatEventID {
+		return
+	}
+
+	if sessCtx.observer && !v.wc.observerAllowed(event.ID) {
+		v.wc.logger.Warn("event from observer", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "event", event.ID, "err", ErrReadOnly)
+		return
+	}
+
+	if !v.allowUser(sessCtx, event.ID) {
+		return
+	}
+
+	if event.ID == TimezoneEventID {
+		if err := setTimezone(sessCtx.dom.store, *event); err != nil {
+			v.wc.logger.Error("setting timezone", "conn", sessCtx.connID, "user", v.user, "err", err)
 		}
+		return
 	}
-	if v.view.LiveEventReceiver() != nil {
-		done <- struct{}{}
+
+	if event.ID == UploadEventID {
+		v.startUpload(sessCtx, *event)
+		return
 	}
-	if topic != nil {
-		v.wc.removeConnection(*topic, connID)
+
+	sessCtx.serial.Lock()
+	v.reloadTemplates()
+	sessCtx.dom.rootTemplate = v.viewTemplate
+	sessCtx.serial.Unlock()
+	if event.ID == NavigateEventID {
+		// the navigation swaps the view of the handlers still running
+		sessCtx.handling.Wait()
+	}
+	if !v.schedule(sessCtx, *event) {
+		sessCtx.serial.Lock()
+		defer sessCtx.serial.Unlock()
+		v.handleEvent(sessCtx, *event)
+	}
+}
+
+// handleEvent calls the view's event handler with the event decoded by handleMessage.
+func (v *viewHandler) handleEvent(sessCtx *sessionContext, event Event) {
+	sessCtx.dom.receivedAt = time.Now()
+	sessCtx.dom.eventID = event.ID
+	sessCtx.dom.resetKeys()
+	sessCtx.event = event
+	sessCtx.taken = &[]Upload{}
+	var eventHandlerErr error
+	endSpan := v.traceEvent(sessCtx)
+	defer func() {
+		endSpan(eventHandlerErr)
+	}()
+	sessCtx.dom.beginBatch()
+	defer sessCtx.dom.endBatch()
+	sessCtx.unsetError()
+
+	if v.wc.debugLog {
+		v.wc.logger.Debug("received event", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "event", event.ID,
+			"params", v.wc.redaction.event(sessCtx.event).Params)
+	}
+	if v.wc.overloaded() {
+		v.wc.logger.Warn("overloaded, asking to retry the event", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "event", event.ID)
+		v.wc.retry(sessCtx.conn, event)
+		return
 	}
+	if err := v.decodeParams(sessCtx); err != nil {
+		sessCtx.setError(UserError(err), err)
+		return
+	}
+	eventHandlerErr, err := v.runHandler(func() error {
+		if event.ID == NavigateEventID {
+			return v.navigate(sessCtx)
+		}
+		return v.dispatch(*sessCtx)
+	})
+	if err != nil {
+		sessCtx.setError(err.Error(), fmt.Errorf("event %s from user %d: %w", event.ID, v.user, err))
+		return
+	}
+	removeUploads(*sessCtx.taken)
+	v.wc.checkSlow("event", event.ID, "", sessCtx.dom.receivedAt)
+	v.trackEvent(sessCtx, eventHandlerErr)
+
+	if eventHandlerErr != nil {
+		v.wc.logger.Error("event handler", "topic", sessCtx.dom.topic, "conn", sessCtx.connID, "user", v.user, "event", event.ID,
+			"duration", time.Since(sessCtx.dom.receivedAt), "err", eventHandlerErr, "params", v.wc.redaction.event(event).Params)
+		sessCtx.setError(UserError(eventHandlerErr), eventHandlerErr)
+	}
+	sessCtx.dom.settle(event.ID)
 }
 
 // creates a html/template from the View type.
-func parseTemplate(projectRoot string, view View) (*template.Template, error) {
+func parseTemplate(tfs templateFS, view View, funcs template.FuncMap) (*template.Template, error) {
+	t, err := compileTemplate(tfs, view, mergeFuncs(funcs, view.FuncMap()))
+	if err != nil {
+		return nil, err
+	}
+	bindRender(t)
+	return t, nil
+}
+
+func compileTemplate(tfs templateFS, view View, funcMap template.FuncMap) (*template.Template, error) {
 	// if both layout and content is empty show a default view.
 	if view.Layout() == "" && view.Content() == "" {
 		return template.Must(template.New("").
@@ -376,13 +794,13 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 	if view.Layout() != "" && view.Content() == "" {
 		var layoutTemplate *template.Template
 		// check if layout is not a file or directory
-		if _, err := os.Stat(filepath.Join(projectRoot, view.Layout())); errors.Is(err, os.ErrNotExist) {
+		if tfs.missing(tfs.join(view.Layout())) {
 			// is not a file but html content
-			layoutTemplate = template.Must(template.New("").Funcs(view.FuncMap()).Parse(view.Layout()))
+			layoutTemplate = template.Must(template.New("").Funcs(funcMap).Parse(view.Layout()))
 		} else {
 			// layout must be a file
-			viewLayoutPath := filepath.Join(projectRoot, view.Layout())
-			ok, err := isDirectory(viewLayoutPath)
+			viewLayoutPath := tfs.join(view.Layout())
+			ok, err := tfs.isDirectory(viewLayoutPath)
 			if err == nil && ok {
 				return nil, fmt.Errorf("layout is a directory but it must be a file")
 			}
@@ -391,14 +809,13 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 				return nil, err
 			}
 			// compile layout
-			commonFiles := []string{viewLayoutPath}
+			layoutTemplate = template.Must(tfs.parseFiles(template.New(viewLayoutPath).
+				Funcs(funcMap), viewLayoutPath))
 			// global partials
-			for _, p := range view.Partials() {
-				commonFiles = append(commonFiles, find(filepath.Join(projectRoot, p), view.Extensions())...)
+			layoutTemplate, err = parsePartials(layoutTemplate, tfs, view)
+			if err != nil {
+				return nil, err
 			}
-			layoutTemplate = template.Must(template.New(viewLayoutPath).
-				Funcs(view.FuncMap()).
-				ParseFiles(commonFiles...))
 		}
 		return template.Must(layoutTemplate.Clone()), nil
 	}
@@ -406,23 +823,19 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 	// if layout is empty and content is set
 	if view.Layout() == "" && view.Content() != "" {
 		// check if content is a not a file or directory
-		if _, err := os.Stat(filepath.Join(projectRoot, view.Content())); errors.Is(err, os.ErrNotExist) {
+		if tfs.missing(tfs.join(view.Content())) {
 			return template.Must(template.New("base").
-				Funcs(view.FuncMap()).
+				Funcs(funcMap).
 				Parse(view.Content())), nil
 		} else {
 
-			viewContentPath := filepath.Join(projectRoot, view.Content())
+			viewContentPath := tfs.join(view.Content())
 			// is a file or directory
-			var pageFiles []string
 			// view and its partials
-			pageFiles = append(pageFiles, find(viewContentPath, view.Extensions())...)
-			for _, p := range view.Partials() {
-				pageFiles = append(pageFiles, find(filepath.Join(projectRoot, p), view.Extensions())...)
-			}
-			return template.Must(template.New(filepath.Base(viewContentPath)).
-				Funcs(view.FuncMap()).
-				ParseFiles(pageFiles...)), nil
+			pageFiles := tfs.find(viewContentPath, view.Extensions())
+			contentTemplate := template.Must(tfs.parseFiles(template.New(filepath.Base(viewContentPath)).
+				Funcs(funcMap), pageFiles...))
+			return parsePartials(contentTemplate, tfs, view)
 		}
 	}
 
@@ -431,13 +844,13 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 	// 1. build layout
 	var layoutTemplate *template.Template
 	// check if layout is not a file or directory
-	if _, err := os.Stat(filepath.Join(projectRoot, view.Layout())); errors.Is(err, os.ErrNotExist) {
+	if tfs.missing(tfs.join(view.Layout())) {
 		// is not a file but html content
-		layoutTemplate = template.Must(template.New("base").Funcs(view.FuncMap()).Parse(view.Layout()))
+		layoutTemplate = template.Must(template.New("base").Funcs(funcMap).Parse(view.Layout()))
 	} else {
 		// layout must be a file
-		viewLayoutPath := filepath.Join(projectRoot, view.Layout())
-		ok, err := isDirectory(viewLayoutPath)
+		viewLayoutPath := tfs.join(view.Layout())
+		ok, err := tfs.isDirectory(viewLayoutPath)
 		if err == nil && ok {
 			return nil, fmt.Errorf("layout is a directory but it must be a file")
 		}
@@ -446,15 +859,14 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 			return nil, err
 		}
 		// compile layout
-		commonFiles := []string{viewLayoutPath}
+		layoutTemplate = template.Must(
+			tfs.parseFiles(template.New(filepath.Base(viewLayoutPath)).
+				Funcs(funcMap), viewLayoutPath))
 		// global partials
-		for _, p := range view.Partials() {
-			commonFiles = append(commonFiles, find(filepath.Join(projectRoot, p), view.Extensions())...)
+		layoutTemplate, err = parsePartials(layoutTemplate, tfs, view)
+		if err != nil {
+			return nil, err
 		}
-		layoutTemplate = template.Must(
-			template.New(filepath.Base(viewLayoutPath)).
-				Funcs(view.FuncMap()).
-				ParseFiles(commonFiles...))
 
 		//log.Println("compiled layoutTemplate...")
 		//for _, v := range layoutTemplate.Templates() {
@@ -464,16 +876,16 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 
 	// 2. add content
 	// check if content is a not a file or directory
-	if _, err := os.Stat(filepath.Join(projectRoot, view.Content())); errors.Is(err, os.ErrNotExist) {
+	if tfs.missing(tfs.join(view.Content())) {
 		// content is not a file or directory but html content
 		viewTemplate = template.Must(layoutTemplate.Parse(view.Content()))
 	} else {
 		// content is a file or directory
 		var pageFiles []string
 		// view and its partials
-		pageFiles = append(pageFiles, find(filepath.Join(projectRoot, view.Content()), view.Extensions())...)
+		pageFiles = append(pageFiles, tfs.find(tfs.join(view.Content()), view.Extensions())...)
 
-		viewTemplate = template.Must(layoutTemplate.ParseFiles(pageFiles...))
+		viewTemplate = template.Must(tfs.parseFiles(layoutTemplate, pageFiles...))
 	}
 
 	// check if the final viewTemplate contains a content child template which is `content` by default.
@@ -486,6 +898,53 @@ func parseTemplate(projectRoot string, view View) (*template.Template, error) {
 	return viewTemplate, nil
 }
 
+// injectAnnounceRegion places the aria-live region before the closing body tag or at the end of the html.
+func injectAnnounceRegion(html []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(html), []byte("</body>"))
+	if i < 0 {
+		return append(html, announceRegion...)
+	}
+	out := make([]byte, 0, len(html)+len(announceRegion))
+	out = append(out, html[:i]...)
+	out = append(out, announceRegion...)
+	return append(out, html[i:]...)
+}
+
+// parsePartials adds the partials of the view to t. Each file is registered under its path relative to the partials
+// directory without the extension e.g. widgets/card, so same named files in nested directories don't collide.
+// Files at the root of the partials directory are also registered under their file name as done by ParseFiles. A
+// name already defined, e.g. by a define of the layout or another partials directory, is an error: the partial
+// would silently replace it.
+func parsePartials(t *template.Template, tfs templateFS, view View) (*template.Template, error) {
+	for _, p := range view.Partials() {
+		dir := tfs.join(p)
+		for _, file := range tfs.find(dir, view.Extensions()) {
+			b, err := tfs.readFile(file)
+			if err != nil {
+				return nil, err
+			}
+			rel, err := filepath.Rel(dir, file)
+			if err != nil || rel == "." {
+				rel = filepath.Base(file)
+			}
+			names := []string{filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))}
+			if filepath.Base(rel) == rel {
+				names = append(names, rel)
+			}
+			for _, name := range names {
+				if t.Lookup(name) != nil {
+					return nil, fmt.Errorf("partial %s: template %s is already defined", file, name)
+				}
+				_, err = t.New(name).Parse(string(b))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
 var DefaultUserErrorMessage = "internal error"
 
 func UserError(err error) string {
@@ -497,35 +956,7 @@ func UserError(err error) string {
 }
 
 func find(p string, extensions []string) []string {
-	var files []string
-
-	fi, err := os.Stat(p)
-	if os.IsNotExist(err) {
-		return files
-	}
-	if !fi.IsDir() {
-		if !contains(extensions, filepath.Ext(p)) {
-			return files
-		}
-		files = append(files, p)
-		return files
-	}
-	err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if contains(extensions, filepath.Ext(d.Name())) {
-			files = append(files, path)
-		}
-		return nil
-	})
-
-	if err != nil {
-		panic(err)
-	}
-
-	return files
+	return osTemplates("").find(p, extensions)
 }
 
 func contains(arr []string, s string) bool {
@@ -536,12 +967,3 @@ func contains(arr []string, s string) bool {
 	}
 	return false
 }
-
-func isDirectory(path string) (bool, error) {
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		return false, err
-	}
-
-	return fileInfo.IsDir(), err
-}
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"io/fs"
-	"log"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,13 +12,24 @@ import (
 
 var DefaultWatchExtensions = []string{".go", ".gohtml", ".gotmpl", ".html", ".tmpl"}
 
+// watchingTemplates reports whether the template files are watched, in which case the views are recompiled when
+// the watcher invalidates them instead of checking their files on each request.
+func (wc *websocketController) watchingTemplates() bool {
+	return wc.enableWatch && wc.templateFS == nil
+}
+
+// checkTemplates reports whether the files of the templates are checked for changes on each request.
+func (wc *websocketController) checkTemplates() bool {
+	return wc.disableTemplateCache && !wc.watchingTemplates()
+}
+
 func watchTemplates(wc *websocketController) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		wc.logger.Error("watching templates, the changes won't be reloaded", "err", err)
+		return
 	}
 	defer watcher.Close()
-	done := make(chan bool)
 	go func() {
 		for {
 			select {
@@ -27,35 +37,45 @@ func watchTemplates(wc *websocketController) {
 				if !ok {
 					return
 				}
+				if !slices.Contains(wc.watchExts, filepath.Ext(event.Name)) {
+					continue
+				}
 				if event.Op&fsnotify.Write == fsnotify.Write ||
 					event.Op&fsnotify.Remove == fsnotify.Remove ||
+					event.Op&fsnotify.Rename == fsnotify.Rename ||
 					event.Op&fsnotify.Create == fsnotify.Create {
+					// the templates are reparsed once here, not on each request
+					invalidated := wc.compiledViews.invalidate(event.Name, event.Op&fsnotify.Write != fsnotify.Write)
+					wc.logger.Info("invalidated templates", "path", event.Name, "views", len(invalidated))
+					if wc.hotReload && wc.rerenderViews(event.Name, invalidated) {
+						time.Sleep(1000 * time.Millisecond)
+						continue
+					}
+					go wc.compiledViews.rewarm(wc.logger)
 					m := &Operation{Op: Reload}
-					wc.messageAll(m.Bytes())
+					wc.messageAll(m.encode(wc.logger))
 					time.Sleep(1000 * time.Millisecond)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				log.Println("error:", err)
+				wc.logger.Error("watching templates", "err", err)
 			}
 		}
 	}()
 
-	// watch extensions
+	// watch the directories so that the files created later, e.g. a new partial, are picked up too
 	filepath.WalkDir(wc.projectRoot, func(path string, d fs.DirEntry, err error) error {
-		if d != nil && !d.IsDir() {
-			if slices.Contains(wc.watchExts, filepath.Ext(path)) {
-				if strings.Contains(path, "node_modules") {
-					return nil
-				}
-				log.Println("watching =>", path)
-				return watcher.Add(path)
-			}
+		if d == nil || !d.IsDir() {
+			return nil
+		}
+		if path != wc.projectRoot && (d.Name() == "node_modules" || strings.HasPrefix(d.Name(), ".")) {
+			return filepath.SkipDir
 		}
-		return nil
+		wc.logger.Debug("watching", "path", path)
+		return watcher.Add(path)
 	})
 
-	<-done
+	<-wc.shutdown.done
 }
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultWriteQueueSize is the number of messages buffered for a websocket connection, see WithWriteQueue.
+const DefaultWriteQueueSize = 256
+
+// writeTimeout bounds the write of a queued message so that a client which stopped reading doesn't hold its writer.
+const writeTimeout = 10 * time.Second
+
+// ErrWriteQueueFull is the error of a connection closed because its write queue is full, see CloseWhenFull.
+var ErrWriteQueueFull = errors.New("write queue full")
+
+var errConnClosed = errors.New("connection closed")
+
+// QueuePolicy decides what happens when a message is sent to a connection whose write queue is full.
+type QueuePolicy int
+
+const (
+	// CloseWhenFull closes the connection: the client reconnects and renders the current state on mount.
+	CloseWhenFull QueuePolicy = iota
+	// DropWhenFull drops the message, the client misses it.
+	DropWhenFull
+)
+
+// WithWriteQueue buffers up to size messages per websocket connection, written by a goroutine of the connection,
+// so that a slow client doesn't delay the broadcasts to the others nor the handlers sending to it. policy applies
+// once the queue of a connection is full. A size of 0 writes the messages directly. Defaults to
+// DefaultWriteQueueSize and CloseWhenFull.
+func WithWriteQueue(size int, policy QueuePolicy) Option {
+	return func(o *controlOpt) {
+		o.writeQueueSize = size
+		o.queuePolicy = policy
+	}
+}
+
+// queuedMessage is a message of a write queue, prepared once for all the connections of a broadcast or not.
+type queuedMessage struct {
+	prepared *websocket.PreparedMessage
+	data     []byte
+}
+
+// writeQueue buffers the messages of a websocket connection until its writer goroutine writes them.
+type writeQueue struct {
+	messages chan queuedMessage
+	// closing receives the close frame to write once the queued messages are written, nil for none.
+	closing   chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	policy    QueuePolicy
+	// failed is called once when a write fails or the queue overflows with CloseWhenFull.
+	failed func(err error)
+	// dropped is called for each message dropped with DropWhenFull.
+	dropped func()
+}
+
+// newLiveConn returns the connection of the live websocket c. Its messages are queued, if WithWriteQueue isn't
+// disabled, and a failure to write them closes c and calls cancel. The caller closes the returned connection to
+// stop its writer once the messages queued so far are written.
+func (wc *websocketController) newLiveConn(c *websocket.Conn, cancel func()) wsConn {
+	ws := newWSConn(c)
+	if wc.writeQueueSize <= 0 {
+		return ws
+	}
+	q := &writeQueue{
+		messages: make(chan queuedMessage, wc.writeQueueSize),
+		closing:  make(chan []byte, 1),
+		done:     make(chan struct{}),
+		policy:   wc.queuePolicy,
+		failed: func(err error) {
+			wc.logger.Error("writing message, closing conn", "addr", c.RemoteAddr(), "err", err)
+			reason := DropWriteFailed
+			if errors.Is(err, ErrWriteQueueFull) {
+				reason = DropQueueFull
+			}
+			wc.metrics.MessageDropped("", reason)
+			c.Close()
+			cancel()
+		},
+		dropped: func() {
+			wc.metrics.MessageDropped("", DropQueueFull)
+		},
+	}
+	ws.queue = q
+	go q.run(ws)
+	return ws
+}
+
+// push queues the message m. A message overflowing the queue is dropped, or closes the connection, according to
+// the policy of the queue.
+func (q *writeQueue) push(m queuedMessage) error {
+	select {
+	case <-q.done:
+		return errConnClosed
+	default:
+	}
+	select {
+	case q.messages <- m:
+		return nil
+	default:
+	}
+	if q.policy == DropWhenFull {
+		q.dropped()
+		return nil
+	}
+	q.fail(ErrWriteQueueFull)
+	return nil
+}
+
+// close stops the writer once the queued messages and the close frame, if any, are written.
+func (q *writeQueue) close(frame []byte) {
+	q.closeOnce.Do(func() {
+		q.closing <- frame
+	})
+}
+
+func (q *writeQueue) fail(err error) {
+	q.closeOnce.Do(func() {
+		q.failed(err)
+		close(q.closing)
+	})
+}
+
+// run writes the queued messages to ws until the queue is closed.
+func (q *writeQueue) run(ws wsConn) {
+	defer close(q.done)
+	for {
+		select {
+		case m := <-q.messages:
+			if err := ws.write(m); err != nil {
+				q.fail(err)
+				return
+			}
+		case frame, ok := <-q.closing:
+			if !ok {
+				return
+			}
+		drain:
+			for {
+				select {
+				case m := <-q.messages:
+					if err := ws.write(m); err != nil {
+						ws.Conn.Close()
+						return
+					}
+				default:
+					break drain
+				}
+			}
+			if frame != nil {
+				_ = ws.WriteControl(websocket.CloseMessage, frame, time.Now().Add(time.Second))
+			}
+			ws.Conn.Close()
+			return
+		}
+	}
+}
+
+// write writes the queued message m to ws.
+func (w wsConn) write(m queuedMessage) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	w.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if m.prepared != nil {
+		return w.WritePreparedMessage(m.prepared)
+	}
+	return w.WriteMessage(websocket.TextMessage, m.data)
+}